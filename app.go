@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+	wunderlist "github.com/robdimsdale/wl"
+	"github.com/robdimsdale/wl/logger"
+	"github.com/robdimsdale/wl/oauth"
+)
+
+// BoardService is the Trello API as miriam uses it. Everything is addressed
+// by ID so implementations don't need to hand out live trello objects.
+type BoardService interface {
+	GetMember(memberID string) (*trello.Member, error)
+	GetBoard(boardID string) (*trello.Board, error)
+	GetLists(boardID string) ([]*trello.List, error)
+	GetLabels(boardID string) ([]*trello.Label, error)
+	GetListCards(listID string, args trello.Arguments) ([]*trello.Card, error)
+	GetCard(cardID string, args trello.Arguments) (*trello.Card, error)
+	GetCardActions(cardID string, args trello.Arguments) (trello.ActionCollection, error)
+	GetBoardActions(boardID string, args trello.Arguments) (trello.ActionCollection, error)
+	CreateCard(listID string, name string) (*trello.Card, error)
+	UpdateCard(cardID string, args trello.Arguments) (*trello.Card, error)
+	AddLabel(cardID string, labelID string) error
+	RemoveLabel(cardID string, labelID string) error
+	CreateChecklist(cardID string, name string) (*trello.Checklist, error)
+	DeleteChecklist(checklistID string) error
+	AddURLAttachment(cardID string, name string, url string) (*trello.Attachment, error)
+	DeleteAttachment(cardID string, attachmentID string) error
+	AddComment(cardID string, text string) (*trello.Action, error)
+	DeleteComment(actionID string) error
+	UpdateCheckItem(cardID string, itemID string, args trello.Arguments) (*trello.CheckItem, error)
+}
+
+// TaskService is the part of the Wunderlist client miriam uses, so the
+// client itself satisfies it
+type TaskService interface {
+	User() (wunderlist.User, error)
+	Inbox() (wunderlist.List, error)
+	Lists() ([]wunderlist.List, error)
+	List(listID uint) (wunderlist.List, error)
+	CreateList(title string) (wunderlist.List, error)
+	DeleteList(list wunderlist.List) error
+	Folders() ([]wunderlist.Folder, error)
+	Folder(folderID uint) (wunderlist.Folder, error)
+	CreateFolder(title string, listIDs []uint) (wunderlist.Folder, error)
+	UpdateFolder(folder wunderlist.Folder) (wunderlist.Folder, error)
+	DeleteFolder(folder wunderlist.Folder) error
+	Task(taskID uint) (wunderlist.Task, error)
+	TasksForListID(listID uint) ([]wunderlist.Task, error)
+	CompletedTasksForListID(listID uint, completed bool) ([]wunderlist.Task, error)
+	CreateTask(title string, listID uint, assigneeID uint, completed bool, recurrenceType string, recurrenceCount uint, dueDate time.Time, starred bool) (wunderlist.Task, error)
+	UpdateTask(task wunderlist.Task) (wunderlist.Task, error)
+	DeleteTask(task wunderlist.Task) error
+	TaskCommentsForListID(listID uint) ([]wunderlist.TaskComment, error)
+	TaskComment(taskCommentID uint) (wunderlist.TaskComment, error)
+	CreateTaskComment(text string, taskID uint) (wunderlist.TaskComment, error)
+	DeleteTaskComment(taskComment wunderlist.TaskComment) error
+	NotesForListID(listID uint) ([]wunderlist.Note, error)
+	Note(noteID uint) (wunderlist.Note, error)
+	CreateNote(content string, taskID uint) (wunderlist.Note, error)
+	UpdateNote(note wunderlist.Note) (wunderlist.Note, error)
+	DeleteNote(note wunderlist.Note) error
+	SubtasksForListID(listID uint) ([]wunderlist.Subtask, error)
+	CompletedSubtasksForListID(listID uint, completed bool) ([]wunderlist.Subtask, error)
+	Subtask(subtaskID uint) (wunderlist.Subtask, error)
+	CreateSubtask(title string, taskID uint, completed bool) (wunderlist.Subtask, error)
+	UpdateSubtask(subtask wunderlist.Subtask) (wunderlist.Subtask, error)
+	DeleteSubtask(subtask wunderlist.Subtask) error
+	RemindersForListID(listID uint) ([]wunderlist.Reminder, error)
+	Reminder(reminderID uint) (wunderlist.Reminder, error)
+	CreateReminder(date string, taskID uint, createdByDeviceUdid string) (wunderlist.Reminder, error)
+	DeleteReminder(reminder wunderlist.Reminder) error
+}
+
+// ChatMessage is a message someone sent in a chat channel. Adapters fill in
+// User when the message names its sender, otherwise it's looked up from
+// UserID.
+type ChatMessage struct {
+	Channel string
+	UserID  string
+	User    string
+	Text    string
+}
+
+// ChatAdapter is a chat service. It sends to any channel, an empty channel
+// being the configured one, and delivers the configured channel's messages
+// to Subscribe's handler. LookupUser turns a user ID into the username
+// chat-users lists.
+type ChatAdapter interface {
+	Send(channel string, text string) error
+	Subscribe(handle func(ChatMessage)) error
+	LookupUser(userID string) (string, error)
+}
+
+// App is one pipeline: a configuration and the services it syncs between.
+// Nothing connects until a method needs it, and two Apps share no state.
+type App struct {
+	Config *Config
+	Boards BoardService
+	Tasks  TaskService
+	// Chat is nil unless the daemon connected to a chat server
+	Chat  ChatAdapter
+	Clock Clock
+	Audit *AuditLog
+	// runs lets one run at a time change the boards, whether it was
+	// scheduled or asked for in chat
+	runs *sync.Mutex
+}
+
+// NewApp builds the Trello and Wunderlist clients for a configuration
+func NewApp(c *Config) *App {
+	trelloClient := trello.NewClient(c.TrelloKey, c.TrelloToken)
+	trelloClient.BaseURL = strings.TrimSuffix(c.TrelloURL, "/")
+	return &App{
+		Config: c,
+		Boards: &trelloBoards{trelloClient},
+		Tasks:  oauth.NewClient(c.WunderlistAccessToken, c.WunderlistClientID, strings.TrimSuffix(c.WunderlistURL, "/"), logger.NewLogger(logger.INFO)),
+		Clock:  systemClock{},
+		Audit:  &AuditLog{Path: c.AuditLog, Clock: systemClock{}},
+		runs:   &sync.Mutex{},
+	}
+}
+
+// SetClock replaces the clock everywhere the App reads the time
+func (a *App) SetClock(clock Clock) {
+	a.Clock = clock
+	a.Audit.Clock = clock
+}
+
+// trelloBoards is the BoardService backed by the Trello API
+type trelloBoards struct {
+	client *trello.Client
+}
+
+func (t *trelloBoards) GetMember(memberID string) (*trello.Member, error) {
+	return t.client.GetMember(memberID, trello.Defaults())
+}
+
+func (t *trelloBoards) GetBoard(boardID string) (*trello.Board, error) {
+	return t.client.GetBoard(boardID, trello.Defaults())
+}
+
+func (t *trelloBoards) GetLists(boardID string) ([]*trello.List, error) {
+	var lists []*trello.List
+	err := t.client.Get(fmt.Sprintf("boards/%s/lists", boardID), trello.Defaults(), &lists)
+	return lists, err
+}
+
+func (t *trelloBoards) GetLabels(boardID string) ([]*trello.Label, error) {
+	var labels []*trello.Label
+	err := t.client.Get(fmt.Sprintf("boards/%s/labels", boardID), trello.Defaults(), &labels)
+	return labels, err
+}
+
+func (t *trelloBoards) GetListCards(listID string, args trello.Arguments) ([]*trello.Card, error) {
+	var cards []*trello.Card
+	err := t.client.Get(fmt.Sprintf("lists/%s/cards", listID), args, &cards)
+	return cards, err
+}
+
+func (t *trelloBoards) GetCard(cardID string, args trello.Arguments) (*trello.Card, error) {
+	return t.client.GetCard(cardID, args)
+}
+
+func (t *trelloBoards) GetCardActions(cardID string, args trello.Arguments) (trello.ActionCollection, error) {
+	var actions trello.ActionCollection
+	err := t.client.Get(fmt.Sprintf("cards/%s/actions", cardID), args, &actions)
+	return actions, err
+}
+
+func (t *trelloBoards) GetBoardActions(boardID string, args trello.Arguments) (trello.ActionCollection, error) {
+	var actions trello.ActionCollection
+	err := t.client.Get(fmt.Sprintf("boards/%s/actions", boardID), args, &actions)
+	return actions, err
+}
+
+func (t *trelloBoards) CreateCard(listID string, name string) (*trello.Card, error) {
+	var card trello.Card
+	err := t.client.Post("cards", trello.Arguments{"idList": listID, "name": name}, &card)
+	return &card, err
+}
+
+func (t *trelloBoards) UpdateCard(cardID string, args trello.Arguments) (*trello.Card, error) {
+	var card trello.Card
+	err := t.client.Put(fmt.Sprintf("cards/%s", cardID), args, &card)
+	return &card, err
+}
+
+func (t *trelloBoards) AddLabel(cardID string, labelID string) error {
+	var labels []string
+	return t.client.Post(fmt.Sprintf("cards/%s/idLabels", cardID), trello.Arguments{"value": labelID}, &labels)
+}
+
+func (t *trelloBoards) RemoveLabel(cardID string, labelID string) error {
+	var result map[string]interface{}
+	return t.client.Delete(fmt.Sprintf("cards/%s/idLabels/%s", cardID, labelID), trello.Arguments{}, &result)
+}
+
+func (t *trelloBoards) CreateChecklist(cardID string, name string) (*trello.Checklist, error) {
+	var checklist trello.Checklist
+	err := t.client.Post(fmt.Sprintf("cards/%s/checklists", cardID), trello.Arguments{"name": name}, &checklist)
+	return &checklist, err
+}
+
+func (t *trelloBoards) DeleteChecklist(checklistID string) error {
+	var result map[string]interface{}
+	return t.client.Delete(fmt.Sprintf("checklists/%s", checklistID), trello.Arguments{}, &result)
+}
+
+// AddURLAttachment is trello's Card.AddURLAttachment, by card ID
+func (t *trelloBoards) AddURLAttachment(cardID string, name string, url string) (*trello.Attachment, error) {
+	var attachment trello.Attachment
+	err := t.client.Post(fmt.Sprintf("cards/%s/attachments", cardID), trello.Arguments{"url": url, "name": name}, &attachment)
+	return &attachment, err
+}
+
+func (t *trelloBoards) DeleteAttachment(cardID string, attachmentID string) error {
+	var result map[string]interface{}
+	return t.client.Delete(fmt.Sprintf("cards/%s/attachments/%s", cardID, attachmentID), trello.Arguments{}, &result)
+}
+
+// AddComment is trello's Card.AddComment, by card ID
+func (t *trelloBoards) AddComment(cardID string, text string) (*trello.Action, error) {
+	var action trello.Action
+	err := t.client.Post(fmt.Sprintf("cards/%s/actions/comments", cardID), trello.Arguments{"text": text}, &action)
+	return &action, err
+}
+
+func (t *trelloBoards) DeleteComment(actionID string) error {
+	var result map[string]interface{}
+	return t.client.Delete(fmt.Sprintf("actions/%s", actionID), trello.Arguments{}, &result)
+}
+
+func (t *trelloBoards) UpdateCheckItem(cardID string, itemID string, args trello.Arguments) (*trello.CheckItem, error) {
+	var item trello.CheckItem
+	err := t.client.Put(fmt.Sprintf("cards/%s/checkItem/%s", cardID, itemID), args, &item)
+	return &item, err
+}
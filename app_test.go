@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// fakeChat records what was sent and hands messages to the listener
+type fakeChat struct {
+	mu   sync.Mutex
+	Sent []string
+	// SentTo is what was sent by channel, "" being the configured one
+	SentTo map[string][]string
+	handle func(ChatMessage)
+}
+
+func (f *fakeChat) Send(channel string, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.SentTo == nil {
+		f.SentTo = map[string][]string{}
+	}
+	f.Sent = append(f.Sent, text)
+	f.SentTo[channel] = append(f.SentTo[channel], text)
+	return nil
+}
+
+func (f *fakeChat) Subscribe(handle func(ChatMessage)) error {
+	f.handle = handle
+	return nil
+}
+
+// LookupUser knows every user, their ID is their name
+func (f *fakeChat) LookupUser(userID string) (string, error) {
+	return userID, nil
+}
+
+// Say delivers a message as if user had typed it in the channel
+func (f *fakeChat) Say(user, text string) {
+	f.handle(ChatMessage{UserID: user, Text: text})
+}
+
+func TestAppsAreIndependent(t *testing.T) {
+	first := newFakeWorld(t)
+	defer first.Close()
+	second := newFakeWorld(t)
+	defer second.Close()
+	firstCard := first.Trello.AddCard(first.Someday, "Learn to juggle")
+	secondCard := second.Trello.AddCard(second.Someday, "Run a marathon", "Planned")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, w := range []*fakeWorld{first, second} {
+		wg.Add(1)
+		go func(i int, w *fakeWorld) {
+			defer wg.Done()
+			errs[i] = w.App.runJobs("label-hygiene", "goal-promotion")
+		}(i, w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectStrings(t, "first card labels", first.Trello.LabelsOf(firstCard), "Needs success criteria", "Needs tasks")
+	if list := second.Trello.ListOf(secondCard); list != "In Progress" {
+		t.Errorf("expected the planned card to be promoted in the second world, got %v", list)
+	}
+	if list := first.Trello.ListOf(firstCard); list != "Someday" {
+		t.Errorf("expected the unplanned card to stay in Someday, got %v", list)
+	}
+	if tasks := first.Wunderlist.Find("Start working on a new goal"); len(tasks) != 1 {
+		t.Errorf("expected the first world to ask for a new goal, got %v", tasks)
+	}
+	if tasks := second.Wunderlist.Find("Start working on a new goal"); len(tasks) != 0 {
+		t.Errorf("expected the second world to have a goal, got %v", tasks)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Audit operations, one for every kind of write miriam makes
+const (
+	OpCreateChecklist   = "create-checklist"
+	OpDeleteChecklist   = "delete-checklist"
+	OpAddLabel          = "add-label"
+	OpRemoveLabel       = "remove-label"
+	OpMoveCardToList    = "move-card-to-list"
+	OpMoveCardToBoard   = "move-card-to-board"
+	OpMoveCheckItem     = "move-check-item"
+	OpMarkCheckItem     = "mark-check-item"
+	OpUpdateCard        = "update-card"
+	OpCreateCard        = "create-card"
+	OpArchiveCard       = "archive-card"
+	OpAddAttachment     = "add-attachment"
+	OpDeleteAttachment  = "delete-attachment"
+	OpAddComment        = "add-comment"
+	OpDeleteComment     = "delete-comment"
+	OpCreateList        = "create-list"
+	OpDeleteList        = "delete-list"
+	OpCreateFolder      = "create-folder"
+	OpUpdateFolder      = "update-folder"
+	OpDeleteFolder      = "delete-folder"
+	OpCreateTask        = "create-task"
+	OpUpdateTask        = "update-task"
+	OpDeleteTask        = "delete-task"
+	OpCreateTaskComment = "create-task-comment"
+	OpDeleteTaskComment = "delete-task-comment"
+	OpCreateNote        = "create-note"
+	OpUpdateNote        = "update-note"
+	OpDeleteNote        = "delete-note"
+	OpCreateSubtask     = "create-subtask"
+	OpUpdateSubtask     = "update-subtask"
+	OpDeleteSubtask     = "delete-subtask"
+	OpCreateReminder    = "create-reminder"
+	OpDeleteReminder    = "delete-reminder"
+)
+
+// AuditTarget identifies the objects touched by a mutation
+type AuditTarget struct {
+	CardID        string `json:"card_id,omitempty"`
+	CardName      string `json:"card_name,omitempty"`
+	BoardID       string `json:"board_id,omitempty"`
+	ListID        string `json:"list_id,omitempty"`
+	ChecklistID   string `json:"checklist_id,omitempty"`
+	CheckItemID   string `json:"check_item_id,omitempty"`
+	LabelID       string `json:"label_id,omitempty"`
+	LabelName     string `json:"label_name,omitempty"`
+	AttachmentID  string `json:"attachment_id,omitempty"`
+	CommentID     string `json:"comment_id,omitempty"`
+	TaskListID    uint   `json:"task_list_id,omitempty"`
+	FolderID      uint   `json:"folder_id,omitempty"`
+	TaskID        uint   `json:"task_id,omitempty"`
+	TaskCommentID uint   `json:"task_comment_id,omitempty"`
+	NoteID        uint   `json:"note_id,omitempty"`
+	SubtaskID     uint   `json:"subtask_id,omitempty"`
+	ReminderID    uint   `json:"reminder_id,omitempty"`
+}
+
+// AuditEntry is a single line in the audit log
+type AuditEntry struct {
+	Time   time.Time       `json:"time"`
+	RunID  string          `json:"run_id"`
+	Rule   string          `json:"rule"`
+	Op     string          `json:"op"`
+	Target AuditTarget     `json:"target"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditLog appends entries to a JSONL file
+type AuditLog struct {
+	Path  string
+	RunID string
+	// Clock stamps the entries, the system clock when nil
+	Clock Clock
+	mu    sync.Mutex
+}
+
+func (a *AuditLog) now() time.Time {
+	if a.Clock == nil {
+		return time.Now()
+	}
+	return a.Clock.Now()
+}
+
+func newRunID(now time.Time) string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return now.Format("20060102T150405")
+	}
+	return fmt.Sprintf("%s-%s", now.Format("20060102T150405"), hex.EncodeToString(b))
+}
+
+// StartRun assigns a new run ID to all following entries
+func (a *AuditLog) StartRun() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.RunID = newRunID(a.now())
+	return a.RunID
+}
+
+func auditValue(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// Record writes a mutation to the audit log. The file is synced after every
+// entry so nothing is lost if miriam dies mid-run.
+func (a *AuditLog) Record(rule string, op string, target AuditTarget, before interface{}, after interface{}) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Path == "" {
+		return nil
+	}
+	entry := AuditEntry{
+		Time:   a.now(),
+		RunID:  a.RunID,
+		Rule:   rule,
+		Op:     op,
+		Target: target,
+		Before: auditValue(before),
+		After:  auditValue(after),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "Error encoding audit entry for %s", op)
+	}
+	f, err := os.OpenFile(a.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening audit log %s", a.Path)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return errors.Wrapf(err, "Error writing audit log %s", a.Path)
+	}
+	return f.Sync()
+}
+
+// record is Record with the error logged instead of returned, the mutation
+// has already happened at this point so there is nothing to roll back
+func (a *AuditLog) record(rule string, op string, target AuditTarget, before interface{}, after interface{}) {
+	if err := a.Record(rule, op, target, before, after); err != nil {
+		log.Println(err)
+	}
+}
+
+// ReadAuditLog loads every entry from a JSONL audit log
+func ReadAuditLog(path string) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening audit log %s", path)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Wrapf(err, "Error parsing audit log %s line %d", path, line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
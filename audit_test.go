@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLogRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	audit := &AuditLog{Path: filepath.Join(dir, "audit.jsonl")}
+	runID := audit.StartRun()
+	if err := audit.Record("label-hygiene", OpAddLabel, AuditTarget{CardID: "card1", LabelID: "label1"}, nil, map[string]string{"name": "Needs tasks"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.Record("planned-move", OpMoveCardToBoard, AuditTarget{CardID: "card1", BoardID: "goals"}, map[string]string{"idBoard": "backlog"}, map[string]string{"idBoard": "goals"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadAuditLog(audit.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].RunID != runID || entries[1].RunID != runID {
+		t.Errorf("expected run ID %s on every entry", runID)
+	}
+	if entries[1].Op != OpMoveCardToBoard || string(entries[1].Before) != `{"idBoard":"backlog"}` {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+	if entries[0].Before != nil {
+		t.Errorf("expected no before value for %s, got %s", entries[0].Op, entries[0].Before)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// burndownPath is where the health check server serves burndown charts, as
+// burndownPath + card ID + ".svg"
+const burndownPath = "/burndown/"
+
+// burndownAttachment is the name of the chart's attachment on a card
+const burndownAttachment = "Burndown"
+
+// burndownHistory is the number of open Tasks and Backlog items of a goal
+// over time
+type burndownHistory struct {
+	Name     string          `json:"name"`
+	ShortURL string          `json:"short_url"`
+	Due      time.Time       `json:"due"`
+	Points   []burndownPoint `json:"points"`
+}
+
+type burndownPoint struct {
+	Time time.Time `json:"time"`
+	Open int       `json:"open"`
+}
+
+// record adds a point. A run that finds the same count as the last two
+// points moves the last one instead, so the history only grows when the
+// count changes.
+func (h *burndownHistory) record(now time.Time, open int) {
+	n := len(h.Points)
+	if n >= 2 && h.Points[n-1].Open == open && h.Points[n-2].Open == open {
+		h.Points[n-1].Time = now
+		return
+	}
+	h.Points = append(h.Points, burndownPoint{Time: now, Open: open})
+}
+
+// openItems is the number of unchecked Tasks and Backlog items on a goal
+func openItems(c *Config, card *Card) int {
+	_, tasksUnchecked := card.Items(c.TasksChecklist)
+	_, backlogUnchecked := card.Items(c.BacklogChecklist)
+	return len(tasksUnchecked) + len(backlogUnchecked)
+}
+
+// burndownURL is where a goal's chart is served, empty without public-url
+func burndownURL(c *Config, card *Card) string {
+	if c.PublicURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v%v%v.svg", strings.TrimSuffix(c.PublicURL, "/"), burndownPath, card.ID)
+}
+
+// recordBurndown adds a point for every goal in progress. Goals that have
+// left In Progress get one last point if their count changed, so a finished
+// goal burns down to zero.
+func (a *App) recordBurndown(s *Snapshot) error {
+	c := a.Config
+	if c.BurndownFile == "" {
+		return nil
+	}
+	histories, err := a.readBurndown()
+	if err != nil {
+		return err
+	}
+	inProgress := s.Goals.ListByName(c.InProgressList)
+	for _, card := range s.Goals.Cards {
+		open := openItems(c, card)
+		h := histories[card.ID]
+		if inProgress == nil || card.ListID != inProgress.ID {
+			if h != nil && h.Points[len(h.Points)-1].Open != open {
+				h.Points = append(h.Points, burndownPoint{Time: s.Now, Open: open})
+			}
+			continue
+		}
+		if h == nil {
+			h = &burndownHistory{}
+			histories[card.ID] = h
+		}
+		h.Name, h.ShortURL, h.Due = card.Name, card.ShortURL, card.Due
+		h.record(s.Now, open)
+	}
+	return a.writeBurndown(histories)
+}
+
+func (a *App) readBurndown() (map[string]*burndownHistory, error) {
+	histories := map[string]*burndownHistory{}
+	if a.Config.BurndownFile == "" {
+		return histories, nil
+	}
+	data, err := ioutil.ReadFile(a.Config.BurndownFile)
+	if os.IsNotExist(err) {
+		return histories, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &histories); err != nil {
+		return nil, errors.Wrapf(err, "Error reading %s", a.Config.BurndownFile)
+	}
+	return histories, nil
+}
+
+// writeBurndown replaces the file in one step, so charts being served never
+// see half of it
+func (a *App) writeBurndown(histories map[string]*burndownHistory) error {
+	data, err := json.MarshalIndent(histories, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := a.Config.BurndownFile
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// burndownSVG draws the open items as a step line, with a dashed line from
+// the first point to zero on the due date when the goal has one
+func burndownSVG(h *burndownHistory, location *time.Location) string {
+	const width, height, left, right, top, bottom = 600, 300, 50, 20, 40, 40
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n", width, height, width, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(&b, `<text x="%d" y="24" font-size="16">%v</text>`+"\n", left, html.EscapeString(h.Name))
+	if len(h.Points) == 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d">No history yet</text>`+"\n</svg>\n", left, height/2)
+		return b.String()
+	}
+
+	start, end := h.Points[0].Time, h.Points[len(h.Points)-1].Time
+	if h.Due.After(end) {
+		end = h.Due
+	}
+	if !end.After(start) {
+		end = start.Add(24 * time.Hour)
+	}
+	most := 1
+	for _, point := range h.Points {
+		if point.Open > most {
+			most = point.Open
+		}
+	}
+	x := func(t time.Time) float64 {
+		return left + float64(width-left-right)*float64(t.Sub(start))/float64(end.Sub(start))
+	}
+	y := func(open int) float64 {
+		return top + float64(height-top-bottom)*float64(most-open)/float64(most)
+	}
+
+	// Axes, with the count on the left and the dates along the bottom
+	fmt.Fprintf(&b, `<path d="M%d %d V%d H%d" fill="none" stroke="black"/>`+"\n", left, top, height-bottom, width-right)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%d</text>`+"\n", left-6, y(most)+4, most)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">0</text>`+"\n", left-6, y(0)+4)
+	fmt.Fprintf(&b, `<text x="%d" y="%d">%v</text>`+"\n", left, height-bottom+18, start.In(location).Format("2 Jan"))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%v</text>`+"\n", width-right, height-bottom+18, end.In(location).Format("2 Jan"))
+
+	if !h.Due.IsZero() && h.Due.After(start) {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="gray" stroke-dasharray="4 4"/>`+"\n", x(start), y(h.Points[0].Open), x(h.Due), y(0))
+	}
+	path := fmt.Sprintf("M%.1f %.1f", x(start), y(h.Points[0].Open))
+	for _, point := range h.Points[1:] {
+		path += fmt.Sprintf(" H%.1f V%.1f", x(point.Time), y(point.Open))
+	}
+	fmt.Fprintf(&b, `<path d="%v" fill="none" stroke="#0079bf" stroke-width="2"/>`+"\n", path)
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// burndownHandler serves the chart of the card in the path
+type burndownHandler func() *App
+
+func (h burndownHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a := h()
+	cardID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, burndownPath), ".svg")
+	histories, err := a.readBurndown()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	history := histories[cardID]
+	if history == nil {
+		http.NotFound(w, r)
+		return
+	}
+	location, err := a.Config.Location()
+	if err != nil {
+		location = time.Local
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, burndownSVG(history, location))
+}
+
+// chatBurndown sums up the burndown of the goals in progress matching arg,
+// every one without arg, with a link to each chart when public-url is set
+func (a *App) chatBurndown(arg string) (string, error) {
+	snapshot, err := a.loadSnapshot()
+	if err != nil {
+		return "", err
+	}
+	histories, err := a.readBurndown()
+	if err != nil {
+		return "", err
+	}
+	var goals []*Card
+	if list := snapshot.Goals.ListByName(a.Config.InProgressList); list != nil {
+		goals = snapshot.Goals.CardsIn(list.ID)
+	}
+	if arg != "" {
+		goals = matchingCards(goals, arg)
+	}
+	if len(goals) == 0 && arg == "" {
+		return fmt.Sprintf("Nothing is in %v", a.Config.InProgressList), nil
+	}
+	if len(goals) == 0 {
+		return fmt.Sprintf("No goal in %v matches '%v'", a.Config.InProgressList, arg), nil
+	}
+	var lines []string
+	for _, goal := range goals {
+		line := fmt.Sprintf("*%v*: %v open", goal.Name, openItems(a.Config, goal))
+		if h := histories[goal.ID]; h != nil && len(h.Points) > 0 {
+			line += fmt.Sprintf(", %v on %v", h.Points[0].Open, h.Points[0].Time.Format("2 Jan"))
+		}
+		if url := burndownURL(a.Config, goal); url != "" {
+			line += fmt.Sprintf(" (%v)", url)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
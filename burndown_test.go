@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBurndownSVG(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	h := &burndownHistory{Name: "Learn <Go>", Due: start.Add(4 * 24 * time.Hour)}
+	for i, open := range []int{4, 4, 4, 2, 1} {
+		h.record(start.Add(time.Duration(i)*24*time.Hour), open)
+	}
+	// The unchanged run in the middle only moved the last point
+	if len(h.Points) != 4 || !h.Points[1].Time.Equal(start.Add(2*24*time.Hour)) {
+		t.Errorf("expected the repeated count to be merged, got %v", h.Points)
+	}
+
+	svg := burndownSVG(h, time.UTC)
+	for _, expected := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg"`,
+		`>Learn &lt;Go&gt;</text>`,
+		`>4</text>`,
+		`>2 Mar</text>`,
+		`>6 Mar</text>`,
+		// From 4 open at the left edge, stepping down to 1 at the right
+		`<path d="M50.0 40.0 H315.0 V40.0 H447.5 V150.0 H580.0 V205.0"`,
+		`stroke-dasharray="4 4"`,
+		"</svg>",
+	} {
+		if !strings.Contains(svg, expected) {
+			t.Errorf("expected %q in:\n%v", expected, svg)
+		}
+	}
+	if svg := burndownSVG(&burndownHistory{Name: "Empty"}, time.UTC); !strings.Contains(svg, "No history yet") {
+		t.Errorf("expected an empty chart, got:\n%v", svg)
+	}
+}
+
+func TestBurndownScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	chat := &fakeChat{}
+	w.App.Chat = chat
+	w.App.Config.PublicURL = "https://miriam.example.com/"
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour", "Install Go")
+	w.Trello.AddChecklist(goal, "Backlog", "Write a CLI")
+	tour := w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+
+	if err := w.App.runJobs(); err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("https://miriam.example.com/burndown/%v.svg", goal.ID)
+	if len(goal.Attachments) != 1 || goal.Attachments[0].URL != url || goal.Attachments[0].Name != "Burndown" {
+		t.Errorf("expected the chart attached to the goal, got %+v", goal.Attachments)
+	}
+
+	w.Clock.Advance(24 * time.Hour)
+	w.Wunderlist.mu.Lock()
+	tour.Completed = true
+	w.Wunderlist.mu.Unlock()
+	if err := w.App.runJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(goal.Attachments) != 1 {
+		t.Errorf("expected the chart to be attached once, got %+v", goal.Attachments)
+	}
+	histories, err := w.App.readBurndown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := histories[goal.ID]; h == nil || len(h.Points) != 2 || h.Points[0].Open != 3 || h.Points[1].Open != 2 {
+		t.Fatalf("expected the goal to burn down from 3 to 2 open items, got %+v", h)
+	}
+
+	response := httptest.NewRecorder()
+	burndownHandler(func() *App { return w.App }).ServeHTTP(response, httptest.NewRequest("GET", "/burndown/"+goal.ID+".svg", nil))
+	if response.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(response.Body.String(), ">Learn Go</text>") {
+		t.Errorf("expected the goal's chart, got %v", response.Body.String())
+	}
+	response = httptest.NewRecorder()
+	burndownHandler(func() *App { return w.App }).ServeHTTP(response, httptest.NewRequest("GET", "/burndown/unknown.svg", nil))
+	if response.Code != 404 {
+		t.Errorf("expected an unknown card to be not found, got %v", response.Code)
+	}
+
+	if reply := say(t, w, chat, "burndown go"); reply != fmt.Sprintf("*Learn Go*: 2 open, 3 on 2 Mar (%v)", url) {
+		t.Errorf("unexpected reply to burndown: %q", reply)
+	}
+}
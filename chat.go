@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/RocketChat/Rocket.Chat.Go.SDK/models"
+	chat "github.com/RocketChat/Rocket.Chat.Go.SDK/realtime"
+	"github.com/pkg/errors"
+)
+
+// ChatService is the chat adapter the config asks for, empty when there is
+// no chat
+func (c *Config) ChatService() string {
+	if c.Chat == "" && c.RocketChatURL != "" {
+		return "rocketchat"
+	}
+	return c.Chat
+}
+
+// validateChat checks that the chat adapter has what it needs to connect
+func (c *Config) validateChat() []string {
+	required := map[string]map[string]string{
+		"":           {},
+		"rocketchat": {"rocketchat-url": c.RocketChatURL, "rocketchat-email": c.RocketChatEmail, "rocketchat-password": c.RocketChatPassword},
+		"slack":      {"slack-url": c.SlackURL, "slack-token": c.SlackToken, "slack-signing-secret": c.SlackSigningSecret},
+		"matrix":     {"matrix-url": c.MatrixURL, "matrix-user": c.MatrixUser, "matrix-token": c.MatrixToken},
+		"webhook":    {"webhook-url": c.WebhookURL},
+	}
+	settings, ok := required[c.ChatService()]
+	if !ok {
+		return []string{fmt.Sprintf("chat must be rocketchat, slack, matrix or webhook, not %v", c.Chat)}
+	}
+	var problems []string
+	for key, value := range settings {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%v is required for %v chat", key, c.ChatService()))
+		}
+	}
+	return problems
+}
+
+// connectChat connects the configured chat adapter. Without one there is no
+// chat and nil is returned.
+func connectChat(c *Config) (ChatAdapter, error) {
+	switch c.ChatService() {
+	case "rocketchat":
+		return connectRocketChat(c)
+	case "slack":
+		return newSlackChat(c), nil
+	case "matrix":
+		return connectMatrixChat(c)
+	case "webhook":
+		return newWebhookChat(c), nil
+	}
+	return nil, nil
+}
+
+// rocketChat is a ChatAdapter listening on one Rocket.Chat channel
+type rocketChat struct {
+	client      *chat.Client
+	channelName string
+	channel     models.Channel
+	// Channel IDs by name, for sending to other channels
+	channelIDs map[string]string
+	// Usernames by user ID, as seen on messages
+	users map[string]string
+	mu    sync.Mutex
+}
+
+// connectRocketChat logs in to Rocket.Chat over its realtime API
+func connectRocketChat(c *Config) (ChatAdapter, error) {
+	address := c.RocketChatURL
+	// The houseparty format was a bare host:port
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	serverURL, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid rocketchat-url %s", c.RocketChatURL)
+	}
+	client, err := chat.NewClient(serverURL, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not connect to %s", c.RocketChatURL)
+	}
+	if _, err := client.Login(&models.UserCredentials{Email: c.RocketChatEmail, Password: c.RocketChatPassword}); err != nil {
+		return nil, errors.Wrapf(err, "Could not log in to %s", c.RocketChatURL)
+	}
+	channelID, err := client.GetChannelId(c.ChatChannel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not find channel %s", c.ChatChannel)
+	}
+	return &rocketChat{client: client, channelName: c.ChatChannel, channel: models.Channel{ID: channelID}, channelIDs: map[string]string{c.ChatChannel: channelID}, users: map[string]string{}}, nil
+}
+
+func (r *rocketChat) Send(channel string, text string) error {
+	if channel == "" {
+		channel = r.channelName
+	}
+	r.mu.Lock()
+	channelID, ok := r.channelIDs[channel]
+	r.mu.Unlock()
+	if !ok {
+		var err error
+		if channelID, err = r.client.GetChannelId(channel); err != nil {
+			return errors.Wrapf(err, "Could not find channel %s", channel)
+		}
+		r.mu.Lock()
+		r.channelIDs[channel] = channelID
+		r.mu.Unlock()
+	}
+	_, err := r.client.SendMessage(&models.Channel{ID: channelID}, text)
+	return err
+}
+
+func (r *rocketChat) Subscribe(handle func(ChatMessage)) error {
+	messages := make(chan models.Message, 1)
+	if err := r.client.SubscribeToMessageStream(&r.channel, messages); err != nil {
+		return errors.Wrap(err, "Error subscribing to chat messages")
+	}
+	go func() {
+		for msg := range messages {
+			if msg.User == nil {
+				continue
+			}
+			r.mu.Lock()
+			r.users[msg.User.ID] = msg.User.UserName
+			r.mu.Unlock()
+			handle(ChatMessage{Channel: r.channelName, UserID: msg.User.ID, User: msg.User.UserName, Text: msg.Msg})
+		}
+	}()
+	return nil
+}
+
+// LookupUser knows the users that have posted since miriam subscribed, the
+// realtime API has no user lookup
+func (r *rocketChat) LookupUser(userID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name, ok := r.users[userID]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("Unknown Rocket.Chat user %s", userID)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// Clock tells the time. Everything that decides based on the time asks the
+// App's Clock instead of calling time.Now, so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
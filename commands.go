@@ -0,0 +1,399 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/heptiolabs/healthcheck"
+	"github.com/pkg/errors"
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: miriam [command] [flags]
+
+Commands:
+  daemon     Run forever, each job on its schedule (default)
+  run-once   Do a single run and exit, non-zero if anything failed
+  validate   Check the configuration, credentials and board structure
+  status     Print the goals in progress, active tasks and pending backlog
+  digest     Send the daily digest now, or the weekly one with --weekly
+  report     Print cycle times and weekly throughput as a table, CSV or JSON
+  undo       Revert a run from the audit log (see miriam undo -h)
+`)
+}
+
+// daemonCommand is the long-lived mode: health check, chat listener and
+// every job on its schedule
+func daemonCommand(args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.Parse(args)
+	app, err := loadApp()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Initializing...")
+	scheduler, err := NewScheduler(app.Config, app.Clock.Now())
+	if err != nil {
+		return err
+	}
+	shutdown := make(chan struct{})
+
+	if app.Chat, err = connectChat(app.Config); err != nil {
+		log.Println(err)
+	}
+	// The listener and report answer with whichever App is current after
+	// reloads
+	var live atomic.Value
+	live.Store(app)
+	current := func() *App { return live.Load().(*App) }
+	startHealthCheck(app.Config, app.Chat, reportHandler(current), burndownHandler(current))
+	if app.Chat != nil {
+		fmt.Println("Only listening for messages from", app.Config.ChatUsers)
+		err := app.Chat.Subscribe(func(msg ChatMessage) {
+			live.Load().(*App).handleChatMessage(msg)
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	fmt.Println("Initialization complete")
+
+	// First run before waiting for the schedule
+	if scheduler.Allowed(app.Clock.Now()) {
+		if err := app.run(); err != nil {
+			log.Println(err)
+		}
+	} else {
+		fmt.Println("Outside of the allowed hours, skipping the first run")
+	}
+
+	// Config changes are only applied between runs
+	watcher := newConfigWatcher(ConfigFile, ConfigPath, SecretsPath)
+	reloadTicker := time.NewTicker(reloadCheckInterval)
+
+	go func() {
+		for {
+			next := scheduler.NextDue()
+			wait := next.Sub(app.Clock.Now())
+			if next.IsZero() {
+				// No schedule can ever match, only a config change can help
+				fmt.Println("No job is scheduled to run again")
+				wait = 24 * time.Hour
+			} else {
+				fmt.Printf("Waiting until %v to run again...\n", next.In(scheduler.Location).Format("2006-01-02T15:04:05-0700"))
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				if due := scheduler.Due(app.Clock.Now()); len(due) > 0 {
+					if err := app.runDue(due); err != nil {
+						log.Println(err)
+					}
+				}
+			case <-reloadTicker.C:
+				timer.Stop()
+				if watcher.Changed() {
+					if reloaded, ok := reloadConfig(app); ok {
+						app = reloaded
+						live.Store(app)
+						if s, err := NewScheduler(app.Config, app.Clock.Now()); err != nil {
+							log.Println(err)
+						} else {
+							s.CarryOver(scheduler)
+							scheduler = s
+						}
+					}
+				}
+			case <-shutdown:
+				timer.Stop()
+				reloadTicker.Stop()
+				return
+			}
+		}
+	}()
+
+	// block forever
+	<-shutdown
+	return nil
+}
+
+// runOnceCommand does a single run, for cron jobs and CI
+func runOnceCommand(args []string) error {
+	flags := flag.NewFlagSet("run-once", flag.ExitOnError)
+	only := flags.String("jobs", "", fmt.Sprintf("Comma separated jobs to run instead of all of them (%s)", strings.Join(jobNames(), ", ")))
+	record := flags.String("record", "", "Record the run's HTTP traffic, without credentials, to this fixture file")
+	replay := flags.String("replay", "", "Serve HTTP traffic from this fixture file instead of the network")
+	dryRun := flags.Bool("dry-run", false, "Print the changes the run would make without making them")
+	flags.Parse(args)
+	if *record != "" && *replay != "" {
+		return fmt.Errorf("--record and --replay can't be used together")
+	}
+	app, err := loadApp()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range strings.Split(*only, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !containsString(jobNames(), name) {
+			return fmt.Errorf("Unknown job '%s'", name)
+		}
+		names = append(names, name)
+	}
+	if *record != "" {
+		recordHTTP(*record, app.Config)
+		defer fmt.Println("Recorded HTTP traffic to", *record)
+	}
+	if *replay != "" {
+		transport, err := replayHTTP(*replay, app.Config)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if unused := transport.Unused(); len(unused) > 0 {
+				fmt.Printf("%v recorded requests were not replayed\n", len(unused))
+			}
+		}()
+	}
+	if *dryRun {
+		return app.planJobs(names...)
+	}
+	return app.runJobs(names...)
+}
+
+// validateCommand checks everything a run depends on without changing anything
+func validateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL  %v: %v\n", name, err)
+			failed++
+			return
+		}
+		fmt.Printf("ok    %v\n", name)
+	}
+
+	app, err := loadApp()
+	check("configuration", err)
+	if err != nil {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+
+	c := app.Config
+	_, err = app.Boards.GetMember("me")
+	check("trello credentials", err)
+	_, err = app.Tasks.User()
+	check("wunderlist credentials", err)
+	_, err = app.Tasks.Inbox()
+	check("wunderlist inbox", err)
+
+	// Stale cards on either board are labelled with the label flag
+	var staleLabels []string
+	if containsString(c.StaleFlags, StaleFlagLabel) {
+		staleLabels = append(staleLabels, c.StaleLabel)
+	}
+	backlogBoard, err := app.Boards.GetBoard(c.TrelloBacklog)
+	check(fmt.Sprintf("backlog board %v", c.TrelloBacklog), err)
+	if err == nil {
+		check("backlog board labels", app.requireLabels(backlogBoard, append([]string{c.PlannedLabel, c.NeedsSuccessLabel, c.NeedsTasksLabel}, staleLabels...)...))
+	}
+	goalsBoard, err := app.Boards.GetBoard(c.TrelloGoals)
+	check(fmt.Sprintf("goals board %v", c.TrelloGoals), err)
+	if err == nil {
+		check("goals board lists", app.requireLists(goalsBoard, c.InProgressList, c.ToDoList))
+		if len(staleLabels) > 0 {
+			check("goals board labels", app.requireLabels(goalsBoard, staleLabels...))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+func (a *App) requireLists(board *trello.Board, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if a.getListByName(board, name) == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing lists %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (a *App) requireLabels(board *trello.Board, names ...string) error {
+	labels, err := a.Boards.GetLabels(board.ID)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading labels for board %s", board.ID)
+	}
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, label := range labels {
+			if label.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing labels %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// digestCommand sends a digest without waiting for its schedule
+func digestCommand(args []string) error {
+	flags := flag.NewFlagSet("digest", flag.ExitOnError)
+	weekly := flags.Bool("weekly", false, "Send the weekly digest instead of the daily one")
+	flags.Parse(args)
+	app, err := loadApp()
+	if err != nil {
+		return err
+	}
+	if app.Chat, err = connectChat(app.Config); err != nil {
+		return err
+	}
+	d := findDigest("daily-digest")
+	if *weekly {
+		d = findDigest("weekly-digest")
+	}
+	return app.sendDigest(*d)
+}
+
+// reportCommand prints the cycle time and throughput report
+func reportCommand(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	format := flags.String("format", "table", fmt.Sprintf("Output format (%s)", strings.Join(reportFormats, ", ")))
+	throughput := flags.Bool("throughput", false, "Write the weekly throughput instead of the goals as CSV")
+	flags.Parse(args)
+	if !containsString(reportFormats, *format) {
+		return fmt.Errorf("Unknown report format '%s', expected one of %s", *format, strings.Join(reportFormats, ", "))
+	}
+	app, err := loadApp()
+	if err != nil {
+		return err
+	}
+	report, err := app.loadReport()
+	if err != nil {
+		return err
+	}
+	return report.Write(os.Stdout, *format, *throughput)
+}
+
+// statusCommand prints where the pipeline stands
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.Parse(args)
+	app, err := loadApp()
+	if err != nil {
+		return err
+	}
+
+	cfg := app.Config
+	snapshot, err := app.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	var openTasks []wunderlist.Task
+	for _, task := range snapshot.Tasks {
+		if !task.Completed {
+			openTasks = append(openTasks, task)
+		}
+	}
+
+	var goals, waiting []*Card
+	if list := snapshot.Goals.ListByName(cfg.InProgressList); list != nil {
+		goals = snapshot.Goals.CardsIn(list.ID)
+	}
+	fmt.Printf("Goals in progress (%v of %v):\n", len(goals), cfg.WIPLimit)
+	for _, goal := range goals {
+		tasksChecked, tasksUnchecked := goal.Items(cfg.TasksChecklist)
+		_, backlogUnchecked := goal.Items(cfg.BacklogChecklist)
+		fmt.Printf("  * %v (%v)\n", goal.Name, goal.ShortURL)
+		fmt.Printf("      %v: %v of %v done, %v: %v open\n", cfg.TasksChecklist, len(tasksChecked), len(tasksChecked)+len(tasksUnchecked), cfg.BacklogChecklist, len(backlogUnchecked))
+		for _, task := range findExistingTasks(openTasks, goal.ShortURL, false) {
+			fmt.Printf("      - %v\n", task.Title)
+			for _, subtask := range snapshot.SubtasksOf(task.ID) {
+				if !subtask.Completed {
+					fmt.Printf("          - %v\n", subtask.Title)
+				}
+			}
+		}
+	}
+
+	if list := snapshot.Goals.ListByName(cfg.ToDoList); list != nil {
+		waiting = snapshot.Goals.CardsIn(list.ID)
+	}
+	fmt.Printf("Waiting in %v (%v):\n", cfg.ToDoList, len(waiting))
+	for _, card := range waiting {
+		fmt.Printf("  * %v (%v)\n", card.Name, card.ShortURL)
+	}
+
+	backlog := snapshot.Backlog.Cards
+	needsSuccess, needsTasks, planned := 0, 0, 0
+	for _, card := range backlog {
+		if card.HasLabel(cfg.NeedsSuccessLabel) {
+			needsSuccess++
+		}
+		if card.HasLabel(cfg.NeedsTasksLabel) {
+			needsTasks++
+		}
+		if card.HasLabel(cfg.PlannedLabel) {
+			planned++
+		}
+	}
+	fmt.Printf("Pending backlog: %v cards (%v planned, %v %v, %v %v)\n", len(backlog), planned, needsSuccess, strings.ToLower(cfg.NeedsSuccessLabel), needsTasks, strings.ToLower(cfg.NeedsTasksLabel))
+	fmt.Printf("Open tasks in inbox: %v\n", len(openTasks))
+	return nil
+}
+
+// startHealthCheck serves the liveness and readiness checks for Kubernetes,
+// the report and burndown charts, and the events of chat adapters that are
+// called back
+func startHealthCheck(c *Config, chat ChatAdapter, report http.Handler, burndown http.Handler) {
+	health := healthcheck.NewHandler()
+	// Our app is not happy if we've got more than 100 goroutines running.
+	health.AddLivenessCheck("goroutine-threshold", healthcheck.GoroutineCountCheck(100))
+	// Our app is not ready if we can't resolve the services we sync in DNS.
+	for name, address := range map[string]string{"trello-dns": c.TrelloURL, "wunderlist-dns": c.WunderlistURL} {
+		if u, err := url.Parse(address); err == nil && u.Hostname() != "" {
+			health.AddReadinessCheck(name, healthcheck.DNSResolveCheck(u.Hostname(), 5*time.Second))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", health)
+	mux.Handle(reportPath, report)
+	mux.Handle(burndownPath, burndown)
+	// Chat services that call back, like Slack's Events API, share the server
+	if events, ok := chat.(http.Handler); ok {
+		mux.Handle(chatEventsPath, events)
+	}
+	go func() {
+		if err := http.ListenAndServe(c.ListenAddress, mux); err != nil {
+			log.Println(err)
+		}
+	}()
+}
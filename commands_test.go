@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs a command against the fake world's configuration, returning
+// what it printed
+func runCommand(t *testing.T, w *fakeWorld, command func([]string) error, args ...string) (string, error) {
+	oldFile, oldPath, oldSecrets := ConfigFile, ConfigPath, SecretsPath
+	defer func() { ConfigFile, ConfigPath, SecretsPath = oldFile, oldPath, oldSecrets }()
+	ConfigFile, ConfigPath, SecretsPath = filepath.Join(w.dir, "miriam.yaml"), "", ""
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, reader)
+		output <- buf.String()
+	}()
+	err = command(args)
+	os.Stdout = stdout
+	writer.Close()
+	return <-output, err
+}
+
+func expectOutput(t *testing.T, output string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, output)
+		}
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+
+	output, err := runCommand(t, w, validateCommand)
+	if err != nil {
+		t.Errorf("expected every check to pass, got %v", err)
+	}
+	expectOutput(t, output, "ok    configuration", "ok    trello credentials", "ok    wunderlist inbox", "ok    backlog board labels", "ok    goals board lists", "ok    goals board labels")
+
+	w.Wunderlist.Fail["GET /user"] = http.StatusUnauthorized
+	output, err = runCommand(t, w, validateCommand)
+	if err == nil || err.Error() != "1 checks failed" {
+		t.Errorf("expected one failed check, got %v", err)
+	}
+	expectOutput(t, output, "FAIL  wunderlist credentials", "ok    goals board lists")
+}
+
+func TestValidateCommandRequiresTheStaleLabel(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	board := w.Trello.AddBoard("Ideas")
+	w.Trello.AddList(board, "Someday")
+	for _, label := range []string{"Planned", "Needs success criteria", "Needs tasks"} {
+		w.Trello.AddLabel(board, label)
+	}
+	config, err := ioutil.ReadFile(filepath.Join(w.dir, "miriam.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeConfigFiles(t, w.dir, map[string]string{"miriam.yaml": strings.Replace(string(config), w.Backlog.ID, board.ID, 1)})
+
+	output, err := runCommand(t, w, validateCommand)
+	if err == nil {
+		t.Error("expected a missing Stale label to fail validation")
+	}
+	expectOutput(t, output, "FAIL  backlog board labels: missing labels Stale")
+
+	// Without the label flag the label isn't needed
+	writeConfigFiles(t, w.dir, map[string]string{"miriam.yaml": strings.Replace(string(config), w.Backlog.ID, board.ID, 1) + "stale-flags: task\n"})
+	if output, err := runCommand(t, w, validateCommand); err != nil {
+		t.Errorf("expected validation to pass, got %v:\n%s", err, output)
+	}
+}
+
+func TestValidateCommandReportsConfigurationErrors(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	writeConfigFiles(t, w.dir, map[string]string{"miriam.yaml": "interval: soon\n"})
+
+	output, err := runCommand(t, w, validateCommand)
+	if err == nil {
+		t.Error("expected an invalid configuration to fail validation")
+	}
+	expectOutput(t, output, "FAIL  configuration: ", "interval must be a duration")
+	if strings.Contains(output, "ok    ") {
+		t.Errorf("expected no checks to run without a configuration, got:\n%s", output)
+	}
+}
+
+func TestRunOnceCommand(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.ToDo, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+
+	if _, err := runCommand(t, w, runOnceCommand, "--jobs", "goal-promotion, task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	if list := w.Trello.ListOf(goal); list != "In Progress" {
+		t.Errorf("expected the goal in In Progress, got %v", list)
+	}
+	if tasks := w.Wunderlist.Find("Read the tour"); len(tasks) != 1 {
+		t.Errorf("expected a task for the goal, got %v", tasks)
+	}
+
+	if _, err := runCommand(t, w, runOnceCommand, "--jobs", "juggling"); err == nil || !strings.Contains(err.Error(), "Unknown job 'juggling'") {
+		t.Errorf("expected an unknown job to be rejected, got %v", err)
+	}
+
+	// A failed change makes the run exit non-zero
+	done := w.Trello.AddCard(w.InProgress, "Learn Rust")
+	w.Trello.AddChecklist(done, "Tasks", "[x] Read the book")
+	task := w.Wunderlist.AddTask(fmt.Sprintf("Read the book (%s)", done.ShortUrl), false)
+	w.Wunderlist.Fail[fmt.Sprintf("PATCH /tasks/%d", task.ID)] = http.StatusInternalServerError
+	if _, err := runCommand(t, w, runOnceCommand, "--jobs", "task-sync"); err == nil {
+		t.Error("expected the failed update to fail the run")
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Write a test", "Read the tour")
+	w.Trello.AddChecklist(goal, "Backlog", "Build a CLI")
+	w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+	waiting := w.Trello.AddCard(w.ToDo, "Run a marathon")
+	w.Trello.AddCard(w.Someday, "Write a novel", "Planned")
+	w.Trello.AddCard(w.Someday, "Learn to juggle", "Needs tasks")
+
+	output, err := runCommand(t, w, statusCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOutput(t, output,
+		"Goals in progress (1 of 1):",
+		fmt.Sprintf("  * Learn Go (%s)", goal.ShortUrl),
+		"      Tasks: 1 of 2 done, Backlog: 1 open",
+		"      - Read the tour",
+		"Waiting in To Do (1):",
+		fmt.Sprintf("  * Run a marathon (%s)", waiting.ShortUrl),
+		"Pending backlog: 2 cards (1 planned, 0 needs success criteria, 1 needs tasks)",
+		"Open tasks in inbox: 1",
+	)
+
+	w.Trello.Fail[fmt.Sprintf("GET /boards/%s", w.Goals.ID)] = http.StatusInternalServerError
+	if _, err := runCommand(t, w, statusCommand); err == nil {
+		t.Error("expected status to fail without the goals board")
+	}
+}
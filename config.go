@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Config is miriam's typed configuration. Every field is named by its
+// `config` tag, which is the key in the YAML file, the file name in the
+// per-file layout and (upper-cased with a MIRIAM_ prefix) the environment
+// variable that overrides it.
+type Config struct {
+	Interval       time.Duration `config:"interval" default:"5m"`
+	TrelloBacklog  string        `config:"trello-backlog" required:"true"`
+	TrelloGoals    string        `config:"trello-goals" required:"true"`
+	TodoistProject string        `config:"todoist-project"`
+	AuditLog       string        `config:"audit-log" default:"audit.jsonl"`
+	TrelloURL      string        `config:"trello-url" default:"https://api.trello.com/1"`
+	WunderlistURL  string        `config:"wunderlist-url" default:"https://a.wunderlist.com/api/v1"`
+
+	// Lists
+	InProgressList   string   `config:"in-progress-list" default:"In Progress"`
+	ToDoList         string   `config:"to-do-list" default:"To Do"`
+	DoneList         string   `config:"done-list" default:"Done"`
+	IdeasList        string   `config:"ideas-list" default:"Ideas"`
+	BacklogExcludes  []string `config:"backlog-excludes" default:"Ideas,Needs research"`
+	WIPLimit         int      `config:"wip-limit" default:"1"`
+	SuccessChecklist string   `config:"success-checklist" default:"Success Criteria"`
+	TasksChecklist   string   `config:"tasks-checklist" default:"Tasks"`
+	BacklogChecklist string   `config:"backlog-checklist" default:"Backlog"`
+	// task-mode is tasks for an inbox task per Tasks item, or subtasks for
+	// one task per goal with the items as its subtasks
+	TaskMode string `config:"task-mode" default:"tasks"`
+	// task-notes copies each goal's description, success criteria and links
+	// into the notes of its open tasks
+	TaskNotes bool `config:"task-notes" default:"true"`
+	// comment-sync copies comments between goals in progress and their tasks
+	CommentSync bool `config:"comment-sync" default:"true"`
+	// goal-lists puts each goal's tasks in a list of its own, in
+	// goals-folder, instead of the inbox. A finished goal's list moves to
+	// goal-list-archive, or is deleted when that's empty.
+	GoalLists       bool   `config:"goal-lists"`
+	GoalsFolder     string `config:"goals-folder" default:"Goals"`
+	GoalListArchive string `config:"goal-list-archive" default:"Goals Archive"`
+
+	// Labels
+	PlannedLabel      string `config:"planned-label" default:"Planned"`
+	NeedsSuccessLabel string `config:"needs-success-label" default:"Needs success criteria"`
+	NeedsTasksLabel   string `config:"needs-tasks-label" default:"Needs tasks"`
+	StaleLabel        string `config:"stale-label" default:"Stale"`
+
+	// Stale detection. stale-lists sets how long a card may stay in a list,
+	// as "List=14d". Backlog cards in other lists are stale after
+	// stale-backlog without changes, open tasks stale-tasks after they were
+	// created. 0 turns a check off. Stale cards are flagged with the stale
+	// label and/or a review task, stale tasks are starred.
+	StaleLists   []string      `config:"stale-lists" default:"In Progress=14d"`
+	StaleBacklog time.Duration `config:"stale-backlog" default:"90d"`
+	StaleTasks   time.Duration `config:"stale-tasks" default:"30d"`
+	StaleFlags   []string      `config:"stale-flags" default:"label"`
+
+	// Reminders on open tasks. "due=1d@09:00" reminds at 09:00 a day before
+	// a task is due, "open=3d@09:00" at 09:00 once it has been open 3 days.
+	// The time of day defaults to 09:00. A task that has a reminder keeps
+	// it, and completed tasks lose theirs.
+	Reminders []string `config:"reminders"`
+
+	// Scheduling, jobs without a schedule run every interval
+	Timezone               string `config:"timezone"`
+	QuietHours             string `config:"quiet-hours"`
+	WeekdayHours           string `config:"weekday-hours"`
+	WeekendHours           string `config:"weekend-hours"`
+	ScheduleLabelHygiene   string `config:"schedule-label-hygiene"`
+	ScheduleGoalPromotion  string `config:"schedule-goal-promotion"`
+	ScheduleTaskSync       string `config:"schedule-task-sync"`
+	ScheduleCommentSync    string `config:"schedule-comment-sync"`
+	ScheduleGoalCompletion string `config:"schedule-goal-completion"`
+	ScheduleStaleDetection string `config:"schedule-stale-detection"`
+	ScheduleBurndown       string `config:"schedule-burndown"`
+	ScheduleReminders      string `config:"schedule-reminders"`
+	// Digests are only sent when they have a schedule
+	ScheduleDailyDigest  string `config:"schedule-daily-digest"`
+	ScheduleWeeklyDigest string `config:"schedule-weekly-digest"`
+
+	// Digests are written to digest-dir, sent to chat and emailed to
+	// digest-email when it's set
+	DigestDir   string   `config:"digest-dir" default:"digests"`
+	DigestEmail []string `config:"digest-email"`
+	SMTPAddress string   `config:"smtp-address"`
+	SMTPFrom    string   `config:"smtp-from"`
+
+	// Chat and the health check server, the daemon works without chat.
+	// chat is rocketchat, slack, matrix or webhook, and rocketchat when only
+	// rocketchat-url is set.
+	Chat            string   `config:"chat"`
+	RocketChatURL   string   `config:"rocketchat-url"`
+	RocketChatEmail string   `config:"rocketchat-email"`
+	SlackURL        string   `config:"slack-url" default:"https://slack.com/api"`
+	MatrixURL       string   `config:"matrix-url"`
+	MatrixUser      string   `config:"matrix-user"`
+	WebhookURL      string   `config:"webhook-url"`
+	ChatChannel     string   `config:"chat-channel" default:"house-party"`
+	ChatUsers       []string `config:"chat-users" default:"matt"`
+	ListenAddress   string   `config:"listen-address" default:"0.0.0.0:8086"`
+
+	// Every run records the open items of the goals in progress in
+	// burndown-file. When public-url, the address listen-address is reached
+	// at, is set, the charts are attached to the cards.
+	BurndownFile string `config:"burndown-file" default:"burndown.json"`
+	PublicURL    string `config:"public-url"`
+
+	// Notifications go to chat-channel unless notify-channel is set
+	NotifyChannel string   `config:"notify-channel"`
+	NotifyEvents  []string `config:"notify-events" default:"goal-planned,goal-started,goal-completed,new-goal-needed,backlog-promoted,needs-success-criteria,stale,run-failed"`
+
+	// Secrets
+	TrelloKey             string `config:"trello-key" secret:"true" required:"true"`
+	TrelloToken           string `config:"trello-token" secret:"true" required:"true"`
+	WunderlistAccessToken string `config:"wunderlist-access-token" secret:"true" required:"true"`
+	WunderlistClientID    string `config:"wunderlist-client-id" secret:"true" required:"true"`
+	RocketChatPassword    string `config:"rocketchat-password" secret:"true"`
+	SlackToken            string `config:"slack-token" secret:"true"`
+	SlackSigningSecret    string `config:"slack-signing-secret" secret:"true"`
+	MatrixToken           string `config:"matrix-token" secret:"true"`
+	SMTPUsername          string `config:"smtp-username" secret:"true"`
+	SMTPPassword          string `config:"smtp-password" secret:"true"`
+}
+
+// ConfigPath and SecretsPath hold the one-file-per-key configuration
+var (
+	ConfigPath  string
+	SecretsPath string
+)
+
+// ConfigFile is the single-file configuration, YAML or, named *.toml, TOML
+var ConfigFile string
+
+func getEnv(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func configEnvName(key string) string {
+	return "MIRIAM_" + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+func setConfigField(field reflect.Value, key string, value string) error {
+	value = strings.TrimSpace(value)
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Wrapf(err, "%s must be a number", key)
+		}
+		field.SetInt(int64(i))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrapf(err, "%s must be true or false", key)
+		}
+		field.SetBool(b)
+	case time.Duration:
+		// Plain numbers are seconds, like the old interval file
+		if seconds, err := strconv.Atoi(value); err == nil {
+			field.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := parseDuration(value)
+		if err != nil {
+			return errors.Wrapf(err, "%s must be a duration like 5m", key)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+			if item = unquote(strings.TrimSpace(item)); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s has an unsupported type %s", key, field.Type())
+	}
+	return nil
+}
+
+// parseDuration is time.ParseDuration with whole days, like 14d
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// parseConfigFile reads a flat YAML file, or TOML when the name ends in
+// .toml. Lists become the comma separated form the other sources use, and
+// YAML scalars are kept as written, so an ID like 0042 stays a string.
+//
+//	interval: 5m
+//	backlog-excludes:
+//	  - Ideas
+//	  - Needs research
+func parseConfigFile(name string, contents []byte) (map[string]string, error) {
+	values := map[string]string{}
+	if strings.HasSuffix(name, ".toml") {
+		raw := map[string]interface{}{}
+		if _, err := toml.Decode(string(contents), &raw); err != nil {
+			return nil, err
+		}
+		for key, value := range raw {
+			var err error
+			if values[key], err = tomlValue(key, value); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected 'key: value' settings", root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i].Value
+		var err error
+		if values[key], err = yamlValue(key, root.Content[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func yamlValue(key string, node *yaml.Node) (string, error) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		var items []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("%s: list items can't be lists or tables", key)
+			}
+			items = append(items, item.Value)
+		}
+		return joinConfigList(key, items)
+	default:
+		return "", fmt.Errorf("%s: tables aren't supported, settings are flat", key)
+	}
+}
+
+func tomlValue(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			switch item.(type) {
+			case []interface{}, []map[string]interface{}, map[string]interface{}:
+				return "", fmt.Errorf("%s: list items can't be lists or tables", key)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		return joinConfigList(key, items)
+	case map[string]interface{}, []map[string]interface{}:
+		return "", fmt.Errorf("%s: tables aren't supported, settings are flat", key)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// joinConfigList joins list items with commas, which items can't contain
+// since they couldn't be told apart from the separator
+func joinConfigList(key string, items []string) (string, error) {
+	for _, item := range items {
+		if strings.Contains(item, ",") {
+			return "", fmt.Errorf("%s: list items can't contain commas", key)
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+// readConfigDir reads the one-file-per-key layout (Kubernetes ConfigMaps and
+// Secrets), only for keys that have a file
+func readConfigDir(dir string, keys []string) (map[string]string, error) {
+	values := map[string]string{}
+	if dir == "" {
+		return values, nil
+	}
+	for _, key := range keys {
+		contents, err := ioutil.ReadFile(path.Join(dir, key))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading %s", key)
+		}
+		values[key] = strings.TrimSpace(string(contents))
+	}
+	return values, nil
+}
+
+// LoadConfig builds the configuration from, in increasing priority: defaults,
+// the config file, the per-file config and secrets directories, and
+// MIRIAM_* environment variables. The result is validated before returning.
+func LoadConfig(configFile string, configDir string, secretsDir string) (*Config, error) {
+	c := &Config{}
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var configKeys, secretKeys []string
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("secret") == "true" {
+			secretKeys = append(secretKeys, t.Field(i).Tag.Get("config"))
+		} else {
+			configKeys = append(configKeys, t.Field(i).Tag.Get("config"))
+		}
+	}
+
+	fileValues := map[string]string{}
+	if configFile != "" {
+		contents, err := ioutil.ReadFile(configFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "Error reading config file %s", configFile)
+		}
+		if err == nil {
+			fileValues, err = parseConfigFile(configFile, contents)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error parsing config file %s", configFile)
+			}
+		}
+	}
+	configValues, err := readConfigDir(configDir, configKeys)
+	if err != nil {
+		return nil, err
+	}
+	secretValues, err := readConfigDir(secretsDir, secretKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	var problems []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("config")
+		known[key] = true
+		value := field.Tag.Get("default")
+		for _, source := range []map[string]string{fileValues, configValues, secretValues} {
+			if fromSource, ok := source[key]; ok {
+				value = fromSource
+			}
+		}
+		if fromEnv, ok := os.LookupEnv(configEnvName(key)); ok {
+			value = fromEnv
+		}
+		if value == "" {
+			if field.Tag.Get("required") == "true" {
+				problems = append(problems, fmt.Sprintf("%s is required", key))
+			}
+			continue
+		}
+		if err := setConfigField(v.Field(i), key, value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for key := range fileValues {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s is not a known setting", key))
+		}
+	}
+	problems = append(problems, c.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return c, nil
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	if c.Interval < time.Second {
+		problems = append(problems, "interval must be at least 1s")
+	}
+	if c.WIPLimit < 1 {
+		problems = append(problems, "wip-limit must be at least 1")
+	}
+	if c.InProgressList != "" && c.InProgressList == c.ToDoList {
+		problems = append(problems, "in-progress-list and to-do-list must be different lists")
+	}
+	if c.TasksChecklist == c.BacklogChecklist {
+		problems = append(problems, "tasks-checklist and backlog-checklist must be different checklists")
+	}
+	if c.TaskMode != "" && c.TaskMode != TaskModeTasks && c.TaskMode != TaskModeSubtasks {
+		problems = append(problems, fmt.Sprintf("task-mode must be %v or %v", TaskModeTasks, TaskModeSubtasks))
+	}
+	if c.GoalLists && c.GoalsFolder == "" {
+		problems = append(problems, "goals-folder is required with goal-lists")
+	}
+	if c.GoalLists && c.GoalsFolder == c.GoalListArchive {
+		problems = append(problems, "goals-folder and goal-list-archive must be different folders")
+	}
+	problems = append(problems, c.validateChat()...)
+	if len(c.DigestEmail) > 0 && (c.SMTPAddress == "" || c.SMTPFrom == "") {
+		problems = append(problems, "smtp-address and smtp-from are required to email digests")
+	}
+	if _, err := c.StaleThresholds(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, flag := range c.StaleFlags {
+		if flag != StaleFlagLabel && flag != StaleFlagTask {
+			problems = append(problems, fmt.Sprintf("stale-flags: unknown flag %v, expected %v or %v", flag, StaleFlagLabel, StaleFlagTask))
+		}
+	}
+	if _, err := c.ReminderPolicies(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, event := range c.NotifyEvents {
+		if !containsString(EventTypes, event) {
+			problems = append(problems, fmt.Sprintf("notify-events: unknown event %v, expected one of %v", event, strings.Join(EventTypes, ", ")))
+		}
+	}
+	if _, err := c.Location(); err != nil {
+		problems = append(problems, fmt.Sprintf("timezone: %v", err))
+	} else if c.Interval >= time.Second {
+		if _, err := NewScheduler(c, time.Now()); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// Location is the time zone for schedules and quiet hours, local time unless
+// a timezone is configured
+func (c *Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// How task-mode maps Tasks items to the inbox
+const (
+	TaskModeTasks    = "tasks"
+	TaskModeSubtasks = "subtasks"
+)
+
+// Ways of flagging stale cards with stale-flags
+const (
+	StaleFlagLabel = "label"
+	StaleFlagTask  = "task"
+)
+
+// StaleThresholds maps list names to how long a card may stay in them
+func (c *Config) StaleThresholds() (map[string]time.Duration, error) {
+	thresholds := map[string]time.Duration{}
+	for _, entry := range c.StaleLists {
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("stale-lists: expected List=14d, got %v", entry)
+		}
+		d, err := parseDuration(strings.TrimSpace(entry[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("stale-lists: %v has an invalid duration %v", entry[:i], entry[i+1:])
+		}
+		thresholds[strings.TrimSpace(entry[:i])] = d
+	}
+	return thresholds, nil
+}
+
+// Kinds of reminders policy
+const (
+	ReminderDue  = "due"
+	ReminderOpen = "open"
+)
+
+// ReminderPolicy is a reminders entry: remind Offset before a task is due or
+// once it has been open for Offset, at At past midnight
+type ReminderPolicy struct {
+	Kind   string
+	Offset time.Duration
+	At     time.Duration
+}
+
+// ReminderPolicies parses the reminders setting
+func (c *Config) ReminderPolicies() ([]ReminderPolicy, error) {
+	var policies []ReminderPolicy
+	for _, entry := range c.Reminders {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("reminders: expected due=1d@09:00 or open=3d@09:00, got %v", entry)
+		}
+		policy := ReminderPolicy{Kind: strings.TrimSpace(entry[:i]), At: 9 * time.Hour}
+		if policy.Kind != ReminderDue && policy.Kind != ReminderOpen {
+			return nil, fmt.Errorf("reminders: unknown policy %v, expected %v or %v", policy.Kind, ReminderDue, ReminderOpen)
+		}
+		value := strings.TrimSpace(entry[i+1:])
+		if at := strings.Index(value, "@"); at >= 0 {
+			t, err := time.Parse("15:04", strings.TrimSpace(value[at+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("reminders: %v has an invalid time of day %v", entry, value[at+1:])
+			}
+			policy.At = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+			value = strings.TrimSpace(value[:at])
+		}
+		d, err := parseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("reminders: %v has an invalid duration %v", entry, value)
+		}
+		policy.Offset = d
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// JobSchedule is the schedule-<job> setting for a job, empty if the job runs
+// on the interval
+func (c *Config) JobSchedule(name string) string {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("config") == "schedule-"+name {
+			return v.Field(i).String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir := filepath.Join(dir, "config")
+	secretsDir := filepath.Join(dir, "secrets")
+	os.Mkdir(configDir, 0755)
+	os.Mkdir(secretsDir, 0755)
+
+	writeConfigFiles(t, dir, map[string]string{"miriam.yaml": `
+# miriam settings
+interval: 10m
+trello-backlog: "backlog-from-file"
+trello-goals: goals-from-file
+wip-limit: 2
+backlog-excludes:
+  - Ideas
+  - 'Someday'
+stale-backlog: 60d
+reminders: due=1d@08:30, open=3d
+`})
+	// The per-file layout wins over the config file
+	writeConfigFiles(t, configDir, map[string]string{"trello-goals": "goals-from-dir\n"})
+	writeConfigFiles(t, secretsDir, map[string]string{
+		"trello-key":              "key",
+		"trello-token":            "token",
+		"wunderlist-access-token": "access",
+		"wunderlist-client-id":    "client",
+	})
+	// And the environment wins over both
+	os.Setenv("MIRIAM_WIP_LIMIT", "3")
+	defer os.Unsetenv("MIRIAM_WIP_LIMIT")
+
+	c, err := LoadConfig(filepath.Join(dir, "miriam.yaml"), configDir, secretsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Interval != 10*time.Minute {
+		t.Errorf("expected interval 10m, got %v", c.Interval)
+	}
+	if c.TrelloBacklog != "backlog-from-file" || c.TrelloGoals != "goals-from-dir" {
+		t.Errorf("unexpected boards %q and %q", c.TrelloBacklog, c.TrelloGoals)
+	}
+	if c.WIPLimit != 3 {
+		t.Errorf("expected wip-limit from the environment, got %d", c.WIPLimit)
+	}
+	if !reflect.DeepEqual(c.BacklogExcludes, []string{"Ideas", "Someday"}) {
+		t.Errorf("unexpected backlog-excludes %q", c.BacklogExcludes)
+	}
+	if c.StaleBacklog != 60*24*time.Hour {
+		t.Errorf("expected stale-backlog of 60 days, got %v", c.StaleBacklog)
+	}
+	if thresholds, err := c.StaleThresholds(); err != nil || thresholds["In Progress"] != 14*24*time.Hour {
+		t.Errorf("expected In Progress to go stale after 14 days, got %v (%v)", thresholds, err)
+	}
+	expected := []ReminderPolicy{{Kind: ReminderDue, Offset: 24 * time.Hour, At: 8*time.Hour + 30*time.Minute}, {Kind: ReminderOpen, Offset: 72 * time.Hour, At: 9 * time.Hour}}
+	if policies, err := c.ReminderPolicies(); err != nil || !reflect.DeepEqual(policies, expected) {
+		t.Errorf("expected reminder policies %v, got %v (%v)", expected, policies, err)
+	}
+	if c.InProgressList != "In Progress" || c.AuditLog != "audit.jsonl" {
+		t.Errorf("expected defaults, got %q and %q", c.InProgressList, c.AuditLog)
+	}
+	if c.TrelloKey != "key" || c.WunderlistClientID != "client" {
+		t.Errorf("expected secrets to be loaded")
+	}
+}
+
+func TestLoadConfigPerFileOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFiles(t, dir, map[string]string{
+		"interval":                "300",
+		"trello-backlog":          "backlog",
+		"trello-goals":            "goals",
+		"trello-key":              "key",
+		"trello-token":            "token",
+		"wunderlist-access-token": "access",
+		"wunderlist-client-id":    "client",
+	})
+	c, err := LoadConfig(filepath.Join(dir, "missing.yaml"), dir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Interval != 5*time.Minute {
+		t.Errorf("expected an integer interval to be read as seconds, got %v", c.Interval)
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		contents string
+		expected map[string]string
+		problem  string
+	}{
+		{"miriam.yaml", "trello-token: \"abc #1\" # quoted\nwip-limit: 2\nchat:\n", map[string]string{"trello-token": "abc #1", "wip-limit": "2", "chat": ""}, ""},
+		{"miriam.yaml", "backlog-excludes: [Ideas, Needs research]\n", map[string]string{"backlog-excludes": "Ideas,Needs research"}, ""},
+		{"miriam.toml", "trello-token = \"abc #1\" # quoted\nbacklog-excludes = [\"Ideas\", \"Needs research\"]\nwip-limit = 2\n", map[string]string{"trello-token": "abc #1", "backlog-excludes": "Ideas,Needs research", "wip-limit": "2"}, ""},
+		{"miriam.yaml", "chat:\n  slack: token\n", nil, "chat: tables aren't supported"},
+		{"miriam.toml", "[chat]\nslack = \"token\"\n", nil, "chat: tables aren't supported"},
+		{"miriam.yaml", "backlog-excludes: [\"Ideas, mostly\"]\n", nil, "backlog-excludes: list items can't contain commas"},
+		{"miriam.yaml", "trello-goals: 0042\nwip-limit: 2\n", map[string]string{"trello-goals": "0042", "wip-limit": "2"}, ""},
+		{"miriam.yaml", "interval = 5m\n", nil, "expected 'key: value' settings"},
+	} {
+		values, err := parseConfigFile(test.name, []byte(test.contents))
+		if test.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%s %q: expected %q, got %v", test.name, test.contents, test.problem, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("%s %q: expected %v, got %v (%v)", test.name, test.contents, test.expected, values, err)
+		}
+	}
+}
+
+func TestLoadConfigFromTOML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFiles(t, dir, map[string]string{"miriam.toml": `
+# miriam settings
+interval = "10m"
+trello-backlog = "backlog"
+trello-goals = "goals"
+trello-key = "key"
+trello-token = "abc #1"
+wunderlist-access-token = "access"
+wunderlist-client-id = "client"
+backlog-excludes = ["Someday", "Needs research"]
+`})
+	c, err := LoadConfig(filepath.Join(dir, "miriam.toml"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Interval != 10*time.Minute || c.TrelloToken != "abc #1" {
+		t.Errorf("expected the TOML values, got interval %v and token %q", c.Interval, c.TrelloToken)
+	}
+	if !reflect.DeepEqual(c.BacklogExcludes, []string{"Someday", "Needs research"}) {
+		t.Errorf("expected the TOML array as a list, got %q", c.BacklogExcludes)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFiles(t, dir, map[string]string{"miriam.yaml": `
+interval: soon
+wip-limit: 0
+trello-gaols: typo
+notify-events: goal-started, goal-finished
+chat: slack
+stale-lists: In Progress=2 weeks, Waiting
+stale-flags: label, email
+reminders: due=1d@9am
+task-mode: subtask
+goal-lists: true
+goal-list-archive: Goals
+`})
+	_, err = LoadConfig(filepath.Join(dir, "miriam.yaml"), "", "")
+	if err == nil {
+		t.Fatal("expected an invalid configuration")
+	}
+	for _, problem := range []string{"interval must be a duration", "wip-limit must be at least 1", "trello-goals is required", "trello-key is required", "trello-gaols is not a known setting", "unknown event goal-finished", "slack-token is required for slack chat", "In Progress has an invalid duration", "unknown flag email", "invalid time of day 9am", "task-mode must be tasks or subtasks", "goals-folder and goal-list-archive must be different folders"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in %v", problem, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// digest is a scheduled report of where the goals stand. Period is how far
+// back the first one looks for completed tasks.
+type digest struct {
+	Name   string
+	Title  string
+	Period time.Duration
+}
+
+// digests are off until they have a schedule-<name>
+var digests = []digest{
+	{"daily-digest", "Daily digest", 24 * time.Hour},
+	{"weekly-digest", "Weekly digest", 7 * 24 * time.Hour},
+}
+
+func findDigest(name string) *digest {
+	for i := range digests {
+		if digests[i].Name == name {
+			return &digests[i]
+		}
+	}
+	return nil
+}
+
+// digestReport is the Markdown report for a snapshot: the goals in
+// progress, tasks completed since the last digest, goals waiting in To Do
+// and backlog cards that still need planning
+func digestReport(c *Config, s *Snapshot, d digest, since time.Time) string {
+	var b strings.Builder
+	location, err := c.Location()
+	if err != nil {
+		location = time.Local
+	}
+	fmt.Fprintf(&b, "# %v, %v\n", d.Title, s.Now.In(location).Format("Monday 2 January 2006"))
+
+	fmt.Fprintf(&b, "\n## %v\n\n", c.InProgressList)
+	var inProgress, toDo []*Card
+	if list := s.Goals.ListByName(c.InProgressList); list != nil {
+		inProgress = s.Goals.CardsIn(list.ID)
+	}
+	if list := s.Goals.ListByName(c.ToDoList); list != nil {
+		toDo = s.Goals.CardsIn(list.ID)
+	}
+	if len(inProgress) == 0 {
+		fmt.Fprintf(&b, "Nothing, time to plan a new goal.\n")
+	}
+	for _, card := range inProgress {
+		tasksChecked, tasksUnchecked := card.Items(c.TasksChecklist)
+		_, backlogUnchecked := card.Items(c.BacklogChecklist)
+		fmt.Fprintf(&b, "- [%v](%v): %v %v/%v done, %v %v left\n", card.Name, card.ShortURL, c.TasksChecklist, len(tasksChecked), len(tasksChecked)+len(tasksUnchecked), len(backlogUnchecked), c.BacklogChecklist)
+		for _, item := range tasksUnchecked {
+			fmt.Fprintf(&b, "  - [ ] %v\n", item.Name)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## Completed since %v\n\n", since.In(location).Format("Mon 2 Jan 15:04"))
+	cards := map[string]*Card{}
+	for _, card := range append(append([]*Card(nil), s.Goals.Cards...), s.Backlog.Cards...) {
+		cards[card.ShortURL] = card
+	}
+	completed := 0
+	for _, task := range s.Tasks {
+		if !task.Completed || !task.CompletedAt.After(since) {
+			continue
+		}
+		completed++
+		name, link := splitTaskTitle(task.Title)
+		if card := cards[link]; card != nil {
+			fmt.Fprintf(&b, "- %v on [%v](%v)\n", name, card.Name, card.ShortURL)
+		} else {
+			fmt.Fprintf(&b, "- %v\n", task.Title)
+		}
+	}
+	if completed == 0 {
+		fmt.Fprintf(&b, "Nothing yet.\n")
+	}
+
+	writeCardSection(&b, fmt.Sprintf("Waiting in %v", c.ToDoList), toDo)
+	for _, label := range []string{c.NeedsSuccessLabel, c.NeedsTasksLabel} {
+		var labelled []*Card
+		for _, card := range s.Backlog.Cards {
+			if card.HasLabel(label) {
+				labelled = append(labelled, card)
+			}
+		}
+		writeCardSection(&b, label, labelled)
+	}
+	return b.String()
+}
+
+func writeCardSection(b *strings.Builder, title string, cards []*Card) {
+	fmt.Fprintf(b, "\n## %v\n\n", title)
+	if len(cards) == 0 {
+		fmt.Fprintf(b, "None.\n")
+	}
+	for _, card := range cards {
+		fmt.Fprintf(b, "- [%v](%v)\n", card.Name, card.ShortURL)
+	}
+}
+
+// splitTaskTitle splits "name (card link)", the way task sync titles tasks
+func splitTaskTitle(title string) (string, string) {
+	i := strings.LastIndex(title, " (")
+	if i < 0 || !strings.HasSuffix(title, ")") {
+		return title, ""
+	}
+	return title[:i], title[i+2 : len(title)-1]
+}
+
+// sendDigest writes the digest to digest-dir and sends it to chat and email,
+// whichever are configured. The next digest covers what was completed after
+// this one.
+func (a *App) sendDigest(d digest) error {
+	c := a.Config
+	snapshot, err := a.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	sent, err := a.readDigestTimes()
+	if err != nil {
+		return err
+	}
+	since, ok := sent[d.Name]
+	if !ok {
+		since = snapshot.Now.Add(-d.Period)
+	}
+	report := digestReport(c, snapshot, d, since)
+	fmt.Printf("Sending the %v\n", d.Name)
+
+	var failures []string
+	if c.DigestDir != "" {
+		path := filepath.Join(c.DigestDir, fmt.Sprintf("%v-%v.md", d.Name, snapshot.Now.Format("2006-01-02")))
+		if err := os.MkdirAll(c.DigestDir, 0755); err != nil {
+			failures = append(failures, err.Error())
+		} else if err := ioutil.WriteFile(path, []byte(report), 0644); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if a.Chat != nil {
+		if err := a.Chat.Send(c.NotifyChannel, report); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(c.DigestEmail) > 0 {
+		if err := a.emailDigest(d, snapshot.Now, report); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Error sending the %v: %v", d.Name, strings.Join(failures, "; "))
+	}
+	sent[d.Name] = snapshot.Now
+	return a.writeDigestTimes(sent)
+}
+
+// emailDigest sends the report as a plain text email over SMTP, logging in
+// when smtp-username is set
+func (a *App) emailDigest(d digest, now time.Time, report string) error {
+	c := a.Config
+	var auth smtp.Auth
+	if c.SMTPUsername != "" {
+		host := c.SMTPAddress
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", c.SMTPUsername, c.SMTPPassword, host)
+	}
+	headers := []string{
+		"From: " + c.SMTPFrom,
+		"To: " + strings.Join(c.DigestEmail, ", "),
+		fmt.Sprintf("Subject: %v, %v", d.Title, now.Format("2 January 2006")),
+		"Date: " + now.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.Replace(report, "\n", "\r\n", -1)
+	if err := smtp.SendMail(c.SMTPAddress, auth, c.SMTPFrom, c.DigestEmail, []byte(message)); err != nil {
+		return errors.Wrapf(err, "Error emailing %v", strings.Join(c.DigestEmail, ", "))
+	}
+	return nil
+}
+
+// The time each digest was last sent is kept next to the reports
+func (a *App) digestTimesPath() string {
+	return filepath.Join(a.Config.DigestDir, "last-sent.json")
+}
+
+func (a *App) readDigestTimes() (map[string]time.Time, error) {
+	sent := map[string]time.Time{}
+	if a.Config.DigestDir == "" {
+		return sent, nil
+	}
+	data, err := ioutil.ReadFile(a.digestTimesPath())
+	if os.IsNotExist(err) {
+		return sent, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return nil, errors.Wrapf(err, "Error reading %s", a.digestTimesPath())
+	}
+	return sent, nil
+}
+
+func (a *App) writeDigestTimes(sent map[string]time.Time) error {
+	if a.Config.DigestDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.digestTimesPath(), data, 0644)
+}
+
+// runDue runs the jobs the scheduler says are due, then sends the digests
+// that are due so they include the run
+func (a *App) runDue(names []string) error {
+	var due []string
+	var failures []string
+	for _, name := range names {
+		if findDigest(name) == nil {
+			due = append(due, name)
+		}
+	}
+	// No names means every job to runJobs
+	if len(due) > 0 {
+		if err := a.runJobs(due...); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	for _, name := range names {
+		if d := findDigest(name); d != nil {
+			if err := a.sendDigest(*d); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%v", strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// smtpSink is a local SMTP server that accepts every message
+type smtpSink struct {
+	listener net.Listener
+	mu       sync.Mutex
+	Messages []smtpMessage
+}
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) Address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *smtpSink) Close() {
+	s.listener.Close()
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 localhost sink")
+	var message smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = smtpMessage{From: strings.Trim(strings.TrimSpace(line)[10:], "<>")}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.To = append(message.To, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data []string
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data = append(data, strings.TrimSuffix(line, "\r\n"))
+			}
+			message.Data = strings.Join(data, "\n")
+			s.mu.Lock()
+			s.Messages = append(s.Messages, message)
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestDigestReport(t *testing.T) {
+	c := plannerConfig(1)
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	s := &Snapshot{
+		Now:     now,
+		Backlog: Board{ID: "backlog"},
+		Goals:   Board{ID: "goals", Lists: []List{{ID: "todo", Name: "To Do"}, {ID: "doing", Name: "In Progress"}}},
+	}
+	s.Goals.Cards = []*Card{
+		{ID: "go", Name: "Learn Go", ShortURL: "https://trello.com/c/go", ListID: "doing", Checklists: []*Checklist{
+			{Name: "Tasks", Items: []CheckItem{{Name: "Read the tour", Complete: true}, {Name: "Write a CLI"}}},
+			{Name: "Backlog", Items: []CheckItem{{Name: "Write tests"}, {Name: "Ship it"}}},
+		}},
+		{ID: "marathon", Name: "Run a marathon", ShortURL: "https://trello.com/c/marathon", ListID: "todo"},
+	}
+	s.Backlog.Cards = []*Card{{ID: "juggle", Name: "Learn to juggle", ShortURL: "https://trello.com/c/juggle", Labels: []Label{{Name: "Needs success criteria"}, {Name: "Needs tasks"}}}}
+	s.Tasks = append(s.Tasks,
+		taskCompletedAt("Read the tour (https://trello.com/c/go)", now.Add(-2*time.Hour)),
+		taskCompletedAt("Install Go (https://trello.com/c/go)", now.Add(-48*time.Hour)),
+		taskCompletedAt("Water the plants", now.Add(-time.Hour)),
+	)
+
+	expected := `# Daily digest, Monday 2 March 2026
+
+## In Progress
+
+- [Learn Go](https://trello.com/c/go): Tasks 1/2 done, 2 Backlog left
+  - [ ] Write a CLI
+
+## Completed since Sun 1 Mar 09:00
+
+- Read the tour on [Learn Go](https://trello.com/c/go)
+- Water the plants
+
+## Waiting in To Do
+
+- [Run a marathon](https://trello.com/c/marathon)
+
+## Needs success criteria
+
+- [Learn to juggle](https://trello.com/c/juggle)
+
+## Needs tasks
+
+- [Learn to juggle](https://trello.com/c/juggle)
+`
+	if report := digestReport(c, s, digests[0], now.Add(-24*time.Hour)); report != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, report)
+	}
+}
+
+func TestDigestDelivery(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	sink := newSMTPSink(t)
+	defer sink.Close()
+	chat := &fakeChat{}
+	w.App.Chat = chat
+	c := w.App.Config
+	c.DigestDir = filepath.Join(w.dir, "digests")
+	c.DigestEmail = []string{"matt@example.com"}
+	c.SMTPAddress, c.SMTPFrom = sink.Address(), "miriam@example.com"
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Read the tour")
+	w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), true)
+
+	if err := w.App.runDue([]string{"daily-digest"}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ioutil.ReadFile(filepath.Join(c.DigestDir, "daily-digest-2026-03-02.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), "- Read the tour on [Learn Go]") {
+		t.Errorf("expected the completed task in the report, got:\n%s", report)
+	}
+	if len(chat.Sent) != 1 || chat.Sent[0] != string(report) {
+		t.Errorf("expected the report in chat, got %v", chat.Sent)
+	}
+	if len(sink.Messages) != 1 {
+		t.Fatalf("expected one email, got %v", sink.Messages)
+	}
+	email := sink.Messages[0]
+	if email.From != "miriam@example.com" || len(email.To) != 1 || email.To[0] != "matt@example.com" {
+		t.Errorf("expected an email from miriam to matt, got %+v", email)
+	}
+	if !strings.Contains(email.Data, "Subject: Daily digest, 2 March 2026") || !strings.Contains(email.Data, "- Read the tour on [Learn Go]") {
+		t.Errorf("expected the report in the email, got:\n%v", email.Data)
+	}
+
+	// The next digest starts from this one
+	w.Clock.Advance(24 * time.Hour)
+	if err := w.App.runDue([]string{"daily-digest"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(chat.Sent[1], "## Completed since Mon 2 Mar 09:00\n\nNothing yet.") {
+		t.Errorf("expected nothing completed since the last digest, got:\n%v", chat.Sent[1])
+	}
+}
+
+func taskCompletedAt(title string, at time.Time) wunderlist.Task {
+	return wunderlist.Task{Title: title, Completed: true, CompletedAt: at}
+}
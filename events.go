@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Event types, each can be turned on and off with notify-events
+const (
+	EventGoalPlanned   = "goal-planned"
+	EventGoalStarted   = "goal-started"
+	EventGoalCompleted = "goal-completed"
+	EventNewGoalNeeded = "new-goal-needed"
+	EventBacklogItem   = "backlog-promoted"
+	EventNeedsSuccess  = "needs-success-criteria"
+	EventStale         = "stale"
+	EventRunFailed     = "run-failed"
+)
+
+// EventTypes is every event type, in the order they're documented
+var EventTypes = []string{EventGoalPlanned, EventGoalStarted, EventGoalCompleted, EventNewGoalNeeded, EventBacklogItem, EventNeedsSuccess, EventStale, EventRunFailed}
+
+// Event is something worth telling the chat channel about
+type Event struct {
+	Type string
+	Text string
+}
+
+// runEvents describes the steps a run carried out on the snapshot it
+// planned from
+func runEvents(c *Config, snapshot *Snapshot, steps []Step) []Event {
+	var events []Event
+	for _, step := range steps {
+		switch action := step.Action.(type) {
+		case MoveCardToBoard:
+			events = append(events, Event{EventGoalPlanned, fmt.Sprintf("Planned goal *%v* (%v)", action.Card.Name, action.Card.ShortURL)})
+		case MoveCardToList:
+			if list := snapshot.Goals.ListByName(c.InProgressList); list != nil && list.ID == action.ListID {
+				events = append(events, Event{EventGoalStarted, fmt.Sprintf("Started goal *%v* (%v)", action.Card.Name, action.Card.ShortURL)})
+			}
+			if list := snapshot.Goals.ListByName(c.DoneList); list != nil && list.ID == action.ListID {
+				events = append(events, Event{EventGoalCompleted, fmt.Sprintf("Completed goal *%v* (%v)", action.Card.Name, action.Card.ShortURL)})
+			}
+		case CreateTask:
+			if step.Rule == "goal-promotion" {
+				events = append(events, Event{EventNewGoalNeeded, fmt.Sprintf("Nothing is in %v or %v, time to plan a new goal (%v)", c.InProgressList, c.ToDoList, snapshot.Goals.ShortURL)})
+			}
+			if step.Rule == "stale-detection" {
+				events = append(events, Event{EventStale, fmt.Sprintf("Added a task: %v", action.Title)})
+			}
+		case MoveCheckItem:
+			events = append(events, Event{EventBacklogItem, fmt.Sprintf("Next up on *%v*: %v (%v)", action.Card.Name, action.Item.Name, action.Card.ShortURL)})
+		case AddLabel:
+			if action.Label.Name == c.NeedsSuccessLabel {
+				events = append(events, Event{EventNeedsSuccess, fmt.Sprintf("*%v* needs success criteria (%v)", action.Card.Name, action.Card.ShortURL)})
+			}
+			if action.Label.Name == c.StaleLabel {
+				events = append(events, Event{EventStale, fmt.Sprintf("*%v* has gone stale (%v)", action.Card.Name, action.Card.ShortURL)})
+			}
+		case StarTask:
+			events = append(events, Event{EventStale, fmt.Sprintf("Task *%v* has been open since %v", action.Task.Title, action.Task.CreatedAt.Format("2 Jan"))})
+		}
+	}
+	return events
+}
+
+// runFailedEvent explains why a run failed, with each failed operation
+func runFailedEvent(err error, failures []error) Event {
+	text := fmt.Sprintf("Run failed: %v", err)
+	for _, failure := range failures {
+		text = fmt.Sprintf("%v\n> %v", text, failure)
+	}
+	return Event{EventRunFailed, text}
+}
+
+// notify sends the enabled events to the notify channel, when chat is
+// configured
+func (a *App) notify(events []Event) {
+	if a.Chat == nil {
+		return
+	}
+	var lines []string
+	for _, event := range events {
+		if containsString(a.Config.NotifyEvents, event.Type) {
+			lines = append(lines, event.Text)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if err := a.Chat.Send(a.Config.NotifyChannel, strings.Join(lines, "\n")); err != nil {
+		log.Println(err)
+	}
+}
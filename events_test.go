@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRunEvents(t *testing.T) {
+	c := plannerConfig(1)
+	s := &Snapshot{
+		Backlog: Board{ID: "backlog", Labels: []Label{{ID: "planned", Name: "Planned"}, {ID: "needs", Name: "Needs success criteria"}}},
+		Goals:   Board{ID: "goals", ShortURL: "https://trello.com/b/goals", Lists: []List{{ID: "todo", Name: "To Do"}, {ID: "doing", Name: "In Progress"}, {ID: "done", Name: "Done"}}},
+	}
+	s.Backlog.Cards = []*Card{
+		{ID: "marathon", Name: "Run a marathon", ShortURL: "https://trello.com/c/marathon", BoardID: "backlog", Labels: []Label{{ID: "planned", Name: "Planned"}}},
+		{ID: "juggle", Name: "Learn to juggle", ShortURL: "https://trello.com/c/juggle", BoardID: "backlog"},
+	}
+	s.Goals.Cards = []*Card{{ID: "go", Name: "Learn Go", ShortURL: "https://trello.com/c/go", BoardID: "goals", ListID: "doing", Checklists: []*Checklist{
+		{ID: "tasks", Name: "Tasks", Items: []CheckItem{{ID: "tour", Name: "Read the tour", ChecklistID: "tasks"}}},
+		{ID: "backlog", Name: "Backlog"},
+	}}}
+	goal := *s.Goals.Cards[0]
+	steps := []Step{
+		{Rule: "label-hygiene", CardID: "juggle", Action: AddLabel{Card: *s.Backlog.Cards[1], Label: s.Backlog.Labels[1]}},
+		{Rule: "planned-move", CardID: "marathon", Action: MoveCardToBoard{Card: *s.Backlog.Cards[0], BoardID: "goals", ListID: "todo"}},
+		{Rule: "task-sync", CardID: "go", Action: MarkCheckItem{Card: goal, Item: goal.Checklists[0].Items[0], Complete: true}},
+		{Rule: "goal-completion", CardID: "go", Action: MoveCardToList{Card: goal, ListID: "done"}},
+	}
+
+	var texts []string
+	for _, event := range runEvents(c, s, steps) {
+		texts = append(texts, fmt.Sprintf("%v: %v", event.Type, event.Text))
+	}
+	expectStrings(t, "events", texts,
+		"needs-success-criteria: *Learn to juggle* needs success criteria (https://trello.com/c/juggle)",
+		"goal-planned: Planned goal *Run a marathon* (https://trello.com/c/marathon)",
+		"goal-completed: Completed goal *Learn Go* (https://trello.com/c/go)",
+	)
+}
+
+func TestNotifications(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	chat := &fakeChat{}
+	w.App.Chat = chat
+	w.App.Config.NotifyChannel = "goals"
+	w.App.Config.NotifyEvents = []string{EventGoalStarted, EventBacklogItem, EventRunFailed}
+	goal := w.Trello.AddCard(w.ToDo, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks")
+	item := w.Trello.AddChecklist(goal, "Backlog", "Read the tour").CheckItems[0]
+	w.Trello.AddCard(w.Someday, "Learn to juggle")
+
+	if err := w.App.runJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(chat.Sent) != 1 || len(chat.SentTo["goals"]) != 1 {
+		t.Fatalf("expected one notification in goals, got %v", chat.SentTo)
+	}
+	expected := fmt.Sprintf("Started goal *Learn Go* (%v)\nNext up on *Learn Go*: Read the tour (%v)", goal.ShortUrl, goal.ShortUrl)
+	if chat.Sent[0] != expected {
+		t.Errorf("expected %q, got %q", expected, chat.Sent[0])
+	}
+
+	// Nothing changes, so nothing is sent
+	if err := w.App.runJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(chat.Sent) != 1 {
+		t.Errorf("expected no more notifications, got %v", chat.Sent[1:])
+	}
+
+	// Done in Wunderlist, but Trello won't check it off
+	w.Wunderlist.task(w.Wunderlist.Find("Read the tour")[0].ID).Completed = true
+	w.Trello.Fail[fmt.Sprintf("PUT /cards/%s/checkItem/%s", goal.ID, item.ID)] = http.StatusInternalServerError
+	if err := w.App.runJobs(); err == nil {
+		t.Fatal("expected the failed update to fail the run")
+	}
+	if len(chat.Sent) != 2 || !strings.HasPrefix(chat.Sent[1], "Run failed: 1 operations failed") {
+		t.Errorf("expected the failure to be reported, got %v", chat.Sent)
+	}
+}
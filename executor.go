@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/adlio/trello"
+	"github.com/pkg/errors"
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// executor carries out planned steps against the live services and audits
+// each one. Steps only name checklists, since a checklist created earlier in
+// the plan has no ID until it exists.
+type executor struct {
+	app      *App
+	snapshot *Snapshot
+	// Checklist IDs by card ID and checklist name
+	checklists map[string]map[string]string
+	// The latest revision of every task that was changed
+	tasks map[uint]wunderlist.Task
+	// Tasks created in this run by title, for subtasks planned before their
+	// task existed
+	created map[string]wunderlist.Task
+	// Goal lists by title, including the ones created in this run
+	lists map[string]wunderlist.List
+	// The steps that were carried out
+	done []Step
+}
+
+func (a *App) newExecutor(snapshot *Snapshot) *executor {
+	e := &executor{app: a, snapshot: snapshot, checklists: map[string]map[string]string{}, tasks: map[uint]wunderlist.Task{}, created: map[string]wunderlist.Task{}, lists: map[string]wunderlist.List{}}
+	for _, list := range snapshot.GoalLists {
+		e.lists[list.Title] = list
+	}
+	for _, board := range []Board{snapshot.Backlog, snapshot.Goals} {
+		for _, card := range board.Cards {
+			for _, checklist := range card.Checklists {
+				e.setChecklist(card.ID, checklist.Name, checklist.ID)
+			}
+		}
+	}
+	return e
+}
+
+func (e *executor) setChecklist(cardID string, name string, id string) {
+	if e.checklists[cardID] == nil {
+		e.checklists[cardID] = map[string]string{}
+	}
+	e.checklists[cardID][name] = id
+}
+
+// Execute carries out the steps in order. The steps planned for a card after
+// one of its steps failed are skipped, they assumed it succeeded.
+func (e *executor) Execute(steps []Step) []error {
+	var failures []error
+	failedCards := map[string]bool{}
+	for _, step := range steps {
+		if step.CardID != "" && failedCards[step.CardID] {
+			continue
+		}
+		fmt.Printf("    %v\n", step.Action)
+		if err := e.execute(step); err != nil {
+			log.Println(err)
+			failures = append(failures, err)
+			if step.CardID != "" {
+				failedCards[step.CardID] = true
+			}
+			continue
+		}
+		e.done = append(e.done, step)
+	}
+	return failures
+}
+
+func (e *executor) execute(step Step) error {
+	a, audit := e.app, e.app.Audit
+	switch action := step.Action.(type) {
+	case CreateChecklist:
+		checklist, err := a.Boards.CreateChecklist(action.Card.ID, action.Name)
+		if err != nil {
+			return errors.Wrapf(err, "Error creating checklist on card %s", action.Card.ID)
+		}
+		e.setChecklist(action.Card.ID, action.Name, checklist.ID)
+		audit.record(step.Rule, OpCreateChecklist, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, ChecklistID: checklist.ID}, nil, checklist)
+	case AddLabel:
+		if err := a.Boards.AddLabel(action.Card.ID, action.Label.ID); err != nil {
+			return errors.Wrapf(err, "Error adding label %s to card %s", action.Label.Name, action.Card.ID)
+		}
+		audit.record(step.Rule, OpAddLabel, labelTarget(action.Card, action.Label), nil, trelloLabel(action.Label))
+	case RemoveLabel:
+		if err := a.Boards.RemoveLabel(action.Card.ID, action.Label.ID); err != nil {
+			return errors.Wrapf(err, "Error removing label %s from card %s", action.Label.Name, action.Card.ID)
+		}
+		audit.record(step.Rule, OpRemoveLabel, labelTarget(action.Card, action.Label), trelloLabel(action.Label), nil)
+	case MoveCardToBoard:
+		before := map[string]string{"idBoard": action.Card.BoardID, "idList": action.Card.ListID}
+		moved, err := a.Boards.UpdateCard(action.Card.ID, trello.Arguments{"idBoard": action.BoardID})
+		if err != nil {
+			return errors.Wrapf(err, "Error moving card %s to board %s", action.Card.ID, action.BoardID)
+		}
+		audit.record(step.Rule, OpMoveCardToBoard, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, BoardID: action.BoardID}, before, map[string]string{"idBoard": moved.IDBoard, "idList": moved.IDList})
+	case MoveCardToList:
+		if _, err := a.Boards.UpdateCard(action.Card.ID, trello.Arguments{"idList": action.ListID}); err != nil {
+			return errors.Wrapf(err, "Error moving card %s to list %s", action.Card.ID, action.ListID)
+		}
+		audit.record(step.Rule, OpMoveCardToList, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, ListID: action.ListID}, map[string]string{"idList": action.Card.ListID}, map[string]string{"idList": action.ListID})
+	case CompleteCard:
+		// The due date is kept, the completion date goes in a comment
+		before := map[string]string{"dueComplete": fmt.Sprint(action.Card.DueComplete)}
+		after := map[string]string{"dueComplete": "true"}
+		if _, err := a.Boards.UpdateCard(action.Card.ID, trello.Arguments(after)); err != nil {
+			return errors.Wrapf(err, "Error marking card %s as completed", action.Card.ID)
+		}
+		audit.record(step.Rule, OpUpdateCard, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name}, before, after)
+		comment, err := a.Boards.AddComment(action.Card.ID, "Completed on "+action.At.Format("2006-01-02"))
+		if err != nil {
+			return errors.Wrapf(err, "Error commenting on card %s", action.Card.ID)
+		}
+		audit.record(step.Rule, OpAddComment, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, CommentID: comment.ID}, nil, comment)
+	case AttachURL:
+		attachment, err := a.Boards.AddURLAttachment(action.Card.ID, action.Name, action.URL)
+		if err != nil {
+			return errors.Wrapf(err, "Error attaching '%s' to card %s", action.Name, action.Card.ID)
+		}
+		audit.record(step.Rule, OpAddAttachment, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, AttachmentID: attachment.ID}, nil, attachment)
+	case CommentOnCard:
+		comment, err := a.Boards.AddComment(action.Card.ID, action.Text)
+		if err != nil {
+			return errors.Wrapf(err, "Error commenting on card %s", action.Card.ID)
+		}
+		audit.record(step.Rule, OpAddComment, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, CommentID: comment.ID, TaskCommentID: action.TaskComment}, nil, comment)
+	case CommentOnTask:
+		if _, err := a.commentOnTask(e.current(action.Task), action.Text, step.Rule); err != nil {
+			return err
+		}
+	case MoveCheckItem:
+		checklistID := e.checklists[action.Card.ID][action.Checklist]
+		if checklistID == "" {
+			return fmt.Errorf("Could not find checklist '%v'", action.Checklist)
+		}
+		moved, err := a.Boards.UpdateCheckItem(action.Card.ID, action.Item.ID, trello.Arguments{"idChecklist": checklistID})
+		if err != nil {
+			return errors.Wrapf(err, "Error moving checklist item '%s' to %s", action.Item.Name, action.Checklist)
+		}
+		audit.record(step.Rule, OpMoveCheckItem, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, ChecklistID: checklistID, CheckItemID: action.Item.ID}, trelloCheckItem(action.Item), moved)
+	case MarkCheckItem:
+		state := CheckItem{Complete: action.Complete}.State()
+		updated, err := a.Boards.UpdateCheckItem(action.Card.ID, action.Item.ID, trello.Arguments{"state": state})
+		if err != nil {
+			return errors.Wrapf(err, "Error marking checklist item '%s' as %s", action.Item.Name, state)
+		}
+		audit.record(step.Rule, OpMarkCheckItem, AuditTarget{CardID: action.Card.ID, CardName: action.Card.Name, ChecklistID: action.Item.ChecklistID, CheckItemID: action.Item.ID}, trelloCheckItem(action.Item), updated)
+	case CreateGoalList:
+		list, err := a.createGoalList(action.Title, step.Rule)
+		if list.ID != 0 {
+			e.lists[list.Title] = list
+		}
+		if err != nil {
+			return err
+		}
+	case RetireGoalList:
+		if err := a.retireGoalList(action.List, step.Rule); err != nil {
+			return err
+		}
+	case CreateTask:
+		listID := e.snapshot.Inbox.ID
+		if action.List != "" {
+			list, ok := e.lists[action.List]
+			if !ok {
+				return fmt.Errorf("Could not find list '%v'", action.List)
+			}
+			listID = list.ID
+		}
+		task, err := a.createTask(action.Title, listID, e.snapshot.User.ID, action.Due, step.Rule)
+		if err != nil {
+			return err
+		}
+		e.tasks[task.ID] = task
+		e.created[task.Title] = task
+		if action.Note != "" {
+			if _, err := a.createNote(task, action.Note, step.Rule); err != nil {
+				return err
+			}
+		}
+	case CompleteTask:
+		current := e.current(action.Task)
+		completed := current
+		completed.Completed = true
+		updated, err := a.updateTask(current, completed, step.Rule)
+		if err != nil {
+			return err
+		}
+		e.tasks[updated.ID] = updated
+	case UpdateTaskDue:
+		current := e.current(action.Task)
+		moved := current
+		moved.DueDate = action.Due
+		updated, err := a.updateTask(current, moved, step.Rule)
+		if err != nil {
+			return err
+		}
+		e.tasks[updated.ID] = updated
+	case StarTask:
+		current := e.current(action.Task)
+		starred := current
+		starred.Starred = true
+		updated, err := a.updateTask(current, starred, step.Rule)
+		if err != nil {
+			return err
+		}
+		e.tasks[updated.ID] = updated
+	case CreateNote:
+		if _, err := a.createNote(e.current(action.Task), action.Content, step.Rule); err != nil {
+			return err
+		}
+	case UpdateNote:
+		updated := action.Note
+		updated.Content = action.Content
+		if _, err := a.updateNote(action.Note, updated, step.Rule); err != nil {
+			return err
+		}
+	case CreateSubtask:
+		task := e.current(action.Task)
+		if task.ID == 0 {
+			created, ok := e.created[task.Title]
+			if !ok {
+				return fmt.Errorf("Could not find task '%v'", task.Title)
+			}
+			task = created
+		}
+		if _, err := a.createSubtask(task, action.Title, step.Rule); err != nil {
+			return err
+		}
+	case CompleteSubtask:
+		completed := action.Subtask
+		completed.Completed = true
+		if _, err := a.updateSubtask(action.Subtask, completed, step.Rule); err != nil {
+			return err
+		}
+	case DeleteSubtask:
+		if err := a.deleteSubtask(action.Subtask, step.Rule); err != nil {
+			return err
+		}
+	case CreateReminder:
+		if _, err := a.createReminder(e.current(action.Task), action.At, step.Rule); err != nil {
+			return err
+		}
+	case DeleteReminder:
+		if err := a.deleteReminder(action.Task, action.Reminder, step.Rule); err != nil {
+			return err
+		}
+	case DeleteTask:
+		if err := a.deleteTask(e.current(action.Task), step.Rule); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Don't know how to carry out %T", step.Action)
+	}
+	return nil
+}
+
+// current is the task as last seen, with the revision a change needs
+func (e *executor) current(task wunderlist.Task) wunderlist.Task {
+	if latest, ok := e.tasks[task.ID]; ok {
+		return latest
+	}
+	return task
+}
+
+func labelTarget(card Card, label Label) AuditTarget {
+	return AuditTarget{CardID: card.ID, CardName: card.Name, LabelID: label.ID, LabelName: label.Name}
+}
+
+// The audit log has always stored Trello's own types, which undo reads back
+func trelloLabel(label Label) *trello.Label {
+	return &trello.Label{ID: label.ID, Name: label.Name}
+}
+
+func trelloCheckItem(item CheckItem) trello.CheckItem {
+	return trello.CheckItem{ID: item.ID, Name: item.Name, State: item.State(), IDChecklist: item.ChecklistID}
+}
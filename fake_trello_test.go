@@ -0,0 +1,655 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+// fakeTrello is an in-memory Trello serving the endpoints miriam uses. Check
+// items named "[x] ..." when seeding start out complete.
+type fakeTrello struct {
+	mu         sync.Mutex
+	server     *httptest.Server
+	nextID     int
+	Boards     []*trello.Board
+	Lists      []*trello.List
+	Cards      []*trello.Card
+	Checklists []*trello.Checklist
+	Labels     []*trello.Label
+	// Actions is the history of cards moving between lists and being
+	// commented on, oldest first
+	Actions []*trello.Action
+	// Requests is every request served, as "METHOD path"
+	Requests []string
+	// Fail answers matching "METHOD path" requests with an error status
+	Fail map[string]int
+	// Clock sets each card's last activity
+	Clock Clock
+}
+
+func newFakeTrello() *fakeTrello {
+	f := &fakeTrello{Fail: map[string]int{}, Clock: systemClock{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeTrello) URL() string {
+	return f.server.URL
+}
+
+func (f *fakeTrello) Close() {
+	f.server.Close()
+}
+
+// id returns Trello style 24 character hex IDs that sort by creation
+func (f *fakeTrello) id() string {
+	f.nextID++
+	return fmt.Sprintf("%024x", f.nextID)
+}
+
+// touch records a change to the card, like Trello's dateLastActivity
+func (f *fakeTrello) touch(card *trello.Card) {
+	now := f.Clock.Now()
+	card.DateLastActivity = &now
+}
+
+// moved records the card arriving in its list, as an action of the given
+// type coming from the before list (nil for new cards)
+func (f *fakeTrello) moved(card *trello.Card, kind string, before *trello.List) {
+	data := &trello.ActionData{Card: &trello.ActionDataCard{ID: card.ID, Name: card.Name, ShortLink: card.ShortLink, Closed: card.Closed}, Board: &trello.Board{ID: card.IDBoard}}
+	list := f.list(card.IDList)
+	if kind == "updateCard" && before == nil {
+		// Archiving or restoring the card, before is the list it stays in
+		data.List = list
+		if !card.Closed {
+			data.Old = &trello.ActionDataCard{Closed: true}
+		}
+	} else if kind == "updateCard" {
+		data.ListBefore, data.ListAfter = before, list
+	} else {
+		data.List = list
+	}
+	f.Actions = append(f.Actions, &trello.Action{ID: f.id(), Type: kind, Date: f.Clock.Now(), Data: data})
+}
+
+// Seeding
+
+func (f *fakeTrello) AddBoard(name string) *trello.Board {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	board := &trello.Board{ID: f.id(), Name: name}
+	board.ShortUrl = "https://trello.com/b/" + board.ID[16:]
+	f.Boards = append(f.Boards, board)
+	return board
+}
+
+func (f *fakeTrello) AddList(board *trello.Board, name string) *trello.List {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := &trello.List{ID: f.id(), Name: name, IDBoard: board.ID}
+	f.Lists = append(f.Lists, list)
+	return list
+}
+
+func (f *fakeTrello) AddLabel(board *trello.Board, name string) *trello.Label {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	label := &trello.Label{ID: f.id(), IDBoard: board.ID, Name: name}
+	f.Labels = append(f.Labels, label)
+	return label
+}
+
+func (f *fakeTrello) AddCard(list *trello.List, name string, labels ...string) *trello.Card {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	card := &trello.Card{ID: f.id(), Name: name, IDBoard: list.IDBoard, IDList: list.ID}
+	f.touch(card)
+	card.ShortLink = card.ID[16:]
+	card.ShortUrl = "https://trello.com/c/" + card.ShortLink
+	for _, name := range labels {
+		if label := f.labelByName(card.IDBoard, name); label != nil {
+			card.IDLabels = append(card.IDLabels, label.ID)
+		}
+	}
+	f.Cards = append(f.Cards, card)
+	f.moved(card, "createCard", nil)
+	return card
+}
+
+func (f *fakeTrello) AddChecklist(card *trello.Card, name string, items ...string) *trello.Checklist {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	checklist := f.newChecklist(card.ID, name)
+	for _, item := range items {
+		state := "incomplete"
+		if strings.HasPrefix(item, "[x] ") {
+			item, state = item[4:], "complete"
+		}
+		checklist.CheckItems = append(checklist.CheckItems, trello.CheckItem{ID: f.id(), Name: item, State: state, IDChecklist: checklist.ID})
+	}
+	return checklist
+}
+
+// AddComment comments on a card as someone other than miriam
+func (f *fakeTrello) AddComment(card *trello.Card, author string, text string) *trello.Action {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.comment(f.card(card.ID), &trello.Member{ID: f.id(), FullName: author}, text)
+}
+
+func (f *fakeTrello) comment(card *trello.Card, author *trello.Member, text string) *trello.Action {
+	data := &trello.ActionData{Text: text, Card: &trello.ActionDataCard{ID: card.ID, Name: card.Name, ShortLink: card.ShortLink}}
+	action := &trello.Action{ID: f.id(), IDMemberCreator: author.ID, Type: "commentCard", Date: f.Clock.Now(), Data: data, MemberCreator: author}
+	f.Actions = append(f.Actions, action)
+	f.touch(card)
+	return action
+}
+
+func (f *fakeTrello) newChecklist(cardID string, name string) *trello.Checklist {
+	card := f.card(cardID)
+	checklist := &trello.Checklist{ID: f.id(), Name: name, IDCard: cardID, IDBoard: card.IDBoard}
+	card.IDCheckLists = append(card.IDCheckLists, checklist.ID)
+	f.Checklists = append(f.Checklists, checklist)
+	return checklist
+}
+
+// Inspection
+
+// ListOf is the name of the list a card is in
+func (f *fakeTrello) ListOf(card *trello.Card) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if list := f.list(f.card(card.ID).IDList); list != nil {
+		return list.Name
+	}
+	return ""
+}
+
+// BoardOf is the ID of the board a card is on
+func (f *fakeTrello) BoardOf(card *trello.Card) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.card(card.ID).IDBoard
+}
+
+// LabelsOf is the names of a card's labels
+func (f *fakeTrello) LabelsOf(card *trello.Card) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, label := range f.cardLabels(f.card(card.ID)) {
+		names = append(names, label.Name)
+	}
+	return names
+}
+
+// ItemsOf maps the items of a card's checklist to their state, nil if the
+// card has no such checklist
+func (f *fakeTrello) ItemsOf(card *trello.Card, checklist string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.Checklists {
+		if c.IDCard == card.ID && c.Name == checklist {
+			items := map[string]string{}
+			for _, item := range c.CheckItems {
+				items[item.Name] = item.State
+			}
+			return items
+		}
+	}
+	return nil
+}
+
+// CommentsOf is the text of a card's comments, oldest first
+func (f *fakeTrello) CommentsOf(card *trello.Card) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var comments []string
+	for _, action := range f.Actions {
+		if action.Type == "commentCard" && action.Data.Card.ID == card.ID {
+			comments = append(comments, action.Data.Text)
+		}
+	}
+	return comments
+}
+
+// Lookups, called with the lock held
+
+func (f *fakeTrello) board(id string) *trello.Board {
+	for _, board := range f.Boards {
+		if board.ID == id {
+			return board
+		}
+	}
+	return nil
+}
+
+func (f *fakeTrello) list(id string) *trello.List {
+	for _, list := range f.Lists {
+		if list.ID == id {
+			return list
+		}
+	}
+	return nil
+}
+
+func (f *fakeTrello) card(id string) *trello.Card {
+	for _, card := range f.Cards {
+		if card.ID == id || card.ShortLink == id {
+			return card
+		}
+	}
+	return nil
+}
+
+func (f *fakeTrello) checklist(id string) *trello.Checklist {
+	for _, checklist := range f.Checklists {
+		if checklist.ID == id {
+			return checklist
+		}
+	}
+	return nil
+}
+
+func (f *fakeTrello) labelByName(boardID string, name string) *trello.Label {
+	for _, label := range f.Labels {
+		if label.IDBoard == boardID && label.Name == name {
+			return label
+		}
+	}
+	return nil
+}
+
+func (f *fakeTrello) cardLabels(card *trello.Card) []*trello.Label {
+	var labels []*trello.Label
+	for _, id := range card.IDLabels {
+		for _, label := range f.Labels {
+			if label.ID == id {
+				labels = append(labels, label)
+			}
+		}
+	}
+	return labels
+}
+
+// render is a card as the API returns it, with its labels and, when asked
+// for, its checklists and list
+func (f *fakeTrello) render(card *trello.Card, r *http.Request) map[string]interface{} {
+	rendered := map[string]interface{}{
+		"id":               card.ID,
+		"name":             card.Name,
+		"desc":             card.Desc,
+		"closed":           card.Closed,
+		"due":              card.Due,
+		"dueComplete":      card.DueComplete,
+		"shortLink":        card.ShortLink,
+		"shortUrl":         card.ShortUrl,
+		"idBoard":          card.IDBoard,
+		"idList":           card.IDList,
+		"idLabels":         card.IDLabels,
+		"idCheckLists":     card.IDCheckLists,
+		"dateLastActivity": card.DateLastActivity,
+		"labels":           f.cardLabels(card),
+	}
+	if r.FormValue("checklists") == "all" {
+		var checklists []*trello.Checklist
+		for _, checklist := range f.Checklists {
+			if checklist.IDCard == card.ID {
+				checklists = append(checklists, checklist)
+			}
+		}
+		rendered["checklists"] = checklists
+	}
+	if r.FormValue("attachments") == "true" {
+		rendered["attachments"] = card.Attachments
+	}
+	if r.FormValue("list") == "true" {
+		rendered["list"] = f.list(card.IDList)
+	}
+	return rendered
+}
+
+// limitActions keeps the newest actions up to the limit asked for, 50 unless
+// asked for more like Trello
+func limitActions(actions []*trello.Action, r *http.Request) []*trello.Action {
+	limit := 50
+	if n, err := strconv.Atoi(r.FormValue("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+	if len(actions) > limit {
+		return actions[:limit]
+	}
+	return actions
+}
+
+func (f *fakeTrello) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, r.Method+" "+r.URL.Path)
+	if status, ok := f.Fail[r.Method+" "+r.URL.Path]; ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	reply := func(value interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(value)
+	}
+	notFound := func(kind string) {
+		http.Error(w, fmt.Sprintf("The requested %s was not found.", kind), http.StatusNotFound)
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + parts[0]
+	if len(parts) > 2 {
+		route += " " + parts[2]
+	}
+	switch {
+	case route == "GET members" && len(parts) == 2:
+		reply(map[string]string{"id": "fake-member", "username": "miriam"})
+
+	case route == "GET boards" && len(parts) == 2:
+		board := f.board(parts[1])
+		if board == nil {
+			notFound("board")
+			return
+		}
+		reply(board)
+
+	case route == "GET boards lists":
+		lists := []*trello.List{}
+		for _, list := range f.Lists {
+			if list.IDBoard == parts[1] && !list.Closed {
+				lists = append(lists, list)
+			}
+		}
+		reply(lists)
+
+	case route == "GET boards labels":
+		labels := []*trello.Label{}
+		for _, label := range f.Labels {
+			if label.IDBoard == parts[1] {
+				labels = append(labels, label)
+			}
+		}
+		reply(labels)
+
+	case route == "GET lists cards":
+		cards := []map[string]interface{}{}
+		for _, card := range f.Cards {
+			if card.IDList == parts[1] && !card.Closed {
+				cards = append(cards, f.render(card, r))
+			}
+		}
+		reply(cards)
+
+	case route == "GET cards" && len(parts) == 2:
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		reply(f.render(card, r))
+
+	case route == "GET boards actions":
+		if f.board(parts[1]) == nil {
+			notFound("board")
+			return
+		}
+		var kinds []string
+		for _, kind := range strings.Split(r.FormValue("filter"), ",") {
+			kinds = append(kinds, strings.SplitN(kind, ":", 2)[0])
+		}
+		actions := []*trello.Action{}
+		for i := len(f.Actions) - 1; i >= 0; i-- {
+			action := f.Actions[i]
+			if action.Data.Board != nil && action.Data.Board.ID == parts[1] && (r.FormValue("filter") == "" || containsString(kinds, action.Type)) {
+				actions = append(actions, action)
+			}
+		}
+		reply(limitActions(actions, r))
+
+	case route == "GET cards actions":
+		if f.card(parts[1]) == nil {
+			notFound("card")
+			return
+		}
+		var kinds []string
+		for _, kind := range strings.Split(r.FormValue("filter"), ",") {
+			kinds = append(kinds, strings.SplitN(kind, ":", 2)[0])
+		}
+		// Newest first, like Trello
+		actions := []*trello.Action{}
+		for i := len(f.Actions) - 1; i >= 0; i-- {
+			action := f.Actions[i]
+			if action.Data.Card.ID == parts[1] && (r.FormValue("filter") == "" || containsString(kinds, action.Type)) {
+				actions = append(actions, action)
+			}
+		}
+		reply(limitActions(actions, r))
+
+	case route == "PUT cards" && len(parts) == 2:
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		before := f.list(card.IDList)
+		if boardID := r.FormValue("idBoard"); boardID != "" && boardID != card.IDBoard {
+			if f.board(boardID) == nil {
+				notFound("board")
+				return
+			}
+			// Trello puts a card moved between boards in the first list
+			card.IDBoard, card.IDList = boardID, ""
+			for _, list := range f.Lists {
+				if list.IDBoard == boardID && !list.Closed {
+					card.IDList = list.ID
+					break
+				}
+			}
+		}
+		if listID := r.FormValue("idList"); listID != "" {
+			list := f.list(listID)
+			if list == nil {
+				notFound("list")
+				return
+			}
+			card.IDList, card.IDBoard = list.ID, list.IDBoard
+		}
+		if before == nil || card.IDBoard != before.IDBoard {
+			f.moved(card, "moveCardToBoard", before)
+		} else if card.IDList != before.ID {
+			f.moved(card, "updateCard", before)
+		}
+		if name := r.FormValue("name"); name != "" {
+			card.Name = name
+		}
+		if _, ok := r.Form["desc"]; ok {
+			card.Desc = r.FormValue("desc")
+		}
+		if closed := r.FormValue("closed"); closed != "" && card.Closed != (closed == "true") {
+			card.Closed = closed == "true"
+			f.moved(card, "updateCard", nil)
+		}
+		if due := r.FormValue("due"); due == "null" {
+			card.Due = nil
+		} else if due != "" {
+			at, err := time.Parse(time.RFC3339, due)
+			if err != nil {
+				http.Error(w, "invalid value for due", http.StatusBadRequest)
+				return
+			}
+			card.Due = &at
+		}
+		if complete := r.FormValue("dueComplete"); complete != "" {
+			card.DueComplete = complete == "true"
+		}
+		f.touch(card)
+		reply(f.render(card, r))
+
+	case route == "POST cards" && len(parts) == 1:
+		list := f.list(r.FormValue("idList"))
+		if list == nil {
+			notFound("list")
+			return
+		}
+		card := &trello.Card{ID: f.id(), Name: r.FormValue("name"), IDBoard: list.IDBoard, IDList: list.ID}
+		card.ShortLink = card.ID[16:]
+		card.ShortUrl = "https://trello.com/c/" + card.ShortLink
+		f.touch(card)
+		f.Cards = append(f.Cards, card)
+		f.moved(card, "createCard", nil)
+		reply(f.render(card, r))
+
+	case route == "POST cards idLabels":
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		labelID := r.FormValue("value")
+		for _, id := range card.IDLabels {
+			if id == labelID {
+				http.Error(w, "that label is already on the card", http.StatusBadRequest)
+				return
+			}
+		}
+		card.IDLabels = append(card.IDLabels, labelID)
+		f.touch(card)
+		reply(card.IDLabels)
+
+	case route == "DELETE cards idLabels" && len(parts) == 4:
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		var remaining []string
+		for _, id := range card.IDLabels {
+			if id != parts[3] {
+				remaining = append(remaining, id)
+			}
+		}
+		card.IDLabels = remaining
+		f.touch(card)
+		reply(map[string]interface{}{"_value": nil})
+
+	case route == "POST cards attachments":
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		attachment := &trello.Attachment{ID: f.id(), Name: r.FormValue("name"), URL: r.FormValue("url")}
+		card.Attachments = append(card.Attachments, attachment)
+		f.touch(card)
+		reply(attachment)
+
+	case route == "DELETE cards attachments" && len(parts) == 4:
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		for i, attachment := range card.Attachments {
+			if attachment.ID == parts[3] {
+				card.Attachments = append(card.Attachments[:i], card.Attachments[i+1:]...)
+				f.touch(card)
+				reply(map[string]interface{}{"_value": nil})
+				return
+			}
+		}
+		notFound("attachment")
+
+	case route == "POST cards actions" && len(parts) == 4 && parts[3] == "comments":
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		reply(f.comment(card, &trello.Member{ID: "fake-member", Username: "miriam", FullName: "Miriam"}, r.FormValue("text")))
+
+	case route == "DELETE actions" && len(parts) == 2:
+		for i, action := range f.Actions {
+			if action.ID == parts[1] && action.Type == "commentCard" {
+				f.Actions = append(f.Actions[:i], f.Actions[i+1:]...)
+				reply(map[string]interface{}{"_value": nil})
+				return
+			}
+		}
+		notFound("action")
+
+	case route == "POST cards checklists":
+		card := f.card(parts[1])
+		if card == nil {
+			notFound("card")
+			return
+		}
+		f.touch(card)
+		reply(f.newChecklist(parts[1], r.FormValue("name")))
+
+	case route == "PUT cards checkItem" && len(parts) == 4:
+		for _, checklist := range f.Checklists {
+			for i, item := range checklist.CheckItems {
+				if item.ID != parts[3] || checklist.IDCard != parts[1] {
+					continue
+				}
+				if state := r.FormValue("state"); state != "" {
+					item.State = state
+				}
+				if name := r.FormValue("name"); name != "" {
+					item.Name = name
+				}
+				if target := r.FormValue("idChecklist"); target != "" && target != checklist.ID {
+					destination := f.checklist(target)
+					if destination == nil {
+						notFound("checklist")
+						return
+					}
+					checklist.CheckItems = append(checklist.CheckItems[:i], checklist.CheckItems[i+1:]...)
+					item.IDChecklist = destination.ID
+					destination.CheckItems = append(destination.CheckItems, item)
+				} else {
+					checklist.CheckItems[i] = item
+				}
+				if card := f.card(parts[1]); card != nil {
+					f.touch(card)
+				}
+				reply(item)
+				return
+			}
+		}
+		notFound("check item")
+
+	case route == "DELETE checklists" && len(parts) == 2:
+		for i, checklist := range f.Checklists {
+			if checklist.ID == parts[1] {
+				f.Checklists = append(f.Checklists[:i], f.Checklists[i+1:]...)
+				if card := f.card(checklist.IDCard); card != nil {
+					var remaining []string
+					for _, id := range card.IDCheckLists {
+						if id != checklist.ID {
+							remaining = append(remaining, id)
+						}
+					}
+					card.IDCheckLists = remaining
+				}
+				reply(map[string]interface{}{"_value": nil})
+				return
+			}
+		}
+		notFound("checklist")
+
+	default:
+		http.Error(w, fmt.Sprintf("fake trello does not implement %s %s", r.Method, r.URL.Path), http.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,816 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// fakeWunderlist is an in-memory Wunderlist serving the endpoints miriam
+// uses. Like the real API it rejects updates and deletes made with a stale
+// revision.
+type fakeWunderlist struct {
+	mu      sync.Mutex
+	server  *httptest.Server
+	nextID  uint
+	User    wunderlist.User
+	Lists   []wunderlist.List
+	Folders []*wunderlist.Folder
+	Tasks   []*wunderlist.Task
+	// Notes, subtasks, reminders and comments belong to a task and are
+	// removed with it
+	Notes        []*wunderlist.Note
+	Subtasks     []*wunderlist.Subtask
+	Reminders    []*wunderlist.Reminder
+	TaskComments []*wunderlist.TaskComment
+	// Requests is every request served, as "METHOD path"
+	Requests []string
+	// Fail answers matching "METHOD path" requests with an error status
+	Fail map[string]int
+	// Clock stamps created and completed tasks
+	Clock Clock
+}
+
+func newFakeWunderlist() *fakeWunderlist {
+	f := &fakeWunderlist{nextID: 1000, Fail: map[string]int{}, Clock: systemClock{}}
+	f.User = wunderlist.User{ID: f.id(), Name: "Miriam", Email: "miriam@example.com"}
+	f.Lists = []wunderlist.List{{ID: f.id(), Title: "inbox", ListType: "inbox"}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeWunderlist) URL() string {
+	return f.server.URL
+}
+
+func (f *fakeWunderlist) Close() {
+	f.server.Close()
+}
+
+func (f *fakeWunderlist) id() uint {
+	f.nextID++
+	return f.nextID
+}
+
+// Inbox is the list miriam creates its tasks in
+func (f *fakeWunderlist) Inbox() wunderlist.List {
+	return f.Lists[0]
+}
+
+// AddTask seeds a task in the inbox
+func (f *fakeWunderlist) AddTask(title string, completed bool) *wunderlist.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	task := &wunderlist.Task{ID: f.id(), Title: title, ListID: f.Inbox().ID, Completed: completed, Revision: 1, CreatedAt: f.Clock.Now()}
+	if completed {
+		task.CompletedAt = f.Clock.Now()
+	}
+	f.Tasks = append(f.Tasks, task)
+	return task
+}
+
+// Find returns copies of the tasks whose title contains the given text
+func (f *fakeWunderlist) Find(text string) []wunderlist.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []wunderlist.Task
+	for _, task := range f.Tasks {
+		if strings.Contains(task.Title, text) {
+			found = append(found, *task)
+		}
+	}
+	return found
+}
+
+// NoteOf returns a copy of a task's note, nil if it has none
+func (f *fakeWunderlist) NoteOf(taskID uint) *wunderlist.Note {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, note := range f.Notes {
+		if note.TaskID == taskID {
+			found := *note
+			return &found
+		}
+	}
+	return nil
+}
+
+func (f *fakeWunderlist) note(id uint) *wunderlist.Note {
+	for _, note := range f.Notes {
+		if note.ID == id {
+			return note
+		}
+	}
+	return nil
+}
+
+// AddSubtask seeds a subtask of a task
+func (f *fakeWunderlist) AddSubtask(task *wunderlist.Task, title string, completed bool) *wunderlist.Subtask {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subtask := &wunderlist.Subtask{ID: f.id(), TaskID: task.ID, Title: title, Completed: completed, Revision: 1, CreatedAt: f.Clock.Now()}
+	f.Subtasks = append(f.Subtasks, subtask)
+	return subtask
+}
+
+// SubtasksOf returns copies of the subtasks of a task
+func (f *fakeWunderlist) SubtasksOf(taskID uint) []wunderlist.Subtask {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []wunderlist.Subtask
+	for _, subtask := range f.Subtasks {
+		if subtask.TaskID == taskID {
+			found = append(found, *subtask)
+		}
+	}
+	return found
+}
+
+func (f *fakeWunderlist) subtask(id uint) *wunderlist.Subtask {
+	for _, subtask := range f.Subtasks {
+		if subtask.ID == id {
+			return subtask
+		}
+	}
+	return nil
+}
+
+// RemindersOf returns copies of the reminders on a task
+func (f *fakeWunderlist) RemindersOf(taskID uint) []wunderlist.Reminder {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []wunderlist.Reminder
+	for _, reminder := range f.Reminders {
+		if reminder.TaskID == taskID {
+			found = append(found, *reminder)
+		}
+	}
+	return found
+}
+
+func (f *fakeWunderlist) reminder(id uint) *wunderlist.Reminder {
+	for _, reminder := range f.Reminders {
+		if reminder.ID == id {
+			return reminder
+		}
+	}
+	return nil
+}
+
+// ListNamed returns a copy of the list with the title, nil if there isn't one
+func (f *fakeWunderlist) ListNamed(title string) *wunderlist.List {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, list := range f.Lists {
+		if list.Title == title {
+			found := list
+			return &found
+		}
+	}
+	return nil
+}
+
+// FolderOf is the title of the folder a list is in, empty if it's in none
+func (f *fakeWunderlist) FolderOf(listID uint) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, folder := range f.Folders {
+		for _, id := range folder.ListIDs {
+			if id == listID {
+				return folder.Title
+			}
+		}
+	}
+	return ""
+}
+
+func (f *fakeWunderlist) list(id uint) *wunderlist.List {
+	for i := range f.Lists {
+		if f.Lists[i].ID == id {
+			return &f.Lists[i]
+		}
+	}
+	return nil
+}
+
+func (f *fakeWunderlist) folder(id uint) *wunderlist.Folder {
+	for _, folder := range f.Folders {
+		if folder.ID == id {
+			return folder
+		}
+	}
+	return nil
+}
+
+// AddTaskComment seeds a comment on a task
+func (f *fakeWunderlist) AddTaskComment(task *wunderlist.Task, text string) *wunderlist.TaskComment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	comment := &wunderlist.TaskComment{ID: f.id(), TaskID: task.ID, Text: text, Revision: 1, CreatedAt: f.Clock.Now()}
+	f.TaskComments = append(f.TaskComments, comment)
+	return comment
+}
+
+// TaskCommentsOf is the text of a task's comments, oldest first
+func (f *fakeWunderlist) TaskCommentsOf(taskID uint) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []string
+	for _, comment := range f.TaskComments {
+		if comment.TaskID == taskID {
+			found = append(found, comment.Text)
+		}
+	}
+	return found
+}
+
+func (f *fakeWunderlist) taskComment(id uint) *wunderlist.TaskComment {
+	for _, comment := range f.TaskComments {
+		if comment.ID == id {
+			return comment
+		}
+	}
+	return nil
+}
+
+// removeChildren removes the notes, subtasks, reminders and comments of a
+// deleted task
+func (f *fakeWunderlist) removeChildren(taskID uint) {
+	var notes []*wunderlist.Note
+	for _, note := range f.Notes {
+		if note.TaskID != taskID {
+			notes = append(notes, note)
+		}
+	}
+	f.Notes = notes
+	var subtasks []*wunderlist.Subtask
+	for _, subtask := range f.Subtasks {
+		if subtask.TaskID != taskID {
+			subtasks = append(subtasks, subtask)
+		}
+	}
+	var reminders []*wunderlist.Reminder
+	for _, reminder := range f.Reminders {
+		if reminder.TaskID != taskID {
+			reminders = append(reminders, reminder)
+		}
+	}
+	var comments []*wunderlist.TaskComment
+	for _, comment := range f.TaskComments {
+		if comment.TaskID != taskID {
+			comments = append(comments, comment)
+		}
+	}
+	f.Subtasks, f.Reminders, f.TaskComments = subtasks, reminders, comments
+}
+
+func (f *fakeWunderlist) task(id uint) *wunderlist.Task {
+	for _, task := range f.Tasks {
+		if task.ID == id {
+			return task
+		}
+	}
+	return nil
+}
+
+// transport is a task as the API sends it, with a date-only due date
+func transport(task *wunderlist.Task) map[string]interface{} {
+	due := ""
+	if !task.DueDate.IsZero() {
+		due = task.DueDate.Format("2006-01-02")
+	}
+	return map[string]interface{}{
+		"id":               task.ID,
+		"assignee_id":      task.AssigneeID,
+		"created_at":       task.CreatedAt,
+		"due_date":         due,
+		"list_id":          task.ListID,
+		"revision":         task.Revision,
+		"starred":          task.Starred,
+		"title":            task.Title,
+		"completed":        task.Completed,
+		"completed_at":     task.CompletedAt,
+		"recurrence_type":  task.RecurrenceType,
+		"recurrence_count": task.RecurrenceCount,
+	}
+}
+
+func (f *fakeWunderlist) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, r.Method+" "+r.URL.Path)
+	if status, ok := f.Fail[r.Method+" "+r.URL.Path]; ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	reply := func(status int, value interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(value)
+	}
+	if r.Header.Get("X-Access-Token") == "" || r.Header.Get("X-Client-ID") == "" {
+		reply(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var taskID uint
+	if len(parts) == 2 && parts[0] == "tasks" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad task id"})
+			return
+		}
+		taskID = uint(id)
+	}
+	var note *wunderlist.Note
+	if len(parts) == 2 && parts[0] == "notes" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad note id"})
+			return
+		}
+		if note = f.note(uint(id)); note == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+	var subtask *wunderlist.Subtask
+	if len(parts) == 2 && parts[0] == "subtasks" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad subtask id"})
+			return
+		}
+		if subtask = f.subtask(uint(id)); subtask == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+	var reminder *wunderlist.Reminder
+	if len(parts) == 2 && parts[0] == "reminders" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad reminder id"})
+			return
+		}
+		if reminder = f.reminder(uint(id)); reminder == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+	var comment *wunderlist.TaskComment
+	if len(parts) == 2 && parts[0] == "task_comments" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad task comment id"})
+			return
+		}
+		if comment = f.taskComment(uint(id)); comment == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+	var list *wunderlist.List
+	if len(parts) == 2 && parts[0] == "lists" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad list id"})
+			return
+		}
+		if list = f.list(uint(id)); list == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+	var folder *wunderlist.Folder
+	if len(parts) == 2 && parts[0] == "folders" {
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "bad folder id"})
+			return
+		}
+		if folder = f.folder(uint(id)); folder == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+	var task *wunderlist.Task
+	if taskID != 0 {
+		if task = f.task(taskID); task == nil {
+			reply(http.StatusNotFound, map[string]string{"error": "not_found"})
+			return
+		}
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/user":
+		reply(http.StatusOK, f.User)
+
+	case r.Method == "GET" && r.URL.Path == "/lists":
+		reply(http.StatusOK, f.Lists)
+
+	case r.Method == "GET" && r.URL.Path == "/tasks":
+		listID, _ := strconv.ParseUint(r.FormValue("list_id"), 10, 64)
+		completed := r.FormValue("completed") == "true"
+		tasks := []map[string]interface{}{}
+		for _, task := range f.Tasks {
+			if task.ListID == uint(listID) && task.Completed == completed {
+				tasks = append(tasks, transport(task))
+			}
+		}
+		reply(http.StatusOK, tasks)
+
+	case r.Method == "GET" && r.URL.Path == "/notes":
+		listID, _ := strconv.ParseUint(r.FormValue("list_id"), 10, 64)
+		notes := []*wunderlist.Note{}
+		for _, note := range f.Notes {
+			if owner := f.task(note.TaskID); owner != nil && owner.ListID == uint(listID) {
+				notes = append(notes, note)
+			}
+		}
+		reply(http.StatusOK, notes)
+
+	case r.Method == "GET" && note != nil:
+		reply(http.StatusOK, note)
+
+	case r.Method == "POST" && r.URL.Path == "/notes":
+		var create struct {
+			Content string `json:"content"`
+			TaskID  uint   `json:"task_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || f.task(create.TaskID) == nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid note"})
+			return
+		}
+		// Like the real API, a task has one note
+		for _, existing := range f.Notes {
+			if existing.TaskID == create.TaskID {
+				reply(http.StatusConflict, map[string]string{"error": "task already has a note"})
+				return
+			}
+		}
+		note := &wunderlist.Note{ID: f.id(), TaskID: create.TaskID, Content: create.Content, Revision: 1, CreatedAt: f.Clock.Now()}
+		f.Notes = append(f.Notes, note)
+		reply(http.StatusCreated, note)
+
+	case r.Method == "PATCH" && note != nil:
+		var update wunderlist.Note
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid update"})
+			return
+		}
+		if update.Revision != note.Revision {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		note.Content, note.UpdatedAt = update.Content, f.Clock.Now()
+		note.Revision++
+		reply(http.StatusOK, note)
+
+	case r.Method == "DELETE" && note != nil:
+		if r.FormValue("revision") != fmt.Sprint(note.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		for i, existing := range f.Notes {
+			if existing == note {
+				f.Notes = append(f.Notes[:i], f.Notes[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && list != nil:
+		reply(http.StatusOK, list)
+
+	case r.Method == "POST" && r.URL.Path == "/lists":
+		var create struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || create.Title == "" {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid list"})
+			return
+		}
+		list := wunderlist.List{ID: f.id(), Title: create.Title, ListType: "list", Revision: 1, CreatedAt: f.Clock.Now()}
+		f.Lists = append(f.Lists, list)
+		reply(http.StatusCreated, list)
+
+	case r.Method == "DELETE" && list != nil:
+		if r.FormValue("revision") != fmt.Sprint(list.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		// Like the real API, the list's tasks go with it
+		id := list.ID
+		var tasks []*wunderlist.Task
+		for _, task := range f.Tasks {
+			if task.ListID == id {
+				f.removeChildren(task.ID)
+			} else {
+				tasks = append(tasks, task)
+			}
+		}
+		f.Tasks = tasks
+		for _, folder := range f.Folders {
+			var listIDs []uint
+			for _, listID := range folder.ListIDs {
+				if listID != id {
+					listIDs = append(listIDs, listID)
+				}
+			}
+			folder.ListIDs = listIDs
+		}
+		for i := range f.Lists {
+			if f.Lists[i].ID == id {
+				f.Lists = append(f.Lists[:i], f.Lists[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && r.URL.Path == "/folders":
+		folders := []*wunderlist.Folder{}
+		reply(http.StatusOK, append(folders, f.Folders...))
+
+	case r.Method == "GET" && folder != nil:
+		reply(http.StatusOK, folder)
+
+	case r.Method == "POST" && r.URL.Path == "/folders":
+		var create struct {
+			Title   string `json:"title"`
+			ListIDs []uint `json:"list_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || create.Title == "" || len(create.ListIDs) == 0 {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid folder"})
+			return
+		}
+		folder := &wunderlist.Folder{ID: f.id(), Title: create.Title, ListIDs: create.ListIDs, Revision: 1, CreatedAt: f.Clock.Now()}
+		f.Folders = append(f.Folders, folder)
+		reply(http.StatusCreated, folder)
+
+	case r.Method == "PATCH" && folder != nil:
+		var update wunderlist.Folder
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid update"})
+			return
+		}
+		if update.Revision != folder.Revision {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		folder.Title, folder.ListIDs, folder.UpdatedAt = update.Title, update.ListIDs, f.Clock.Now()
+		folder.Revision++
+		reply(http.StatusOK, folder)
+
+	case r.Method == "DELETE" && folder != nil:
+		if r.FormValue("revision") != fmt.Sprint(folder.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		for i, existing := range f.Folders {
+			if existing == folder {
+				f.Folders = append(f.Folders[:i], f.Folders[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && r.URL.Path == "/task_comments":
+		listID, _ := strconv.ParseUint(r.FormValue("list_id"), 10, 64)
+		comments := []*wunderlist.TaskComment{}
+		for _, comment := range f.TaskComments {
+			if owner := f.task(comment.TaskID); owner != nil && owner.ListID == uint(listID) {
+				comments = append(comments, comment)
+			}
+		}
+		reply(http.StatusOK, comments)
+
+	case r.Method == "GET" && comment != nil:
+		reply(http.StatusOK, comment)
+
+	case r.Method == "POST" && r.URL.Path == "/task_comments":
+		var create struct {
+			Text   string `json:"text"`
+			TaskID uint   `json:"task_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || f.task(create.TaskID) == nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid task comment"})
+			return
+		}
+		comment := &wunderlist.TaskComment{ID: f.id(), TaskID: create.TaskID, Text: create.Text, Revision: 1, CreatedAt: f.Clock.Now()}
+		f.TaskComments = append(f.TaskComments, comment)
+		reply(http.StatusCreated, comment)
+
+	case r.Method == "DELETE" && comment != nil:
+		if r.FormValue("revision") != fmt.Sprint(comment.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		for i, existing := range f.TaskComments {
+			if existing == comment {
+				f.TaskComments = append(f.TaskComments[:i], f.TaskComments[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && r.URL.Path == "/subtasks":
+		listID, _ := strconv.ParseUint(r.FormValue("list_id"), 10, 64)
+		completed := r.FormValue("completed") == "true"
+		subtasks := []*wunderlist.Subtask{}
+		for _, subtask := range f.Subtasks {
+			if owner := f.task(subtask.TaskID); owner != nil && owner.ListID == uint(listID) && subtask.Completed == completed {
+				subtasks = append(subtasks, subtask)
+			}
+		}
+		reply(http.StatusOK, subtasks)
+
+	case r.Method == "GET" && subtask != nil:
+		reply(http.StatusOK, subtask)
+
+	case r.Method == "POST" && r.URL.Path == "/subtasks":
+		var create struct {
+			Title     string `json:"title"`
+			TaskID    uint   `json:"task_id"`
+			Completed bool   `json:"completed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || create.Title == "" || f.task(create.TaskID) == nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid subtask"})
+			return
+		}
+		subtask := &wunderlist.Subtask{ID: f.id(), TaskID: create.TaskID, Title: create.Title, Completed: create.Completed, Revision: 1, CreatedAt: f.Clock.Now()}
+		f.Subtasks = append(f.Subtasks, subtask)
+		reply(http.StatusCreated, subtask)
+
+	case r.Method == "PATCH" && subtask != nil:
+		var update wunderlist.Subtask
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid update"})
+			return
+		}
+		if update.Revision != subtask.Revision {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		if update.Title != "" {
+			subtask.Title = update.Title
+		}
+		if update.Completed && !subtask.Completed {
+			subtask.CompletedAt = f.Clock.Now()
+		}
+		subtask.Completed = update.Completed
+		subtask.Revision++
+		reply(http.StatusOK, subtask)
+
+	case r.Method == "DELETE" && subtask != nil:
+		if r.FormValue("revision") != fmt.Sprint(subtask.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		for i, existing := range f.Subtasks {
+			if existing == subtask {
+				f.Subtasks = append(f.Subtasks[:i], f.Subtasks[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && r.URL.Path == "/reminders":
+		listID, _ := strconv.ParseUint(r.FormValue("list_id"), 10, 64)
+		reminders := []*wunderlist.Reminder{}
+		for _, reminder := range f.Reminders {
+			if owner := f.task(reminder.TaskID); owner != nil && owner.ListID == uint(listID) {
+				reminders = append(reminders, reminder)
+			}
+		}
+		reply(http.StatusOK, reminders)
+
+	case r.Method == "GET" && reminder != nil:
+		reply(http.StatusOK, reminder)
+
+	case r.Method == "POST" && r.URL.Path == "/reminders":
+		var create struct {
+			Date   string `json:"date"`
+			TaskID uint   `json:"task_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || f.task(create.TaskID) == nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid reminder"})
+			return
+		}
+		if _, err := time.Parse(reminderLayout, create.Date); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid date"})
+			return
+		}
+		reminder := &wunderlist.Reminder{ID: f.id(), Date: create.Date, TaskID: create.TaskID, Revision: 1, CreatedAt: f.Clock.Now()}
+		f.Reminders = append(f.Reminders, reminder)
+		reply(http.StatusCreated, reminder)
+
+	case r.Method == "DELETE" && reminder != nil:
+		if r.FormValue("revision") != fmt.Sprint(reminder.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		for i, existing := range f.Reminders {
+			if existing == reminder {
+				f.Reminders = append(f.Reminders[:i], f.Reminders[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && task != nil:
+		reply(http.StatusOK, transport(task))
+
+	case r.Method == "POST" && r.URL.Path == "/tasks":
+		var create struct {
+			ListID     uint   `json:"list_id"`
+			Title      string `json:"title"`
+			AssigneeID uint   `json:"assignee_id"`
+			Completed  bool   `json:"completed"`
+			DueDate    string `json:"due_date"`
+			Starred    bool   `json:"starred"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil || create.Title == "" || f.list(create.ListID) == nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid task"})
+			return
+		}
+		task := &wunderlist.Task{ID: f.id(), Title: create.Title, ListID: create.ListID, AssigneeID: create.AssigneeID, Completed: create.Completed, Starred: create.Starred, Revision: 1, CreatedAt: f.Clock.Now()}
+		if create.DueDate != "" {
+			task.DueDate, _ = time.Parse("2006-01-02", create.DueDate)
+		}
+		f.Tasks = append(f.Tasks, task)
+		reply(http.StatusCreated, transport(task))
+
+	case r.Method == "PATCH" && task != nil:
+		var update struct {
+			Title      string   `json:"title"`
+			Revision   uint     `json:"revision"`
+			AssigneeID uint     `json:"assignee_id"`
+			ListID     uint     `json:"list_id"`
+			Completed  bool     `json:"completed"`
+			DueDate    string   `json:"due_date"`
+			Starred    bool     `json:"starred"`
+			Remove     []string `json:"remove"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			reply(http.StatusBadRequest, map[string]string{"error": "invalid update"})
+			return
+		}
+		if update.Revision != task.Revision {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		if update.Title != "" {
+			task.Title = update.Title
+		}
+		if update.ListID != 0 {
+			task.ListID = update.ListID
+		}
+		if update.AssigneeID != 0 {
+			task.AssigneeID = update.AssigneeID
+		}
+		if update.DueDate != "" {
+			task.DueDate, _ = time.Parse("2006-01-02", update.DueDate)
+		}
+		for _, field := range update.Remove {
+			switch field {
+			case "assignee_id":
+				task.AssigneeID = 0
+			case "due_date":
+				task.DueDate = time.Time{}
+			}
+		}
+		if update.Completed && !task.Completed {
+			task.CompletedAt = f.Clock.Now()
+		}
+		task.Completed, task.Starred = update.Completed, update.Starred
+		task.Revision++
+		reply(http.StatusOK, transport(task))
+
+	case r.Method == "DELETE" && task != nil:
+		if r.FormValue("revision") != fmt.Sprint(task.Revision) {
+			reply(http.StatusConflict, map[string]string{"error": "revision conflict"})
+			return
+		}
+		for i, existing := range f.Tasks {
+			if existing == task {
+				f.Tasks = append(f.Tasks[:i], f.Tasks[i+1:]...)
+				break
+			}
+		}
+		f.removeChildren(task.ID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		reply(http.StatusNotImplemented, map[string]string{"error": fmt.Sprintf("fake wunderlist does not implement %s %s", r.Method, r.URL.Path)})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Both the Trello and Wunderlist clients send their requests through
+// http.DefaultTransport, so recording and replaying swap it out
+var liveTransport = http.DefaultTransport
+
+// Query parameters that carry credentials
+var credentialParams = []string{"key", "token", "access_token", "client_id"}
+
+const redacted = "REDACTED"
+
+// httpExchange is one recorded request and its response, a line in a
+// fixture file
+type httpExchange struct {
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	RequestBody  string `json:"request_body,omitempty"`
+	Status       int    `json:"status"`
+	ContentType  string `json:"content_type,omitempty"`
+	ResponseBody string `json:"response_body"`
+}
+
+// scrubber removes credentials from URLs and bodies. Secrets shorter than 8
+// characters aren't scrubbed from bodies, they would match too much.
+type scrubber struct {
+	Secrets []string
+}
+
+func (s scrubber) String(value string) string {
+	for _, secret := range s.Secrets {
+		if len(secret) >= 8 {
+			value = strings.Replace(value, secret, redacted, -1)
+		}
+	}
+	return value
+}
+
+// URL is the request URL with credential parameters redacted and the query
+// sorted, the key replayed requests are matched on
+func (s scrubber) URL(u *url.URL) string {
+	query := u.Query()
+	for _, param := range credentialParams {
+		if _, ok := query[param]; ok {
+			query.Set(param, redacted)
+		}
+	}
+	scrubbed := fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
+	if len(query) > 0 {
+		scrubbed += "?" + query.Encode()
+	}
+	return s.String(scrubbed)
+}
+
+// recordingTransport passes requests on to the live services and appends
+// each exchange, scrubbed, to a fixture file
+type recordingTransport struct {
+	Base  http.RoundTripper
+	Path  string
+	Scrub scrubber
+	mu    sync.Mutex
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	outgoing := req
+	if req.Body != nil {
+		var err error
+		if requestBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		outgoing = req.WithContext(req.Context())
+		outgoing.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+	resp, err := t.Base.RoundTrip(outgoing)
+	if err != nil {
+		return resp, err
+	}
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	exchange := httpExchange{
+		Method:       req.Method,
+		URL:          t.Scrub.URL(req.URL),
+		RequestBody:  t.Scrub.String(string(requestBody)),
+		Status:       resp.StatusCode,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ResponseBody: t.Scrub.String(string(responseBody)),
+	}
+	if err := t.append(exchange); err != nil {
+		log.Println(err)
+	}
+	return resp, nil
+}
+
+func (t *recordingTransport) append(exchange httpExchange) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return errors.Wrapf(err, "Error encoding %s %s", exchange.Method, exchange.URL)
+	}
+	f, err := os.OpenFile(t.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening fixture file %s", t.Path)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// replayTransport answers requests from a fixture file without touching the
+// network. Matching exchanges are used in the order they were recorded, then
+// the last one is repeated, so a replayed run sees the same sequence of
+// responses as the recorded one.
+type replayTransport struct {
+	Exchanges []httpExchange
+	Scrub     scrubber
+	used      []bool
+	mu        sync.Mutex
+}
+
+// ReadFixtures loads every exchange from a fixture file
+func ReadFixtures(path string) ([]httpExchange, error) {
+	var exchanges []httpExchange
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening fixture file %s", path)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var exchange httpExchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, errors.Wrapf(err, "Error parsing fixture file %s line %d", path, line)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, scanner.Err()
+}
+
+func newReplayTransport(exchanges []httpExchange, scrub scrubber) *replayTransport {
+	return &replayTransport{Exchanges: exchanges, Scrub: scrub, used: make([]bool, len(exchanges))}
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := t.Scrub.URL(req.URL)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last := -1
+	for i, exchange := range t.Exchanges {
+		if exchange.Method != req.Method || exchange.URL != key {
+			continue
+		}
+		last = i
+		if !t.used[i] {
+			t.used[i] = true
+			return t.response(req, exchange), nil
+		}
+	}
+	if last >= 0 {
+		return t.response(req, t.Exchanges[last]), nil
+	}
+	return nil, fmt.Errorf("No recorded response for %s %s", req.Method, key)
+}
+
+func (t *replayTransport) response(req *http.Request, exchange httpExchange) *http.Response {
+	header := http.Header{}
+	if exchange.ContentType != "" {
+		header.Set("Content-Type", exchange.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(exchange.ResponseBody)),
+		ContentLength: int64(len(exchange.ResponseBody)),
+		Request:       req,
+	}
+}
+
+// Unused is the recorded exchanges a replay never asked for, usually a sign
+// that the run took a different path than the recorded one
+func (t *replayTransport) Unused() []httpExchange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unused []httpExchange
+	for i, exchange := range t.Exchanges {
+		if !t.used[i] {
+			unused = append(unused, exchange)
+		}
+	}
+	return unused
+}
+
+// configScrubber redacts every secret in the configuration
+func configScrubber(c *Config) scrubber {
+	return scrubber{Secrets: []string{c.TrelloKey, c.TrelloToken, c.WunderlistAccessToken, c.WunderlistClientID}}
+}
+
+// recordHTTP sends all traffic to the live services and records it to a
+// fixture file
+func recordHTTP(path string, c *Config) {
+	http.DefaultTransport = &recordingTransport{Base: liveTransport, Path: path, Scrub: configScrubber(c)}
+}
+
+// replayHTTP serves all traffic from a fixture file
+func replayHTTP(path string, c *Config) (*replayTransport, error) {
+	exchanges, err := ReadFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	replay := newReplayTransport(exchanges, configScrubber(c))
+	http.DefaultTransport = replay
+	return replay, nil
+}
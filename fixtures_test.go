@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScrubberURL(t *testing.T) {
+	s := scrubber{Secrets: []string{"secret-token-value"}}
+	u, _ := url.Parse("https://api.trello.com/1/cards/abc?token=secret-token-value&key=k&fields=name&note=secret-token-value")
+	expected := "https://api.trello.com/1/cards/abc?fields=name&key=REDACTED&note=REDACTED&token=REDACTED"
+	if scrubbed := s.URL(u); scrubbed != expected {
+		t.Errorf("expected %v, got %v", expected, scrubbed)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	defer func() { http.DefaultTransport = liveTransport }()
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.ToDo, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+	w.Trello.AddChecklist(goal, "Backlog", "Write a CLI")
+	fixture := filepath.Join(w.dir, "fixture.jsonl")
+
+	recordHTTP(fixture, w.App.Config)
+	if err := w.App.runJobs("goal-promotion", "task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := w.App.Config
+	for _, secret := range []string{c.TrelloKey, c.TrelloToken, c.WunderlistAccessToken, c.WunderlistClientID} {
+		if strings.Contains(string(contents), secret) {
+			t.Errorf("expected %s to be scrubbed from the fixture", secret)
+		}
+	}
+
+	// Nothing can reach the fake servers after this
+	w.Trello.Close()
+	w.Wunderlist.Close()
+	transport, err := replayHTTP(fixture, w.App.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.App.runJobs("goal-promotion", "task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	if unused := transport.Unused(); len(unused) > 0 {
+		t.Errorf("expected every recorded request to be replayed, %v were not", len(unused))
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	transport := newReplayTransport([]httpExchange{
+		{Method: "GET", URL: "https://a.wunderlist.com/api/v1/user", Status: 200, ResponseBody: `{"id":1}`},
+	}, scrubber{})
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://a.wunderlist.com/api/v1/user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || string(body) != `{"id":1}` {
+			t.Errorf("unexpected replayed response %v %s", resp.StatusCode, body)
+		}
+	}
+	if _, err := client.Get("https://a.wunderlist.com/api/v1/lists"); err == nil {
+		t.Error("expected an error for a request that was never recorded")
+	}
+}
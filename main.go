@@ -1,43 +1,32 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
+	"os"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/adlio/trello"
-	"github.com/matthew-parlette/houseparty"
 	"github.com/pkg/errors"
 	wunderlist "github.com/robdimsdale/wl"
 )
 
 // Trello
 
-func getCards(board *trello.Board) []*trello.Card {
-	var cards []*trello.Card
-	lists, err := board.GetLists(trello.Defaults())
-	if err != nil {
-		// Handle error
-	}
-	for _, list := range lists {
-		if list.Name != "Ideas" && list.Name != "Needs research" {
-			listCards, err := list.GetCards(trello.Defaults())
-			if err != nil {
-				// Handle error
-			}
-			cards = append(cards, listCards...)
+func (a *App) isExcludedList(name string) bool {
+	for _, exclude := range a.Config.BacklogExcludes {
+		if name == exclude {
+			return true
 		}
 	}
-	// excludes := houseparty.Config("backlog-excludes")
-	// cards := []trello.Card
-	return cards
+	return false
 }
 
-func getListByName(board *trello.Board, name string) *trello.List {
-	lists, _ := board.GetLists(trello.Arguments{})
+func (a *App) getListByName(board *trello.Board, name string) *trello.List {
+	lists, _ := a.Boards.GetLists(board.ID)
 	for _, list := range lists {
 		if list.Name == name {
 			return list
@@ -46,125 +35,232 @@ func getListByName(board *trello.Board, name string) *trello.List {
 	return nil
 }
 
-func AddChecklist(card *trello.Card, name string) error {
-	path := fmt.Sprintf("cards/%s/checklists", card.ID)
-	err := houseparty.TrelloClient.Post(path, trello.Arguments{"name": name}, &card.IDCheckLists)
+func (a *App) createCard(listID string, name string, rule string) (*trello.Card, error) {
+	card, err := a.Boards.CreateCard(listID, name)
 	if err != nil {
-		err = errors.Wrapf(err, "Error creating checklist on card %s", card.ID)
+		return nil, errors.Wrapf(err, "Error creating card '%s'", name)
 	}
-	return err
+	a.Audit.record(rule, OpCreateCard, AuditTarget{CardID: card.ID, CardName: card.Name, BoardID: card.IDBoard, ListID: listID}, nil, card)
+	return card, nil
 }
 
-func MarkChecklistItem(card *trello.Card, item trello.CheckItem, state string) error {
-	path := fmt.Sprintf("cards/%s/checkItem/%s", card.ID, item.ID)
-	err := houseparty.TrelloClient.Put(path, trello.Arguments{"state": state}, &card.IDCheckLists)
+// Wunderlist
+
+// jsonString escapes a value the wunderlist client writes into a JSON body
+// as it is
+func jsonString(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted[1 : len(quoted)-1])
+}
+
+// createTask creates a task, without a due date when due is zero
+func (a *App) createTask(title string, listID uint, assigneeID uint, due time.Time, rule string) (wunderlist.Task, error) {
+	task, err := a.Tasks.CreateTask(title, listID, assigneeID, false, "", 0, due, false)
 	if err != nil {
-		err = fmt.Errorf("Error marking checklist item '%s' as %s: %s", item.Name, state, err)
+		return task, errors.Wrapf(err, "Error creating task '%s'", title)
 	}
-	return err
+	a.Audit.record(rule, OpCreateTask, AuditTarget{TaskID: task.ID}, nil, task)
+	return task, nil
+}
+
+// createGoalList creates a list for a goal's tasks in goals-folder
+func (a *App) createGoalList(title string, rule string) (wunderlist.List, error) {
+	list, err := a.Tasks.CreateList(jsonString(title))
+	if err != nil {
+		return list, errors.Wrapf(err, "Error creating list '%s'", title)
+	}
+	a.Audit.record(rule, OpCreateList, AuditTarget{TaskListID: list.ID}, nil, list)
+	return list, a.addToFolder(a.Config.GoalsFolder, list, rule)
 }
 
-func getChecklist(card *trello.Card, name string) *trello.Checklist {
-	for _, existingChecklist := range card.Checklists {
-		if existingChecklist.Name == name {
-			return existingChecklist
+// retireGoalList moves a list from goals-folder to goal-list-archive, or
+// deletes it and its tasks when there's no archive
+func (a *App) retireGoalList(list wunderlist.List, rule string) error {
+	if a.Config.GoalListArchive != "" {
+		if err := a.removeFromFolder(a.Config.GoalsFolder, list, rule); err != nil {
+			return err
 		}
+		return a.addToFolder(a.Config.GoalListArchive, list, rule)
+	}
+	// Tasks are deleted one by one first, so undo can recreate them
+	open, err := a.Tasks.TasksForListID(list.ID)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading the tasks in list '%s'", list.Title)
 	}
+	completed, err := a.Tasks.CompletedTasksForListID(list.ID, true)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading the completed tasks in list '%s'", list.Title)
+	}
+	for _, task := range append(open, completed...) {
+		if err := a.deleteTask(task, rule); err != nil {
+			return err
+		}
+	}
+	target := AuditTarget{TaskListID: list.ID}
+	folders, err := a.Tasks.Folders()
+	if err != nil {
+		return errors.Wrap(err, "Error loading folders")
+	}
+	if folder := folderByTitle(folders, a.Config.GoalsFolder); folder != nil {
+		target.FolderID = folder.ID
+	}
+	// Deleting needs the current revision
+	current, err := a.Tasks.List(list.ID)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading list '%s'", list.Title)
+	}
+	if err := a.Tasks.DeleteList(current); err != nil {
+		return errors.Wrapf(err, "Error deleting list '%s'", list.Title)
+	}
+	a.Audit.record(rule, OpDeleteList, target, current, nil)
 	return nil
 }
 
-// Return the checked and unchecked items for a checklist
-// Create the checklist if necessary
-func getChecklistItems(card *trello.Card, name string) ([]trello.CheckItem, []trello.CheckItem) {
-	var checked []trello.CheckItem
-	var unchecked []trello.CheckItem
-
-	// Does it already exist?
-	existingChecklist := getChecklist(card, name)
-	if existingChecklist != nil {
-		for _, item := range existingChecklist.CheckItems {
-			if item.State == "complete" {
-				checked = append(checked, item)
-			}
-			if item.State == "incomplete" {
-				unchecked = append(unchecked, item)
-			}
+// addToFolder adds a list to the folder with the title, creating the folder
+// if there isn't one
+func (a *App) addToFolder(title string, list wunderlist.List, rule string) error {
+	folders, err := a.Tasks.Folders()
+	if err != nil {
+		return errors.Wrap(err, "Error loading folders")
+	}
+	folder := folderByTitle(folders, title)
+	if folder == nil {
+		created, err := a.Tasks.CreateFolder(title, []uint{list.ID})
+		if err != nil {
+			return errors.Wrapf(err, "Error creating folder '%s'", title)
 		}
-		return checked, unchecked
+		a.Audit.record(rule, OpCreateFolder, AuditTarget{FolderID: created.ID, TaskListID: list.ID}, nil, created)
+		return nil
 	}
+	updated := *folder
+	updated.ListIDs = append(append([]uint(nil), folder.ListIDs...), list.ID)
+	return a.updateFolder(*folder, updated, rule)
+}
 
-	// It doesn't exist, create it
-	AddChecklist(card, name)
-	return checked, unchecked
+func (a *App) removeFromFolder(title string, list wunderlist.List, rule string) error {
+	folders, err := a.Tasks.Folders()
+	if err != nil {
+		return errors.Wrap(err, "Error loading folders")
+	}
+	folder := folderByTitle(folders, title)
+	if folder == nil {
+		return nil
+	}
+	updated := *folder
+	updated.ListIDs = nil
+	for _, id := range folder.ListIDs {
+		if id != list.ID {
+			updated.ListIDs = append(updated.ListIDs, id)
+		}
+	}
+	return a.updateFolder(*folder, updated, rule)
 }
 
-func moveItemToChecklist(item trello.CheckItem, card *trello.Card, name string) error {
-	newChecklist := getChecklist(card, name)
-	if newChecklist == nil {
-		return fmt.Errorf("Could not find checklist '%v'", name)
+func (a *App) updateFolder(before wunderlist.Folder, after wunderlist.Folder, rule string) error {
+	updated, err := a.Tasks.UpdateFolder(after)
+	if err != nil {
+		return errors.Wrapf(err, "Error updating folder '%s'", after.Title)
 	}
-	path := fmt.Sprintf("cards/%s/checkItem/%s", card.ID, item.ID)
-	err := houseparty.TrelloClient.Put(path, trello.Arguments{"idChecklist": newChecklist.ID}, &card.IDCheckLists)
+	a.Audit.record(rule, OpUpdateFolder, AuditTarget{FolderID: after.ID}, before, updated)
+	return nil
+}
+
+func (a *App) updateTask(before wunderlist.Task, after wunderlist.Task, rule string) (wunderlist.Task, error) {
+	updated, err := a.Tasks.UpdateTask(after)
 	if err != nil {
-		err = errors.Wrapf(err, "Error moving checklist item '%s' to %s", item.Name, name)
+		return updated, errors.Wrapf(err, "Error updating task '%s'", after.Title)
 	}
-	return err
+	a.Audit.record(rule, OpUpdateTask, AuditTarget{TaskID: after.ID}, before, updated)
+	return updated, nil
 }
 
-func hasLabel(card *trello.Card, name string) bool {
-	for _, label := range card.Labels {
-		if label.Name == name {
-			return true
-		}
+func (a *App) deleteTask(task wunderlist.Task, rule string) error {
+	if err := a.Tasks.DeleteTask(task); err != nil {
+		return errors.Wrapf(err, "Error deleting task '%s'", task.Title)
 	}
-	return false
+	a.Audit.record(rule, OpDeleteTask, AuditTarget{TaskID: task.ID}, task, nil)
+	return nil
 }
 
-func addLabel(card *trello.Card, name string) {
-	labels, err := card.Board.GetLabels(trello.Arguments{})
+func (a *App) commentOnTask(task wunderlist.Task, text string, rule string) (wunderlist.TaskComment, error) {
+	comment, err := a.Tasks.CreateTaskComment(jsonString(text), task.ID)
 	if err != nil {
-		log.Fatal(err)
-		return
+		return comment, errors.Wrapf(err, "Error commenting on task '%s'", task.Title)
 	}
-	for _, label := range labels {
-		if label.Name == name {
-			card.AddIDLabel(label.ID)
-		}
+	a.Audit.record(rule, OpCreateTaskComment, AuditTarget{TaskID: task.ID, TaskCommentID: comment.ID}, nil, comment)
+	return comment, nil
+}
+
+func (a *App) createNote(task wunderlist.Task, content string, rule string) (wunderlist.Note, error) {
+	note, err := a.Tasks.CreateNote(jsonString(content), task.ID)
+	if err != nil {
+		return note, errors.Wrapf(err, "Error adding a note to task '%s'", task.Title)
 	}
+	a.Audit.record(rule, OpCreateNote, AuditTarget{TaskID: task.ID, NoteID: note.ID}, nil, note)
+	return note, nil
 }
 
-func removeLabel(card *trello.Card, name string) {
-	for _, label := range card.Labels {
-		if label.Name == name {
-			card.RemoveIDLabel(label.ID, label)
-		}
+func (a *App) updateNote(before wunderlist.Note, after wunderlist.Note, rule string) (wunderlist.Note, error) {
+	updated, err := a.Tasks.UpdateNote(after)
+	if err != nil {
+		return updated, errors.Wrapf(err, "Error updating the note of task %d", after.TaskID)
+	}
+	a.Audit.record(rule, OpUpdateNote, AuditTarget{TaskID: after.TaskID, NoteID: after.ID}, before, updated)
+	return updated, nil
+}
+
+func (a *App) deleteNote(note wunderlist.Note, rule string) error {
+	if err := a.Tasks.DeleteNote(note); err != nil {
+		return errors.Wrapf(err, "Error deleting the note of task %d", note.TaskID)
 	}
+	a.Audit.record(rule, OpDeleteNote, AuditTarget{TaskID: note.TaskID, NoteID: note.ID}, note, nil)
+	return nil
 }
 
-// Todoist
+func (a *App) createSubtask(task wunderlist.Task, title string, rule string) (wunderlist.Subtask, error) {
+	subtask, err := a.Tasks.CreateSubtask(jsonString(title), task.ID, false)
+	if err != nil {
+		return subtask, errors.Wrapf(err, "Error creating subtask '%s' of task '%s'", title, task.Title)
+	}
+	a.Audit.record(rule, OpCreateSubtask, AuditTarget{TaskID: task.ID, SubtaskID: subtask.ID}, nil, subtask)
+	return subtask, nil
+}
 
-func syncTodoist() bool {
-	if err := houseparty.TodoistClient.Sync(context.Background()); err != nil {
-		log.Fatal(err)
-		return false
+func (a *App) updateSubtask(before wunderlist.Subtask, after wunderlist.Subtask, rule string) (wunderlist.Subtask, error) {
+	updated, err := a.Tasks.UpdateSubtask(after)
+	if err != nil {
+		return updated, errors.Wrapf(err, "Error updating subtask '%s'", after.Title)
 	}
-	return true
+	a.Audit.record(rule, OpUpdateSubtask, AuditTarget{TaskID: after.TaskID, SubtaskID: after.ID}, before, updated)
+	return updated, nil
 }
 
-func getTodoistWorkingProjectID() int {
-	project := 0
-	search := houseparty.Config("todoist-project")
-	for _, p := range houseparty.TodoistClient.Store.Projects {
-		if p.Name == search {
-			project = p.GetID()
-		}
+func (a *App) deleteSubtask(subtask wunderlist.Subtask, rule string) error {
+	if err := a.Tasks.DeleteSubtask(subtask); err != nil {
+		return errors.Wrapf(err, "Error deleting subtask '%s'", subtask.Title)
 	}
+	a.Audit.record(rule, OpDeleteSubtask, AuditTarget{TaskID: subtask.TaskID, SubtaskID: subtask.ID}, subtask, nil)
+	return nil
+}
+
+// reminderLayout is how reminder dates are sent, in UTC
+const reminderLayout = "2006-01-02T15:04:05.000Z"
 
-	if project == 0 {
-		log.Fatal("Could not find project with name ", houseparty.Config("todoist-project"))
+func (a *App) createReminder(task wunderlist.Task, at time.Time, rule string) (wunderlist.Reminder, error) {
+	reminder, err := a.Tasks.CreateReminder(at.UTC().Format(reminderLayout), task.ID, "")
+	if err != nil {
+		return reminder, errors.Wrapf(err, "Error setting a reminder on task '%s'", task.Title)
 	}
+	a.Audit.record(rule, OpCreateReminder, AuditTarget{TaskID: task.ID, ReminderID: reminder.ID}, nil, reminder)
+	return reminder, nil
+}
 
-	return project
+func (a *App) deleteReminder(task wunderlist.Task, reminder wunderlist.Reminder, rule string) error {
+	if err := a.Tasks.DeleteReminder(reminder); err != nil {
+		return errors.Wrapf(err, "Error removing the reminder from task '%s'", task.Title)
+	}
+	a.Audit.record(rule, OpDeleteReminder, AuditTarget{TaskID: task.ID, ReminderID: reminder.ID}, reminder, nil)
+	return nil
 }
 
 // Find an existing todoist task with the given content
@@ -185,210 +281,144 @@ func findExistingTasks(tasks []wunderlist.Task, content string, strict bool) []w
 	return existing
 }
 
-func run() {
-	fmt.Println("Starting run at", time.Now().Format("2006-01-02T15:04:05-0700"))
-	inbox, _ := houseparty.WunderlistClient.Inbox()
-	wunderlistUser, _ := houseparty.WunderlistClient.User()
-	inboxTasks, err := houseparty.WunderlistClient.TasksForListID(inbox.ID)
-	if err != nil {
-		log.Printf("Error loading open tasks: %v", err)
-		log.Printf("Skipping this run, will try again in %v seconds...", houseparty.Config("interval"))
-		return
-	}
-	inboxCompleted, _ := houseparty.WunderlistClient.CompletedTasksForListID(inbox.ID, true)
-	inboxTasks = append(inboxTasks, inboxCompleted...)
-	fmt.Printf("Found %v tasks (%v completed)\n", len(inboxTasks), len(inboxCompleted))
-	backlogBoard, err := houseparty.TrelloClient.GetBoard(houseparty.Config("trello-backlog"), trello.Defaults())
+// job is one independently scheduled part of a run
+type job struct {
+	Name string
+	Plan func(p *planner)
+}
+
+// jobs in the order they run when more than one is due
+var jobs = []job{
+	{"label-hygiene", (*planner).labelHygiene},
+	{"goal-promotion", (*planner).goalPromotion},
+	{"task-sync", (*planner).taskSync},
+	{"goal-completion", (*planner).goalCompletion},
+	{"comment-sync", (*planner).commentSync},
+	{"stale-detection", (*planner).staleDetection},
+	{"reminders", (*planner).reminders},
+	{"burndown", (*planner).burndown},
+}
+
+func jobNames() []string {
+	var names []string
+	for _, j := range jobs {
+		names = append(names, j.Name)
+	}
+	return names
+}
+
+// runJobs loads the boards and tasks once, plans the named jobs against them,
+// every job when names is empty, and carries out the plan. The returned error
+// summarizes any failures.
+func (a *App) runJobs(names ...string) error {
+	return a.change(func(snapshot *Snapshot) ([]Step, error) {
+		return Plan(a.Config, snapshot, names...), nil
+	})
+}
+
+// change is a single audited run: it loads a snapshot, asks plan for the
+// steps and carries them out. Only one change runs at a time.
+func (a *App) change(plan func(snapshot *Snapshot) ([]Step, error)) error {
+	a.runs.Lock()
+	defer a.runs.Unlock()
+	runID := a.Audit.StartRun()
+	fmt.Println("Starting run", runID, "at", a.Clock.Now().Format("2006-01-02T15:04:05-0700"))
+	snapshot, err := a.loadSnapshot()
 	if err != nil {
-		log.Fatal(err)
-		return
+		a.notify([]Event{runFailedEvent(err, nil)})
+		return err
 	}
-	goalsBoard, err := houseparty.TrelloClient.GetBoard(houseparty.Config("trello-goals"), trello.Defaults())
+	steps, err := plan(snapshot)
 	if err != nil {
-		log.Fatal(err)
-		return
-	}
-	// If there are no cards in In Progress, but there are cards in To Do, move one to In Progress
-	inProgressList := getListByName(goalsBoard, "In Progress")
-	if inProgressList != nil {
-		cards, _ := inProgressList.GetCards(trello.Arguments{})
-		if len(cards) == 0 {
-			toDoList := getListByName(goalsBoard, "To Do")
-			toDoCards, _ := toDoList.GetCards(trello.Arguments{})
-			if len(toDoCards) > 0 {
-				fmt.Printf("In Progress list is empty, moving To Do card %v to In Progress...", toDoCards[0].Name)
-				toDoCards[0].MoveToList(inProgressList.ID, trello.Arguments{})
-			} else {
-				fmt.Printf("No cards in 'In Progress' or 'To Do', creating a task to plan one...")
-				houseparty.WunderlistClient.CreateTask(fmt.Sprintf("Start working on a new goal (%v)", goalsBoard.ShortUrl), inbox.ID, wunderlistUser.ID, false, "", 0, time.Now().Local(), false)
-			}
-		}
+		a.notify([]Event{runFailedEvent(err, nil)})
+		return err
 	}
+	fmt.Printf("Planned %v changes\n", len(steps))
+	e := a.newExecutor(snapshot)
+	failures := e.Execute(steps)
+	events := runEvents(a.Config, snapshot, e.done)
+	if len(failures) > 0 {
+		err = fmt.Errorf("%d operations failed in run %s", len(failures), runID)
+		events = append(events, runFailedEvent(err, failures))
+	}
+	a.notify(events)
+	if err := a.recordBurndown(applySteps(snapshot, e.done)); err != nil {
+		log.Println(errors.Wrap(err, "Error recording the burndown"))
+	}
+	return err
+}
 
-	for _, card := range getCards(backlogBoard) {
-		// Need to get full card details to get checklists
-		card, err = houseparty.TrelloClient.GetCard(card.ID, trello.Arguments{
-			"checklists": "all",
-		})
-		if err != nil {
-			log.Fatal(err)
-			continue
-		}
-		successChecked, successUnchecked := getChecklistItems(card, "Success Criteria")
-		tasksChecked, tasksUnchecked := getChecklistItems(card, "Tasks")
-		backlogChecked, backlogUnchecked := getChecklistItems(card, "Backlog")
+// planJobs prints what runJobs would change without changing anything
+func (a *App) planJobs(names ...string) error {
+	snapshot, err := a.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	steps := Plan(a.Config, snapshot, names...)
+	fmt.Printf("Would make %v changes:\n", len(steps))
+	for _, step := range steps {
+		fmt.Printf("    [%v] %v\n", step.Rule, step.Action)
+	}
+	return nil
+}
 
-		// If checklists are empty, add labels
-		// First, we need to load the Board into the Card object
-		card.Board, err = houseparty.TrelloClient.GetBoard(card.IDBoard, trello.Arguments{})
-		if err != nil {
-			log.Fatal(err)
-			continue
-		}
-		if len(successChecked)+len(successUnchecked) == 0 {
-			addLabel(card, "Needs success criteria")
-		} else {
-			removeLabel(card, "Needs success criteria")
-		}
-		if len(tasksChecked)+len(tasksUnchecked)+len(backlogChecked)+len(backlogUnchecked) == 0 {
-			addLabel(card, "Needs tasks")
-		} else {
-			removeLabel(card, "Needs tasks")
-		}
+// run does a single pass of every job
+func (a *App) run() error {
+	return a.runJobs()
+}
 
-		// If card is marked as planned, move it to the goals board
-		if hasLabel(card, "Planned") {
-			// Remove Planned label before moving
-			removeLabel(card, "Planned")
-			// Then move the card
-			fmt.Println("Moving card", card.ID, "to board", goalsBoard.ID)
-			if err := card.Update(trello.Arguments{"idBoard": goalsBoard.ID}); err != nil {
-				err = errors.Wrapf(err, "Error moving card %s to board %s", card.ID, goalsBoard.ID)
-				log.Println(err)
-				continue
-			}
-		}
-	}
-	for _, card := range getCards(goalsBoard) {
-		// Need to get full card details to get checklists
-		card, err = houseparty.TrelloClient.GetCard(card.ID, trello.Arguments{
-			"checklists": "all",
-			"list":       "true",
-		})
-		if err != nil {
-			log.Fatal(err)
-			continue
-		}
-		if card.List.Name == "In Progress" {
-			// successChecked, successUnchecked := getChecklistItems(card, "Success Criteria")
-			tasksChecked, tasksUnchecked := getChecklistItems(card, "Tasks")
-			backlogChecked, backlogUnchecked := getChecklistItems(card, "Backlog")
-			// TODO: Remove tasks for uncheck backlog items
-			if len(backlogUnchecked) > 0 {
-				for _, item := range backlogUnchecked {
-					tasks := findExistingTasks(inboxTasks, item.Name, false)
-					if len(tasks) > 0 {
-						for _, task := range tasks {
-							fmt.Printf("    Found task for unchecked backlog item, deleting task...\n")
-							houseparty.WunderlistClient.DeleteTask(task)
-						}
-					}
-				}
-			}
-			// TODO: Move completed backlog checklist items to tasks
-			if len(backlogChecked) > 0 {
-			}
-			// TODO: Move incomplete backlog item to tasks
-			if len(tasksUnchecked) == 0 && len(backlogUnchecked) > 0 {
-				nextItem := backlogUnchecked[0]
-				fmt.Printf("All items in %v for card '%v' are completed, moving %v item (%v) to %v...\n", "Tasks", card.Name, "Backlog", nextItem.Name, "Tasks")
-				moveItemToChecklist(nextItem, card, "Tasks")
-				// Reload the tasks since we just moved an item to Tasks
-				tasksChecked, tasksUnchecked = getChecklistItems(card, "Tasks")
-			}
-			// Sync task checklist items with wunderlist. On conflict, wunderlist wins
-			for _, item := range tasksChecked {
-				fmt.Printf("Processing checked checklist item (%v)...\n", item.Name)
-				tasks := findExistingTasks(inboxTasks, item.Name, false)
-				if len(tasks) > 0 {
-					// Found at least one task
-					for _, task := range tasks {
-						fmt.Printf("    Found a matching task (%v)\n", task.Title)
-						if task.Completed == false {
-							// Checklist item is complete, task is not
-							fmt.Println("    Task is incomplete, but checklist item is complete, marking task as complete...")
-							task.Completed = true
-							houseparty.WunderlistClient.UpdateTask(task)
-						} else {
-							fmt.Println("    Task and checklist item are both complete, moving on...")
-						}
-					}
-				} else {
-					fmt.Println("    Task is missing, moving on...")
-				}
-			}
-			for _, item := range tasksUnchecked {
-				fmt.Printf("Processing unchecked checklist item (%v)...\n", item.Name)
-				tasks := findExistingTasks(inboxTasks, item.Name, false)
-				if len(tasks) > 0 {
-					// Found at least one matching todoist task
-					for _, task := range tasks {
-						fmt.Printf("    Found a matching task (%v)\n", task.Title)
-						if task.Completed == false {
-							// Checklist item is incomplete, task is as well
-							fmt.Println("    Task and checklist item are both incomplete, moving on...")
-						} else {
-							// Checklist item is incomplete, task is complete
-							fmt.Println("    Task is complete, checklist item is incomplete, marking checklist item as complete...")
-							_ = MarkChecklistItem(card, item, "complete")
-						}
-					}
-				} else {
-					fmt.Printf("    Task is missing, creating one from checklist item (%v)...\n", item.Name)
-					houseparty.WunderlistClient.CreateTask(fmt.Sprintf("%v (%v)", item.Name, card.ShortUrl), inbox.ID, wunderlistUser.ID, false, "", 0, time.Now().Local(), false)
-				}
-			}
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
-	fmt.Printf("Waiting %v seconds to run again...\n", houseparty.Config("interval"))
+	return false
 }
 
 func init() {
-	houseparty.ConfigPath = houseparty.GetEnv("CONFIG_PATH", "config")
-	houseparty.SecretsPath = houseparty.GetEnv("SECRETS_PATH", "secrets")
+	ConfigPath = getEnv("CONFIG_PATH", "config")
+	SecretsPath = getEnv("SECRETS_PATH", "secrets")
+	ConfigFile = getEnv("CONFIG_FILE", path.Join(ConfigPath, "miriam.yaml"))
 }
 
-func main() {
-	fmt.Println("Initializing...")
-	houseparty.StartHealthCheck()
-	interval, err := strconv.Atoi(houseparty.Config("interval"))
+// loadApp loads the configuration for a command, so help works without one
+func loadApp() (*App, error) {
+	c, err := LoadConfig(ConfigFile, ConfigPath, SecretsPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	shutdown := make(chan struct{})
+	return NewApp(c), nil
+}
 
-	if houseparty.ChatClient != nil {
-		houseparty.StartChatListener()
+func main() {
+	var err error
+	command, args := "daemon", []string{}
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+	switch command {
+	case "daemon":
+		err = daemonCommand(args)
+	case "run-once":
+		err = runOnceCommand(args)
+	case "validate":
+		err = validateCommand(args)
+	case "status":
+		err = statusCommand(args)
+	case "digest":
+		err = digestCommand(args)
+	case "report":
+		err = reportCommand(args)
+	case "undo":
+		err = undoCommand(args)
+	case "help", "-h", "--help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	fmt.Println("Initialization complete")
-
-	// First run before waiting for ticker
-	run()
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				run()
-			case <-shutdown:
-				ticker.Stop()
-				return
-			}
-		}
-	}()
-
-	// block forever
-	<-shutdown
 }
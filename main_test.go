@@ -2,18 +2,44 @@ package main
 
 import (
 	"testing"
-
-	"github.com/matthew-parlette/houseparty"
 )
 
 func TestRun(t *testing.T) {
 	// t.Skip("Skipping run test")
-	run()
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.ToDo, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+	planned := w.Trello.AddCard(w.Someday, "Run a marathon", "Planned")
+	w.Trello.AddCard(w.Someday, "Learn to juggle")
+
+	if err := w.App.run(); err != nil {
+		t.Fatal(err)
+	}
+	if list := w.Trello.ListOf(planned); list != "To Do" {
+		t.Errorf("expected the planned card in To Do, got %v", list)
+	}
+	if list := w.Trello.ListOf(goal); list != "In Progress" {
+		t.Errorf("expected the goal in In Progress, got %v", list)
+	}
+	if tasks := w.Wunderlist.Find(goal.ShortUrl); len(tasks) != 1 {
+		t.Errorf("expected a task for the goal, got %v", tasks)
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "checklist-setup", "goal-promotion", "label-hygiene", "planned-move", "task-sync")
 }
 
 func TestChatListener(t *testing.T) {
-	t.Skip("Skipping chat listener test")
-	houseparty.StartChatListener()
+	chat := &fakeChat{}
+	app := &App{Config: &Config{ChatUsers: []string{"matt"}}, Chat: chat}
+	if err := app.startChatListener(); err != nil {
+		t.Fatal(err)
+	}
+	chat.Say("matt", "status")
+	chat.Say("miriam", "status")
+	chat.Say("matt", "what's for lunch")
+	if len(chat.Sent) != 1 || chat.Sent[0] != "I'm online" {
+		t.Errorf("expected a single status reply, got %v", chat.Sent)
+	}
 }
 
 func TestTrelloObject(t *testing.T) {
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// matrixChat is a ChatAdapter on a Matrix homeserver's client-server API.
+// chat-channel is a room ID (!room:server) or alias (#room:server).
+type matrixChat struct {
+	url    string
+	user   string
+	token  string
+	room   string
+	client *http.Client
+
+	mu sync.Mutex
+	// Room IDs by alias, and a counter for transaction IDs
+	rooms   map[string]string
+	started int64
+	sent    int
+}
+
+// matrixSyncTimeout is how long the homeserver holds a sync open waiting for
+// new messages
+const matrixSyncTimeout = 30 * time.Second
+
+func connectMatrixChat(c *Config) (ChatAdapter, error) {
+	m := &matrixChat{
+		url:     strings.TrimSuffix(c.MatrixURL, "/") + "/_matrix/client/v3",
+		user:    c.MatrixUser,
+		token:   c.MatrixToken,
+		client:  &http.Client{Timeout: matrixSyncTimeout + 30*time.Second},
+		rooms:   map[string]string{},
+		started: time.Now().UnixNano(),
+	}
+	var err error
+	if m.room, err = m.roomID(c.ChatChannel); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// call makes an API request, decoding the JSON answer into result
+func (m *matrixChat) call(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, m.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Error calling Matrix %s", path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var matrixError struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&matrixError)
+		return fmt.Errorf("Matrix %s answered %s: %s", path, resp.Status, matrixError.Error)
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+// roomID resolves a room alias, room IDs are used as they are
+func (m *matrixChat) roomID(room string) (string, error) {
+	if strings.HasPrefix(room, "!") {
+		return room, nil
+	}
+	if !strings.HasPrefix(room, "#") {
+		return "", fmt.Errorf("Matrix room %s must be a room ID (!room:server) or alias (#room:server)", room)
+	}
+	m.mu.Lock()
+	id, ok := m.rooms[room]
+	m.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	var directory struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := m.call("GET", "/directory/room/"+url.PathEscape(room), nil, &directory); err != nil {
+		return "", errors.Wrapf(err, "Could not find room %s", room)
+	}
+	m.mu.Lock()
+	m.rooms[room] = directory.RoomID
+	m.mu.Unlock()
+	return directory.RoomID, nil
+}
+
+func (m *matrixChat) Send(channel string, text string) error {
+	room := m.room
+	if channel != "" {
+		var err error
+		if room, err = m.roomID(channel); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	m.sent++
+	txnID := fmt.Sprintf("miriam-%d-%d", m.started, m.sent)
+	m.mu.Unlock()
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(room), txnID)
+	return m.call("PUT", path, map[string]string{"msgtype": "m.text", "body": text}, nil)
+}
+
+// matrixSync is the part of a sync response miriam reads
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []struct {
+					Type    string `json:"type"`
+					Sender  string `json:"sender"`
+					Content struct {
+						MsgType string `json:"msgtype"`
+						Body    string `json:"body"`
+					} `json:"content"`
+				} `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// Subscribe syncs once to skip the room's history, then long-polls for new
+// messages in the background
+func (m *matrixChat) Subscribe(handle func(ChatMessage)) error {
+	var sync matrixSync
+	if err := m.call("GET", "/sync?timeout=0", nil, &sync); err != nil {
+		return errors.Wrap(err, "Error subscribing to chat messages")
+	}
+	go func() {
+		since := sync.NextBatch
+		for {
+			var next matrixSync
+			path := fmt.Sprintf("/sync?since=%s&timeout=%d", url.QueryEscape(since), matrixSyncTimeout/time.Millisecond)
+			if err := m.call("GET", path, nil, &next); err != nil {
+				log.Println(err)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			since = next.NextBatch
+			for _, event := range next.Rooms.Join[m.room].Timeline.Events {
+				if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Sender == m.user {
+					continue
+				}
+				user, _ := m.LookupUser(event.Sender)
+				handle(ChatMessage{Channel: m.room, UserID: event.Sender, User: user, Text: event.Content.Body})
+			}
+		}
+	}()
+	return nil
+}
+
+// LookupUser is the localpart of a Matrix user ID, matt for @matt:server
+func (m *matrixChat) LookupUser(userID string) (string, error) {
+	if !strings.HasPrefix(userID, "@") || !strings.Contains(userID, ":") {
+		return "", fmt.Errorf("Invalid Matrix user ID %s", userID)
+	}
+	return userID[1:strings.Index(userID, ":")], nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMatrix is a homeserver with one room. Messages are kept as sync
+// events, and each sync returns the events after its since token.
+type fakeMatrix struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	events []map[string]interface{}
+	// Sent is the body of every message miriam sent, by room ID
+	Sent map[string][]string
+}
+
+func newFakeMatrix() *fakeMatrix {
+	f := &fakeMatrix{Sent: map[string][]string{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Say adds a message from sender to the room
+func (f *fakeMatrix) Say(sender string, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, map[string]interface{}{"type": "m.room.message", "sender": sender, "content": map[string]string{"msgtype": "m.text", "body": text}})
+}
+
+func (f *fakeMatrix) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer syt_fake" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token"})
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/_matrix/client/v3")
+	switch {
+	case path == "/directory/room/%23house-party:example.org":
+		json.NewEncoder(w).Encode(map[string]string{"room_id": "!party:example.org"})
+	case r.Method == "PUT" && strings.HasPrefix(path, "/rooms/"):
+		room := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"), "/", 2)[0]
+		var content map[string]string
+		json.NewDecoder(r.Body).Decode(&content)
+		f.mu.Lock()
+		f.Sent[room] = append(f.Sent[room], content["body"])
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"event_id": "$event"})
+	case path == "/sync":
+		since := 0
+		fmt.Sscan(r.URL.Query().Get("since"), &since)
+		// Hold the sync open briefly, like a homeserver waiting for messages
+		deadline := time.Now().Add(200 * time.Millisecond)
+		for {
+			f.mu.Lock()
+			events := f.events[since:]
+			f.mu.Unlock()
+			if len(events) > 0 || r.URL.Query().Get("timeout") == "0" || time.Now().After(deadline) {
+				sync := map[string]interface{}{"next_batch": fmt.Sprint(since + len(events))}
+				sync["rooms"] = map[string]interface{}{"join": map[string]interface{}{"!party:example.org": map[string]interface{}{"timeline": map[string]interface{}{"events": events}}}}
+				json.NewEncoder(w).Encode(sync)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"errcode": "M_NOT_FOUND", "error": "No such room"})
+	}
+}
+
+func TestMatrixChat(t *testing.T) {
+	matrix := newFakeMatrix()
+	defer matrix.server.Close()
+	c := &Config{MatrixURL: matrix.server.URL, MatrixUser: "@miriam:example.org", MatrixToken: "syt_fake", ChatChannel: "#house-party:example.org"}
+	m, err := connectMatrixChat(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Send("", "I'm online"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send("!goals:example.org", "Started goal"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send("#unknown:example.org", "Hello?"); err == nil || !strings.Contains(err.Error(), "No such room") {
+		t.Errorf("expected an unknown room to fail, got %v", err)
+	}
+	expectStrings(t, "sent to the room", matrix.Sent["!party:example.org"], "I'm online")
+	expectStrings(t, "sent to goals", matrix.Sent["!goals:example.org"], "Started goal")
+
+	// History from before miriam subscribed isn't answered
+	matrix.Say("@matt:example.org", "sync")
+	received := make(chan ChatMessage, 3)
+	if err := m.Subscribe(func(msg ChatMessage) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	matrix.Say("@miriam:example.org", "I'm online")
+	matrix.Say("@matt:example.org", "status")
+	select {
+	case msg := <-received:
+		if msg != (ChatMessage{Channel: "!party:example.org", UserID: "@matt:example.org", User: "matt", Text: "status"}) {
+			t.Errorf("expected matt's message, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message to be delivered")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("expected only one message, got %+v", msg)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if _, err := connectMatrixChat(&Config{MatrixURL: matrix.server.URL, MatrixToken: "syt_fake", ChatChannel: "house-party"}); err == nil {
+		t.Error("expected a room name that isn't an ID or alias to be refused")
+	}
+}
@@ -0,0 +1,1163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// Action is one change the planner decided on. Actions are plain values, the
+// executor is what carries them out.
+type Action interface {
+	fmt.Stringer
+}
+
+type CreateChecklist struct {
+	Card Card
+	Name string
+}
+
+type AddLabel struct {
+	Card  Card
+	Label Label
+}
+
+type RemoveLabel struct {
+	Card  Card
+	Label Label
+}
+
+type MoveCardToBoard struct {
+	Card    Card
+	BoardID string
+	// The first list of the board, where Trello puts the card
+	ListID string
+}
+
+type MoveCardToList struct {
+	Card   Card
+	ListID string
+}
+
+// CompleteCard marks a card's due date complete, keeping the date, and
+// comments when it was finished
+type CompleteCard struct {
+	Card Card
+	At   time.Time
+}
+
+// AttachURL attaches a link to a card
+type AttachURL struct {
+	Card Card
+	Name string
+	URL  string
+}
+
+type MoveCheckItem struct {
+	Card      Card
+	Item      CheckItem
+	Checklist string
+}
+
+type MarkCheckItem struct {
+	Card     Card
+	Item     CheckItem
+	Complete bool
+}
+
+// CreateTask creates a task in the inbox, or in the goal list named List,
+// due on Due's date unless it's zero, with Note as its note unless it's empty
+type CreateTask struct {
+	Title string
+	Due   time.Time
+	Note  string
+	List  string
+}
+
+// CreateGoalList creates a list for a goal's tasks in goals-folder
+type CreateGoalList struct {
+	Title string
+}
+
+// RetireGoalList moves a finished goal's list to goal-list-archive, or
+// deletes it
+type RetireGoalList struct {
+	List wunderlist.List
+}
+
+type CompleteTask struct {
+	Task wunderlist.Task
+}
+
+type DeleteTask struct {
+	Task wunderlist.Task
+}
+
+// UpdateTaskDue moves an open task's due date to Due's date, or clears it
+// when Due is zero
+type UpdateTaskDue struct {
+	Task wunderlist.Task
+	Due  time.Time
+}
+
+// StarTask stars a task that has been open too long
+type StarTask struct {
+	Task wunderlist.Task
+}
+
+type CreateNote struct {
+	Task    wunderlist.Task
+	Content string
+}
+
+type UpdateNote struct {
+	Note    wunderlist.Note
+	Content string
+}
+
+// CreateSubtask adds a subtask to a task, which may have been created
+// earlier in the plan
+type CreateSubtask struct {
+	Task  wunderlist.Task
+	Title string
+}
+
+type CompleteSubtask struct {
+	Subtask wunderlist.Subtask
+}
+
+type DeleteSubtask struct {
+	Subtask wunderlist.Subtask
+}
+
+// CreateReminder sets a reminder on an open task
+type CreateReminder struct {
+	Task wunderlist.Task
+	At   time.Time
+}
+
+// DeleteReminder removes a reminder from a task
+type DeleteReminder struct {
+	Task     wunderlist.Task
+	Reminder wunderlist.Reminder
+}
+
+// CommentOnCard adds a comment to a card, a copy of TaskComment when it
+// isn't zero
+type CommentOnCard struct {
+	Card        Card
+	Text        string
+	TaskComment uint
+}
+
+// CommentOnTask adds a comment to a task
+type CommentOnTask struct {
+	Task wunderlist.Task
+	Text string
+}
+
+func (a CreateChecklist) String() string {
+	return fmt.Sprintf("Create checklist '%s' on card '%s'", a.Name, a.Card.Name)
+}
+
+func (a AddLabel) String() string {
+	return fmt.Sprintf("Add label '%s' to card '%s'", a.Label.Name, a.Card.Name)
+}
+
+func (a RemoveLabel) String() string {
+	return fmt.Sprintf("Remove label '%s' from card '%s'", a.Label.Name, a.Card.Name)
+}
+
+func (a MoveCardToBoard) String() string {
+	return fmt.Sprintf("Move card '%s' to board %s", a.Card.Name, a.BoardID)
+}
+
+func (a MoveCardToList) String() string {
+	return fmt.Sprintf("Move card '%s' to list %s", a.Card.Name, a.ListID)
+}
+
+func (a CompleteCard) String() string {
+	return fmt.Sprintf("Mark card '%s' as completed on %s", a.Card.Name, a.At.Format("2006-01-02"))
+}
+
+func (a AttachURL) String() string {
+	return fmt.Sprintf("Attach '%s' to card '%s'", a.Name, a.Card.Name)
+}
+
+func (a MoveCheckItem) String() string {
+	return fmt.Sprintf("Move checklist item '%s' on card '%s' to %s", a.Item.Name, a.Card.Name, a.Checklist)
+}
+
+func (a MarkCheckItem) String() string {
+	return fmt.Sprintf("Mark checklist item '%s' on card '%s' as %s", a.Item.Name, a.Card.Name, CheckItem{Complete: a.Complete}.State())
+}
+
+func (a CommentOnCard) String() string {
+	return fmt.Sprintf("Comment on card '%s': %s", a.Card.Name, a.Text)
+}
+
+func (a CommentOnTask) String() string {
+	return fmt.Sprintf("Comment on task '%s': %s", a.Task.Title, a.Text)
+}
+
+func (a CreateGoalList) String() string {
+	return fmt.Sprintf("Create list '%s'", a.Title)
+}
+
+func (a RetireGoalList) String() string {
+	return fmt.Sprintf("Retire list '%s'", a.List.Title)
+}
+
+func (a CreateTask) String() string {
+	if !a.Due.IsZero() {
+		return fmt.Sprintf("Create task '%s' due %s", a.Title, a.Due.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("Create task '%s'", a.Title)
+}
+
+func (a UpdateTaskDue) String() string {
+	if a.Due.IsZero() {
+		return fmt.Sprintf("Clear the due date of task '%s'", a.Task.Title)
+	}
+	return fmt.Sprintf("Change the due date of task '%s' to %s", a.Task.Title, a.Due.Format("2006-01-02"))
+}
+
+func (a CompleteTask) String() string {
+	return fmt.Sprintf("Complete task '%s'", a.Task.Title)
+}
+
+func (a DeleteTask) String() string {
+	return fmt.Sprintf("Delete task '%s'", a.Task.Title)
+}
+
+func (a StarTask) String() string {
+	return fmt.Sprintf("Star task '%s'", a.Task.Title)
+}
+
+func (a CreateNote) String() string {
+	return fmt.Sprintf("Add a note to task '%s'", a.Task.Title)
+}
+
+func (a UpdateNote) String() string {
+	return fmt.Sprintf("Update the note of task %d", a.Note.TaskID)
+}
+
+func (a CreateSubtask) String() string {
+	return fmt.Sprintf("Create subtask '%s' of task '%s'", a.Title, a.Task.Title)
+}
+
+func (a CompleteSubtask) String() string {
+	return fmt.Sprintf("Complete subtask '%s'", a.Subtask.Title)
+}
+
+func (a DeleteSubtask) String() string {
+	return fmt.Sprintf("Delete subtask '%s'", a.Subtask.Title)
+}
+
+func (a CreateReminder) String() string {
+	return fmt.Sprintf("Remind of task '%s' at %s", a.Task.Title, a.At.Format("2006-01-02 15:04"))
+}
+
+func (a DeleteReminder) String() string {
+	return fmt.Sprintf("Remove the reminder from task '%s'", a.Task.Title)
+}
+
+// Step is an action and the rule that decided on it, which is the rule it's
+// audited under. CardID is the card the action depends on, if any.
+type Step struct {
+	Rule   string
+	CardID string
+	Action Action
+}
+
+// planner decides what a run changes. It works on its own copy of the
+// snapshot and applies each action to it as it goes, so later rules see the
+// effect of earlier ones without reading anything again.
+type planner struct {
+	config *Config
+	state  *Snapshot
+	steps  []Step
+}
+
+// Plan computes the steps for the named jobs, every job when names is empty.
+// It has no side effects and doesn't change the snapshot.
+func Plan(c *Config, snapshot *Snapshot, names ...string) []Step {
+	p := &planner{config: c, state: snapshot.Clone()}
+	for _, j := range jobs {
+		if len(names) > 0 && !containsString(names, j.Name) {
+			continue
+		}
+		j.Plan(p)
+	}
+	return p.steps
+}
+
+// add records a step and applies it to the planner's state
+func (p *planner) add(rule string, card *Card, action Action) {
+	step := Step{Rule: rule, Action: action}
+	if card != nil {
+		step.CardID = card.ID
+	}
+	p.steps = append(p.steps, step)
+	p.state.apply(action)
+}
+
+// ensureChecklist plans the checklist's creation when the card doesn't have it
+func (p *planner) ensureChecklist(card *Card, name string) {
+	if card.Checklist(name) == nil {
+		p.add("checklist-setup", card, CreateChecklist{Card: *card, Name: name})
+	}
+}
+
+// setLabel plans adding or removing a label so the card has it when want is
+// true. Labels that don't exist on the board can't be added.
+func (p *planner) setLabel(board *Board, card *Card, name string, want bool, rule string) {
+	if want && !card.HasLabel(name) {
+		if label := board.LabelByName(name); label != nil {
+			p.add(rule, card, AddLabel{Card: *card, Label: *label})
+		}
+	}
+	if !want {
+		for _, label := range card.Labels {
+			if label.Name == name {
+				p.add(rule, card, RemoveLabel{Card: *card, Label: label})
+			}
+		}
+	}
+}
+
+// tasksMatching returns the tasks whose title contains text. Tasks created
+// earlier in the plan are included, with an ID of 0.
+func (p *planner) tasksMatching(text string) []wunderlist.Task {
+	return findExistingTasks(p.state.Tasks, text, false)
+}
+
+// labelHygiene creates the planning checklists on backlog cards and labels
+// the cards that still need success criteria or tasks
+func (p *planner) labelHygiene() {
+	c := p.config
+	board := &p.state.Backlog
+	for _, card := range board.Cards {
+		p.ensureChecklist(card, c.SuccessChecklist)
+		p.ensureChecklist(card, c.TasksChecklist)
+		p.ensureChecklist(card, c.BacklogChecklist)
+
+		successChecked, successUnchecked := card.Items(c.SuccessChecklist)
+		tasksChecked, tasksUnchecked := card.Items(c.TasksChecklist)
+		backlogChecked, backlogUnchecked := card.Items(c.BacklogChecklist)
+		p.setLabel(board, card, c.NeedsSuccessLabel, len(successChecked)+len(successUnchecked) == 0, "label-hygiene")
+		p.setLabel(board, card, c.NeedsTasksLabel, len(tasksChecked)+len(tasksUnchecked)+len(backlogChecked)+len(backlogUnchecked) == 0, "label-hygiene")
+	}
+}
+
+// goalPromotion moves planned backlog cards to the goals board, then pulls
+// cards from To Do into In Progress up to the WIP limit
+func (p *planner) goalPromotion() {
+	c := p.config
+	goals := &p.state.Goals
+	// Moving a card changes the backlog's cards, so work from a copy
+	for _, card := range append([]*Card(nil), p.state.Backlog.Cards...) {
+		if !card.HasLabel(c.PlannedLabel) || len(goals.Lists) == 0 {
+			continue
+		}
+		p.setLabel(&p.state.Backlog, card, c.PlannedLabel, false, "planned-move")
+		p.add("planned-move", card, MoveCardToBoard{Card: *card, BoardID: goals.ID, ListID: goals.Lists[0].ID})
+	}
+
+	inProgressList := goals.ListByName(c.InProgressList)
+	if inProgressList == nil {
+		return
+	}
+	inProgress := goals.CardsIn(inProgressList.ID)
+	if len(inProgress) >= c.WIPLimit {
+		return
+	}
+	var toDo []*Card
+	if toDoList := goals.ListByName(c.ToDoList); toDoList != nil {
+		toDo = goals.CardsIn(toDoList.ID)
+	}
+	if len(toDo) > 0 {
+		for i := 0; i < len(toDo) && len(inProgress)+i < c.WIPLimit; i++ {
+			p.add("goal-promotion", toDo[i], MoveCardToList{Card: *toDo[i], ListID: inProgressList.ID})
+		}
+	} else if len(inProgress) == 0 {
+		title := fmt.Sprintf("Start working on a new goal (%v)", goals.ShortURL)
+		// Ask once, not on every run until a goal is planned
+		for _, task := range findExistingTasks(p.state.Tasks, title, true) {
+			if !task.Completed {
+				return
+			}
+		}
+		p.add("goal-promotion", nil, CreateTask{Title: title})
+	}
+}
+
+// taskSync keeps the Tasks checklists of goals in progress in step with the
+// inbox, promoting Backlog items as Tasks are finished
+func (p *planner) taskSync() {
+	c := p.config
+	inProgressList := p.state.Goals.ListByName(c.InProgressList)
+	if inProgressList == nil {
+		return
+	}
+	if c.GoalLists {
+		p.retireGoalLists(inProgressList)
+	}
+	for _, card := range p.state.Goals.CardsIn(inProgressList.ID) {
+		p.ensureChecklist(card, c.TasksChecklist)
+		p.ensureChecklist(card, c.BacklogChecklist)
+		if c.GoalLists && p.state.GoalList(card.Name) == nil {
+			p.add("goal-lists", card, CreateGoalList{Title: card.Name})
+		}
+		if c.TaskMode == TaskModeSubtasks {
+			p.subtaskSync(card)
+			continue
+		}
+
+		_, backlogUnchecked := card.Items(c.BacklogChecklist)
+		// Backlog items shouldn't have tasks yet
+		for _, item := range backlogUnchecked {
+			for _, task := range p.tasksMatching(item.Name) {
+				if task.ID != 0 {
+					p.add("backlog-cleanup", card, DeleteTask{Task: task})
+				}
+			}
+		}
+		// Sync task checklist items with wunderlist. On conflict, wunderlist wins
+		tasksChecked, tasksUnchecked := card.Items(c.TasksChecklist)
+		for _, item := range tasksUnchecked {
+			for _, task := range p.tasksMatching(item.Name) {
+				if task.Completed {
+					p.add("task-sync", card, MarkCheckItem{Card: *card, Item: item, Complete: true})
+					break
+				}
+			}
+		}
+		for _, item := range tasksChecked {
+			for _, task := range p.tasksMatching(item.Name) {
+				if !task.Completed && task.ID != 0 {
+					p.add("task-sync", card, CompleteTask{Task: task})
+				}
+			}
+		}
+
+		// Promote the next Backlog item once every task is done, including
+		// the ones just finished in wunderlist
+		if _, tasksUnchecked = card.Items(c.TasksChecklist); len(tasksUnchecked) == 0 && len(backlogUnchecked) > 0 {
+			p.add("backlog-promotion", card, MoveCheckItem{Card: *card, Item: backlogUnchecked[0], Checklist: c.TasksChecklist})
+		}
+		// Open tasks follow the item's due date hint or the card's due date,
+		// and lose theirs when neither has one
+		_, tasksUnchecked = card.Items(c.TasksChecklist)
+		for _, item := range tasksUnchecked {
+			due := p.taskDue(card, item)
+			tasks := p.tasksMatching(item.Name)
+			if len(tasks) == 0 {
+				p.add("task-sync", card, CreateTask{Title: fmt.Sprintf("%v (%v)", item.Name, card.ShortURL), Due: due, Note: p.taskNote(card), List: p.goalList(card)})
+			}
+			for _, task := range tasks {
+				if !task.Completed && task.ID != 0 && dueChanged(task.DueDate, due) {
+					p.add("task-sync", card, UpdateTaskDue{Task: task, Due: due})
+				}
+			}
+			p.syncNotes(card, tasks)
+		}
+	}
+}
+
+// goalList is the list a goal's new tasks go in, empty for the inbox
+func (p *planner) goalList(card *Card) string {
+	if p.config.GoalLists {
+		return card.Name
+	}
+	return ""
+}
+
+// retireGoalLists retires the goal lists no goal in progress is named after,
+// like the lists of goals moved out of In Progress by hand
+func (p *planner) retireGoalLists(inProgressList *List) {
+	for _, list := range append([]wunderlist.List(nil), p.state.GoalLists...) {
+		if list.ID != 0 && !p.inProgressNamed(inProgressList, list.Title) {
+			p.add("goal-lists", nil, RetireGoalList{List: list})
+		}
+	}
+}
+
+func (p *planner) inProgressNamed(inProgressList *List, name string) bool {
+	for _, card := range p.state.Goals.CardsIn(inProgressList.ID) {
+		if card.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// subtaskSync keeps a goal's Tasks checklist in step with the subtasks of
+// its task in the inbox, which is created first if it's missing
+func (p *planner) subtaskSync(card *Card) {
+	c := p.config
+	due := p.taskDue(card, CheckItem{})
+	parent := p.goalTask(card)
+	if parent == nil {
+		p.add("task-sync", card, CreateTask{Title: fmt.Sprintf("%v (%v)", card.Name, card.ShortURL), Due: due, Note: p.taskNote(card), List: p.goalList(card)})
+		parent = p.goalTask(card)
+	} else if !parent.Completed && parent.ID != 0 && dueChanged(parent.DueDate, due) {
+		p.add("task-sync", card, UpdateTaskDue{Task: *parent, Due: due})
+	}
+	p.syncNotes(card, []wunderlist.Task{*parent})
+
+	_, backlogUnchecked := card.Items(c.BacklogChecklist)
+	// Backlog items shouldn't have subtasks yet
+	for _, item := range backlogUnchecked {
+		for _, subtask := range p.subtasksMatching(*parent, item.Name) {
+			if subtask.ID != 0 {
+				p.add("backlog-cleanup", card, DeleteSubtask{Subtask: subtask})
+			}
+		}
+	}
+	// On conflict, wunderlist wins
+	tasksChecked, tasksUnchecked := card.Items(c.TasksChecklist)
+	for _, item := range tasksUnchecked {
+		for _, subtask := range p.subtasksMatching(*parent, item.Name) {
+			if subtask.Completed {
+				p.add("task-sync", card, MarkCheckItem{Card: *card, Item: item, Complete: true})
+				break
+			}
+		}
+	}
+	for _, item := range tasksChecked {
+		for _, subtask := range p.subtasksMatching(*parent, item.Name) {
+			if !subtask.Completed && subtask.ID != 0 {
+				p.add("task-sync", card, CompleteSubtask{Subtask: subtask})
+			}
+		}
+	}
+
+	if _, tasksUnchecked = card.Items(c.TasksChecklist); len(tasksUnchecked) == 0 && len(backlogUnchecked) > 0 {
+		p.add("backlog-promotion", card, MoveCheckItem{Card: *card, Item: backlogUnchecked[0], Checklist: c.TasksChecklist})
+	}
+	_, tasksUnchecked = card.Items(c.TasksChecklist)
+	for _, item := range tasksUnchecked {
+		if len(p.subtasksMatching(*parent, item.Name)) == 0 {
+			p.add("task-sync", card, CreateSubtask{Task: *parent, Title: item.Name})
+		}
+	}
+}
+
+// goalTask is the task standing for a goal in the subtasks task-mode: the
+// task named after the card, or one with its short URL that has subtasks
+// when the card has been renamed. Open tasks come first.
+func (p *planner) goalTask(card *Card) *wunderlist.Task {
+	title := fmt.Sprintf("%v (%v)", card.Name, card.ShortURL)
+	var found *wunderlist.Task
+	for i, task := range p.state.Tasks {
+		if task.Title != title && !(strings.HasSuffix(task.Title, fmt.Sprintf(" (%v)", card.ShortURL)) && task.ID != 0 && len(p.state.SubtasksOf(task.ID)) > 0) {
+			continue
+		}
+		if found == nil || (found.Completed && !task.Completed) {
+			found = &p.state.Tasks[i]
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	task := *found
+	return &task
+}
+
+// subtasksMatching returns the subtasks of a task with the given title
+func (p *planner) subtasksMatching(task wunderlist.Task, title string) []wunderlist.Subtask {
+	var matching []wunderlist.Subtask
+	for _, subtask := range p.state.SubtasksOf(task.ID) {
+		if subtask.Title == title {
+			matching = append(matching, subtask)
+		}
+	}
+	return matching
+}
+
+// taskNote is the note for a goal's tasks: the card's description, its
+// success criteria and its links, leaving out the burndown chart. It's empty
+// without task-notes.
+func (p *planner) taskNote(card *Card) string {
+	c := p.config
+	if !c.TaskNotes {
+		return ""
+	}
+	var sections []string
+	if desc := strings.TrimSpace(card.Desc); desc != "" {
+		sections = append(sections, desc)
+	}
+	if checklist := card.Checklist(c.SuccessChecklist); checklist != nil && len(checklist.Items) > 0 {
+		lines := []string{c.SuccessChecklist + ":"}
+		for _, item := range checklist.Items {
+			box := "[ ]"
+			if item.Complete {
+				box = "[x]"
+			}
+			lines = append(lines, fmt.Sprintf("%v %v", box, item.Name))
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+	var links []string
+	for _, attachment := range card.Attachments {
+		switch {
+		case attachment.Upload || attachment.URL == "" || attachment.URL == burndownURL(c, card):
+		case attachment.Name == "" || attachment.Name == attachment.URL:
+			links = append(links, attachment.URL)
+		default:
+			links = append(links, fmt.Sprintf("%v: %v", attachment.Name, attachment.URL))
+		}
+	}
+	if len(links) > 0 {
+		sections = append(sections, "Links:\n"+strings.Join(links, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// syncNotes keeps the notes of a goal's open tasks in step with the card.
+// Tasks created earlier in the plan were given theirs.
+func (p *planner) syncNotes(card *Card, tasks []wunderlist.Task) {
+	if !p.config.TaskNotes {
+		return
+	}
+	content := p.taskNote(card)
+	for _, task := range tasks {
+		if task.ID == 0 || task.Completed {
+			continue
+		}
+		note, ok := p.state.NoteOf(task.ID)
+		if !ok && content != "" {
+			p.add("task-sync", card, CreateNote{Task: task, Content: content})
+		}
+		if ok && note.Content != content {
+			p.add("task-sync", card, UpdateNote{Note: note, Content: content})
+		}
+	}
+}
+
+// Origin markers start every synced comment, so it isn't synced back
+const (
+	fromWunderlist = "[from Wunderlist]"
+	fromTrello     = "[from Trello]"
+)
+
+func isSyncedComment(text string) bool {
+	return strings.HasPrefix(text, fromWunderlist) || strings.HasPrefix(text, fromTrello)
+}
+
+// commentSync copies comments on a goal's tasks to its card, and comments on
+// the card to its open tasks. Wunderlist doesn't say who wrote a task
+// comment, so they're credited to the inbox's user. Card comments only go to
+// tasks created before them.
+func (p *planner) commentSync() {
+	c := p.config
+	inProgressList := p.state.Goals.ListByName(c.InProgressList)
+	if !c.CommentSync || inProgressList == nil {
+		return
+	}
+	for _, card := range p.state.Goals.CardsIn(inProgressList.ID) {
+		tasks := p.tasksMatching(card.ShortURL)
+		for _, task := range tasks {
+			if task.ID == 0 {
+				continue
+			}
+			title := strings.TrimSuffix(task.Title, fmt.Sprintf(" (%v)", card.ShortURL))
+			for _, comment := range p.state.TaskCommentsOf(task.ID) {
+				if isSyncedComment(comment.Text) {
+					continue
+				}
+				text := fmt.Sprintf("%v %v on '%v': %v", fromWunderlist, p.state.User.Name, title, comment.Text)
+				if !p.state.copiedTaskComment(comment.ID) && !card.HasComment(text) {
+					p.add("comment-sync", card, CommentOnCard{Card: *card, Text: text, TaskComment: comment.ID})
+				}
+			}
+		}
+		for _, comment := range card.Comments {
+			if isSyncedComment(comment.Text) {
+				continue
+			}
+			text := fmt.Sprintf("%v %v: %v", fromTrello, comment.Author, comment.Text)
+			for _, task := range tasks {
+				if task.ID == 0 || task.Completed || comment.Date.Before(task.CreatedAt) || p.state.taskHasComment(task.ID, text) {
+					continue
+				}
+				p.add("comment-sync", card, CommentOnTask{Task: task, Text: text})
+			}
+		}
+	}
+}
+
+// copiedTaskComment is true when the task comment was copied to its card
+func (s *Snapshot) copiedTaskComment(id uint) bool {
+	for _, copied := range s.CopiedTaskComments {
+		if copied == id {
+			return true
+		}
+	}
+	return false
+}
+
+// taskHasComment is true when the task has a comment with exactly the text
+func (s *Snapshot) taskHasComment(taskID uint, text string) bool {
+	for _, comment := range s.TaskCommentsOf(taskID) {
+		if comment.Text == text {
+			return true
+		}
+	}
+	return false
+}
+
+// taskDue is the due date for an item's task: the item's hint, or else the
+// card's due date as a day in the configured time zone. Zero means neither
+// has one.
+func (p *planner) taskDue(card *Card, item CheckItem) time.Time {
+	if !item.Due.IsZero() || card.Due.IsZero() {
+		return item.Due
+	}
+	location, err := p.config.Location()
+	if err != nil {
+		location = time.UTC
+	}
+	due := card.Due.In(location)
+	return time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// sameDay compares due dates, which only have a date
+func sameDay(a time.Time, b time.Time) bool {
+	return !a.IsZero() && a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// dueChanged reports whether a task due on current should be due on due
+// instead, a zero due meaning no due date
+func dueChanged(current time.Time, due time.Time) bool {
+	if due.IsZero() {
+		return !current.IsZero()
+	}
+	return !sameDay(current, due)
+}
+
+// goalCompletion closes out goals in progress once every success criterion
+// is checked and nothing is left in Tasks or Backlog. The card moves to Done
+// with its completion date, its open tasks are completed, and the WIP slots
+// it frees are filled in the same run.
+func (p *planner) goalCompletion() {
+	for p.closeOutGoals() {
+		p.goalPromotion()
+		p.taskSync()
+	}
+}
+
+// closeOutGoals plans the close-out of every finished goal in progress and
+// reports whether there were any
+func (p *planner) closeOutGoals() bool {
+	c := p.config
+	goals := &p.state.Goals
+	inProgressList, doneList := goals.ListByName(c.InProgressList), goals.ListByName(c.DoneList)
+	if inProgressList == nil || doneList == nil {
+		return false
+	}
+	closed := false
+	for _, card := range goals.CardsIn(inProgressList.ID) {
+		if !goalFinished(c, card) {
+			continue
+		}
+		p.add("goal-completion", card, MoveCardToList{Card: *card, ListID: doneList.ID})
+		p.add("goal-completion", card, CompleteCard{Card: *card, At: p.state.Now})
+		for _, task := range p.tasksMatching(card.ShortURL) {
+			if !task.Completed && task.ID != 0 {
+				p.add("goal-completion", card, CompleteTask{Task: task})
+			}
+		}
+		if list := p.state.GoalList(card.Name); c.GoalLists && list != nil && list.ID != 0 && !p.inProgressNamed(inProgressList, card.Name) {
+			p.add("goal-completion", card, RetireGoalList{List: *list})
+		}
+		closed = true
+	}
+	return closed
+}
+
+// goalFinished is true when a goal has success criteria, all of them are
+// checked, and Tasks and Backlog have no open items
+func goalFinished(c *Config, card *Card) bool {
+	successChecked, successUnchecked := card.Items(c.SuccessChecklist)
+	_, tasksUnchecked := card.Items(c.TasksChecklist)
+	_, backlogUnchecked := card.Items(c.BacklogChecklist)
+	return len(successChecked) > 0 && len(successUnchecked)+len(tasksUnchecked)+len(backlogUnchecked) == 0
+}
+
+// staleDetection flags cards that have sat in a list longer than its
+// stale-lists threshold, backlog cards without changes for stale-backlog and
+// tasks open longer than stale-tasks
+func (p *planner) staleDetection() {
+	c := p.config
+	now := p.state.Now
+	thresholds, err := c.StaleThresholds()
+	if err != nil {
+		return
+	}
+	for _, board := range []*Board{&p.state.Backlog, &p.state.Goals} {
+		// Moving cards isn't planned here, but keep to the cards as they were
+		for _, card := range append([]*Card(nil), board.Cards...) {
+			var listName string
+			for _, list := range board.Lists {
+				if list.ID == card.ListID {
+					listName = list.Name
+				}
+			}
+			threshold, inList := thresholds[listName]
+			var stale bool
+			switch {
+			case inList:
+				stale = threshold > 0 && !card.EnteredList.IsZero() && now.Sub(card.EnteredList) > threshold
+			case board == &p.state.Backlog:
+				threshold = c.StaleBacklog
+				stale = threshold > 0 && card.Idle(now) > threshold
+			default:
+				continue
+			}
+			if containsString(c.StaleFlags, StaleFlagLabel) {
+				// Labelling a card changes it, so a card that's only stale by
+				// going without changes keeps the label until it's removed
+				if stale || inList {
+					p.setLabel(board, card, c.StaleLabel, stale, "stale-detection")
+				}
+			}
+			if stale && containsString(c.StaleFlags, StaleFlagTask) {
+				p.reviewTask(card, threshold)
+			}
+		}
+	}
+
+	// Stale tasks are starred whichever flags stale cards get
+	if c.StaleTasks <= 0 {
+		return
+	}
+	for _, task := range p.state.Tasks {
+		if task.ID != 0 && !task.Completed && !task.Starred && !task.CreatedAt.IsZero() && now.Sub(task.CreatedAt) > c.StaleTasks {
+			p.add("stale-detection", nil, StarTask{Task: task})
+		}
+	}
+}
+
+// reviewTask asks for a stale card to be looked at, unless it's already been
+// asked for and the task is open or was finished within the threshold
+func (p *planner) reviewTask(card *Card, threshold time.Duration) {
+	title := fmt.Sprintf("Review stale card '%v' (%v)", card.Name, card.ShortURL)
+	for _, task := range findExistingTasks(p.state.Tasks, title, true) {
+		if !task.Completed || p.state.Now.Sub(task.CompletedAt) < threshold {
+			return
+		}
+	}
+	p.add("stale-detection", card, CreateTask{Title: title})
+}
+
+// reminders sets a reminder on open tasks that a reminders policy applies
+// to, and removes the reminders of completed tasks. A task that already has
+// a reminder keeps it.
+func (p *planner) reminders() {
+	policies, err := p.config.ReminderPolicies()
+	if err != nil || len(policies) == 0 {
+		return
+	}
+	location, err := p.config.Location()
+	if err != nil {
+		location = time.UTC
+	}
+	for _, task := range p.state.Tasks {
+		// Tasks created earlier in the plan get theirs on the next run
+		if task.ID == 0 {
+			continue
+		}
+		reminders := p.state.RemindersFor(task.ID)
+		if task.Completed {
+			for _, reminder := range reminders {
+				p.add("reminders", nil, DeleteReminder{Task: task, Reminder: reminder})
+			}
+			continue
+		}
+		if len(reminders) > 0 {
+			continue
+		}
+		if at := reminderAt(policies, task, p.state.Now, location); !at.IsZero() {
+			p.add("reminders", nil, CreateReminder{Task: task, At: at})
+		}
+	}
+}
+
+// reminderAt is the earliest reminder the policies call for that's still
+// ahead, zero if there's none. A reminder that would already have gone off
+// moves to the next time of day, as long as the task isn't overdue.
+func reminderAt(policies []ReminderPolicy, task wunderlist.Task, now time.Time, location *time.Location) time.Time {
+	var earliest time.Time
+	for _, policy := range policies {
+		var at, overdue time.Time
+		switch policy.Kind {
+		case ReminderDue:
+			if task.DueDate.IsZero() {
+				continue
+			}
+			due := time.Date(task.DueDate.Year(), task.DueDate.Month(), task.DueDate.Day(), 0, 0, 0, 0, location)
+			at = startOfDay(due.Add(-policy.Offset)).Add(policy.At)
+			overdue = due.AddDate(0, 0, 1)
+		case ReminderOpen:
+			if task.CreatedAt.IsZero() {
+				continue
+			}
+			at = nextTimeOfDay(task.CreatedAt.Add(policy.Offset).In(location), policy.At)
+		}
+		if !at.After(now) {
+			at = nextTimeOfDay(now.In(location), policy.At)
+		}
+		if !overdue.IsZero() && !at.Before(overdue) {
+			continue
+		}
+		if earliest.IsZero() || at.Before(earliest) {
+			earliest = at
+		}
+	}
+	return earliest
+}
+
+// startOfDay is midnight on t's day, in t's location
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// nextTimeOfDay is the first time after t that's at past midnight
+func nextTimeOfDay(t time.Time, at time.Duration) time.Time {
+	next := startOfDay(t).Add(at)
+	if !next.After(t) {
+		next = startOfDay(t).AddDate(0, 0, 1).Add(at)
+	}
+	return next
+}
+
+// burndown attaches the burndown chart to goals in progress, when charts
+// can be reached at public-url
+func (p *planner) burndown() {
+	c := p.config
+	inProgressList := p.state.Goals.ListByName(c.InProgressList)
+	if inProgressList == nil || c.PublicURL == "" {
+		return
+	}
+	for _, card := range p.state.Goals.CardsIn(inProgressList.ID) {
+		if url := burndownURL(c, card); !card.HasAttachment(url) {
+			p.add("burndown", card, AttachURL{Card: *card, Name: burndownAttachment, URL: url})
+		}
+	}
+}
+
+// apply changes the snapshot the way carrying out the action changes the
+// boards and inbox
+func (s *Snapshot) apply(action Action) {
+	defer s.touch(action)
+	switch a := action.(type) {
+	case CreateChecklist:
+		if card := s.card(a.Card.ID); card != nil {
+			card.Checklists = append(card.Checklists, &Checklist{Name: a.Name})
+		}
+	case AddLabel:
+		if card := s.card(a.Card.ID); card != nil {
+			card.Labels = append(card.Labels, a.Label)
+		}
+	case RemoveLabel:
+		if card := s.card(a.Card.ID); card != nil {
+			var remaining []Label
+			for _, label := range card.Labels {
+				if label.ID != a.Label.ID {
+					remaining = append(remaining, label)
+				}
+			}
+			card.Labels = remaining
+		}
+	case MoveCardToBoard:
+		for _, board := range []*Board{&s.Backlog, &s.Goals} {
+			for i, card := range board.Cards {
+				if card.ID == a.Card.ID {
+					board.Cards = append(board.Cards[:i:i], board.Cards[i+1:]...)
+					card.BoardID, card.ListID, card.EnteredList = a.BoardID, a.ListID, s.Now
+					if a.BoardID == s.Goals.ID {
+						s.Goals.Cards = append(s.Goals.Cards, card)
+					} else if a.BoardID == s.Backlog.ID {
+						s.Backlog.Cards = append(s.Backlog.Cards, card)
+					}
+					return
+				}
+			}
+		}
+	case MoveCardToList:
+		if card := s.card(a.Card.ID); card != nil {
+			card.ListID, card.EnteredList = a.ListID, s.Now
+		}
+	case CompleteCard:
+		if card := s.card(a.Card.ID); card != nil {
+			card.DueComplete = true
+		}
+	case AttachURL:
+		if card := s.card(a.Card.ID); card != nil {
+			card.Attachments = append(card.Attachments, Attachment{Name: a.Name, URL: a.URL})
+		}
+	case CommentOnCard:
+		if card := s.card(a.Card.ID); card != nil {
+			card.Comments = append(card.Comments, Comment{Text: a.Text, Date: s.Now})
+		}
+		if a.TaskComment != 0 {
+			s.CopiedTaskComments = append(s.CopiedTaskComments, a.TaskComment)
+		}
+	case CommentOnTask:
+		s.TaskComments = append(s.TaskComments, wunderlist.TaskComment{TaskID: a.Task.ID, Text: a.Text})
+	case MoveCheckItem:
+		card := s.card(a.Card.ID)
+		if card == nil {
+			return
+		}
+		for _, checklist := range card.Checklists {
+			for i, item := range checklist.Items {
+				if item.ID == a.Item.ID {
+					checklist.Items = append(checklist.Items[:i:i], checklist.Items[i+1:]...)
+					break
+				}
+			}
+		}
+		if checklist := card.Checklist(a.Checklist); checklist != nil {
+			item := a.Item
+			item.ChecklistID = checklist.ID
+			checklist.Items = append(checklist.Items, item)
+		}
+	case MarkCheckItem:
+		if card := s.card(a.Card.ID); card != nil {
+			for _, checklist := range card.Checklists {
+				for i := range checklist.Items {
+					if checklist.Items[i].ID == a.Item.ID {
+						checklist.Items[i].Complete = a.Complete
+					}
+				}
+			}
+		}
+	case CreateTask:
+		listID := s.Inbox.ID
+		if list := s.GoalList(a.List); a.List != "" && list != nil {
+			listID = list.ID
+		}
+		s.Tasks = append(s.Tasks, wunderlist.Task{Title: a.Title, ListID: listID, AssigneeID: s.User.ID, DueDate: a.Due})
+	case CreateGoalList:
+		s.GoalLists = append(s.GoalLists, wunderlist.List{Title: a.Title})
+	case RetireGoalList:
+		// Its tasks aren't loaded once it's out of goals-folder
+		for i, list := range s.GoalLists {
+			if list.ID == a.List.ID {
+				s.GoalLists = append(s.GoalLists[:i:i], s.GoalLists[i+1:]...)
+				break
+			}
+		}
+		var tasks []wunderlist.Task
+		for _, task := range s.Tasks {
+			if task.ListID != a.List.ID {
+				tasks = append(tasks, task)
+			}
+		}
+		s.Tasks = tasks
+	case UpdateTaskDue:
+		for i := range s.Tasks {
+			if s.Tasks[i].ID == a.Task.ID {
+				s.Tasks[i].DueDate = a.Due
+			}
+		}
+	case CompleteTask:
+		for i := range s.Tasks {
+			if s.Tasks[i].ID == a.Task.ID {
+				s.Tasks[i].Completed = true
+			}
+		}
+	case StarTask:
+		for i := range s.Tasks {
+			if s.Tasks[i].ID == a.Task.ID {
+				s.Tasks[i].Starred = true
+			}
+		}
+	case CreateNote:
+		s.Notes = append(s.Notes, wunderlist.Note{TaskID: a.Task.ID, Content: a.Content})
+	case UpdateNote:
+		for i := range s.Notes {
+			if s.Notes[i].TaskID == a.Note.TaskID {
+				s.Notes[i].Content = a.Content
+			}
+		}
+	case CreateSubtask:
+		s.Subtasks = append(s.Subtasks, wunderlist.Subtask{TaskID: a.Task.ID, Title: a.Title})
+	case CompleteSubtask:
+		for i := range s.Subtasks {
+			if s.Subtasks[i].ID == a.Subtask.ID {
+				s.Subtasks[i].Completed = true
+			}
+		}
+	case DeleteSubtask:
+		for i, subtask := range s.Subtasks {
+			if subtask.ID == a.Subtask.ID {
+				s.Subtasks = append(s.Subtasks[:i:i], s.Subtasks[i+1:]...)
+				break
+			}
+		}
+	case CreateReminder:
+		s.Reminders = append(s.Reminders, wunderlist.Reminder{TaskID: a.Task.ID, Date: a.At.UTC().Format(reminderLayout)})
+	case DeleteReminder:
+		for i, reminder := range s.Reminders {
+			if reminder.ID == a.Reminder.ID && reminder.TaskID == a.Reminder.TaskID {
+				s.Reminders = append(s.Reminders[:i:i], s.Reminders[i+1:]...)
+				break
+			}
+		}
+	case DeleteTask:
+		for i, task := range s.Tasks {
+			if task.ID == a.Task.ID {
+				s.Tasks = append(s.Tasks[:i:i], s.Tasks[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// touch marks the card an action changed as active now, like Trello does
+func (s *Snapshot) touch(action Action) {
+	var id string
+	switch a := action.(type) {
+	case CreateChecklist:
+		id = a.Card.ID
+	case AddLabel:
+		id = a.Card.ID
+	case RemoveLabel:
+		id = a.Card.ID
+	case MoveCardToBoard:
+		id = a.Card.ID
+	case MoveCardToList:
+		id = a.Card.ID
+	case CompleteCard:
+		id = a.Card.ID
+	case AttachURL:
+		id = a.Card.ID
+	case CommentOnCard:
+		id = a.Card.ID
+	case MoveCheckItem:
+		id = a.Card.ID
+	case MarkCheckItem:
+		id = a.Card.ID
+	}
+	if card := s.card(id); card != nil {
+		card.LastActivity = s.Now
+	}
+}
+
+// card finds a card on either board
+func (s *Snapshot) card(id string) *Card {
+	for _, board := range []*Board{&s.Backlog, &s.Goals} {
+		for _, card := range board.Cards {
+			if card.ID == id {
+				return card
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+func plannerConfig(wipLimit int) *Config {
+	return &Config{
+		WIPLimit:          wipLimit,
+		InProgressList:    "In Progress",
+		ToDoList:          "To Do",
+		DoneList:          "Done",
+		SuccessChecklist:  "Success Criteria",
+		TasksChecklist:    "Tasks",
+		BacklogChecklist:  "Backlog",
+		PlannedLabel:      "Planned",
+		NeedsSuccessLabel: "Needs success criteria",
+		NeedsTasksLabel:   "Needs tasks",
+		StaleLabel:        "Stale",
+		StaleLists:        []string{"In Progress=14d"},
+		StaleBacklog:      90 * 24 * time.Hour,
+		StaleTasks:        30 * 24 * time.Hour,
+		StaleFlags:        []string{StaleFlagLabel, StaleFlagTask},
+		PublicURL:         "https://miriam.example.com",
+		Reminders:         []string{"due=1d@09:00", "open=3d@18:00"},
+		TaskNotes:         true,
+		CommentSync:       true,
+	}
+}
+
+// randomWorld is a snapshot of random backlog and goal cards, some with
+// matching tasks, and a WIP limit
+type randomWorld struct {
+	Snapshot *Snapshot
+	WIPLimit int
+}
+
+func (randomWorld) Generate(r *rand.Rand, size int) reflect.Value {
+	ids := 0
+	id := func() string {
+		ids++
+		return fmt.Sprintf("%024x", ids)
+	}
+	s := &Snapshot{Now: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), Inbox: wunderlist.List{ID: 1}, User: wunderlist.User{ID: 2}}
+	daysAgo := func() time.Time {
+		return s.Now.Add(-time.Duration(r.Intn(200)) * 24 * time.Hour)
+	}
+	s.Backlog = Board{ID: id(), ShortURL: "https://trello.com/b/backlog"}
+	s.Backlog.Lists = []List{{ID: id(), Name: "Someday"}}
+	for _, name := range []string{"Planned", "Needs success criteria", "Needs tasks", "Stale"} {
+		s.Backlog.Labels = append(s.Backlog.Labels, Label{ID: id(), Name: name})
+	}
+	s.Goals = Board{ID: id(), ShortURL: "https://trello.com/b/goals"}
+	for _, name := range []string{"To Do", "In Progress", "Done"} {
+		s.Goals.Lists = append(s.Goals.Lists, List{ID: id(), Name: name})
+	}
+	s.Goals.Labels = []Label{{ID: id(), Name: "Stale"}}
+
+	// Item names never contain each other, so tasks only match their own item
+	items := 0
+	addCard := func(board *Board, list List, checklists ...string) *Card {
+		card := &Card{ID: id(), BoardID: board.ID, ListID: list.ID, LastActivity: daysAgo(), EnteredList: daysAgo()}
+		card.Name = "card " + card.ID[20:]
+		card.ShortURL = "https://trello.com/c/" + card.ID[16:]
+		for _, name := range checklists {
+			if r.Intn(4) == 0 {
+				continue
+			}
+			checklist := &Checklist{ID: id(), Name: name}
+			for i := r.Intn(4); i > 0; i-- {
+				items++
+				item := CheckItem{ID: id(), Name: fmt.Sprintf("item %03d.", items), ChecklistID: checklist.ID, Complete: r.Intn(2) == 0}
+				checklist.Items = append(checklist.Items, item)
+				if r.Intn(2) == 0 {
+					task := wunderlist.Task{ID: uint(len(s.Tasks) + 100), Title: fmt.Sprintf("%v (%v)", item.Name, card.ShortURL), Completed: r.Intn(2) == 0, CreatedAt: daysAgo()}
+					if r.Intn(3) == 0 {
+						s.Reminders = append(s.Reminders, wunderlist.Reminder{ID: task.ID + 1000, TaskID: task.ID, Date: "2026-03-03T09:00:00.000Z"})
+					}
+					if r.Intn(3) == 0 {
+						s.TaskComments = append(s.TaskComments, wunderlist.TaskComment{ID: task.ID + 2000, TaskID: task.ID, Text: "comment on " + item.Name})
+					}
+					s.Tasks = append(s.Tasks, task)
+				}
+			}
+			card.Checklists = append(card.Checklists, checklist)
+		}
+		for i := r.Intn(3); i > 0; i-- {
+			card.Comments = append(card.Comments, Comment{ID: id(), Author: "Ada", Text: "comment " + id(), Date: daysAgo()})
+		}
+		board.Cards = append(board.Cards, card)
+		return card
+	}
+	for i := r.Intn(size%6 + 1); i > 0; i-- {
+		card := addCard(&s.Backlog, s.Backlog.Lists[0], "Success Criteria", "Tasks", "Backlog")
+		for _, label := range s.Backlog.Labels {
+			if r.Intn(3) == 0 {
+				card.Labels = append(card.Labels, label)
+			}
+		}
+	}
+	for i := r.Intn(size%6 + 1); i > 0; i-- {
+		card := addCard(&s.Goals, s.Goals.Lists[r.Intn(len(s.Goals.Lists))], "Success Criteria", "Tasks", "Backlog")
+		if r.Intn(2) == 0 {
+			s.GoalLists = append(s.GoalLists, wunderlist.List{ID: uint(len(s.GoalLists) + 500), Title: card.Name})
+		}
+	}
+	return reflect.ValueOf(randomWorld{Snapshot: s, WIPLimit: r.Intn(3) + 1})
+}
+
+func TestPlanIsPure(t *testing.T) {
+	property := func(w randomWorld) bool {
+		before := w.Snapshot.Clone()
+		first := Plan(plannerConfig(w.WIPLimit), w.Snapshot)
+		second := Plan(plannerConfig(w.WIPLimit), w.Snapshot)
+		return reflect.DeepEqual(w.Snapshot, before) && reflect.DeepEqual(first, second)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlanConverges(t *testing.T) {
+	property := func(w randomWorld) bool {
+		c := plannerConfig(w.WIPLimit)
+		after := applySteps(w.Snapshot, Plan(c, w.Snapshot))
+		if again := Plan(c, after); len(again) > 0 {
+			t.Logf("second plan wasn't empty: %v", again)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlanConvergesWithSubtasks(t *testing.T) {
+	property := func(w randomWorld) bool {
+		c := plannerConfig(w.WIPLimit)
+		c.TaskMode = TaskModeSubtasks
+		after := applySteps(w.Snapshot, Plan(c, w.Snapshot))
+		if again := Plan(c, after); len(again) > 0 {
+			t.Logf("second plan wasn't empty: %v", again)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlanConvergesWithGoalLists(t *testing.T) {
+	property := func(w randomWorld) bool {
+		c := plannerConfig(w.WIPLimit)
+		c.GoalLists, c.GoalsFolder = true, "Goals"
+		after := applySteps(w.Snapshot, Plan(c, w.Snapshot))
+		if again := Plan(c, after); len(again) > 0 {
+			t.Logf("second plan wasn't empty: %v", again)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlanRespectsWIPLimit(t *testing.T) {
+	property := func(w randomWorld) bool {
+		inProgress := w.Snapshot.Goals.ListByName("In Progress")
+		limit := len(w.Snapshot.Goals.CardsIn(inProgress.ID))
+		if limit < w.WIPLimit {
+			limit = w.WIPLimit
+		}
+		after := applySteps(w.Snapshot, Plan(plannerConfig(w.WIPLimit), w.Snapshot))
+		return len(after.Goals.CardsIn(inProgress.ID)) <= limit
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlanOnlyNamedJobs(t *testing.T) {
+	s := &Snapshot{
+		Backlog: Board{ID: "backlog", Labels: []Label{{ID: "planned", Name: "Planned"}}},
+		Goals:   Board{ID: "goals", Lists: []List{{ID: "todo", Name: "To Do"}, {ID: "doing", Name: "In Progress"}}},
+	}
+	s.Backlog.Cards = []*Card{{ID: "card", Name: "Run a marathon", BoardID: "backlog", Labels: []Label{{ID: "planned", Name: "Planned"}}}}
+
+	var rules []string
+	for _, step := range Plan(plannerConfig(1), s, "goal-promotion") {
+		rules = append(rules, fmt.Sprintf("%s: %v", step.Rule, step.Action))
+	}
+	expected := []string{
+		"planned-move: Remove label 'Planned' from card 'Run a marathon'",
+		"planned-move: Move card 'Run a marathon' to board goals",
+		"goal-promotion: Move card 'Run a marathon' to list doing",
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often the config directories are checked for changes
+var reloadCheckInterval = 10 * time.Second
+
+// configWatcher notices changes to the config file and the per-file config
+// and secrets directories. Kubernetes updates ConfigMaps by swapping the
+// ..data symlink, so files are compared by content rather than modification
+// time.
+type configWatcher struct {
+	configFile string
+	dirs       []string
+	last       string
+}
+
+func newConfigWatcher(configFile string, dirs ...string) *configWatcher {
+	w := &configWatcher{configFile: configFile, dirs: dirs}
+	w.last = w.fingerprint()
+	return w
+}
+
+// fingerprint hashes the name and contents of every visible file, following
+// symlinks. Unreadable files hash as missing, the next load will report them.
+func (w *configWatcher) fingerprint() string {
+	hash := sha256.New()
+	files := []string{w.configFile}
+	for _, dir := range w.dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// Skip the ..data and timestamped directories of a ConfigMap mount
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			files = append(files, path.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		hash.Write([]byte(file))
+		hash.Write([]byte{0})
+		hash.Write(contents)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Changed reports whether anything changed since the last call
+func (w *configWatcher) Changed() bool {
+	current := w.fingerprint()
+	if current == w.last {
+		return false
+	}
+	w.last = current
+	return true
+}
+
+// Settings only read when the daemon starts, when connecting to chat or
+// starting the health check listener
+var restartKeys = []string{"chat", "chat-channel", "listen-address"}
+var restartPrefixes = []string{"rocketchat-", "slack-", "matrix-", "webhook-"}
+
+// restartSettings lists the settings that changed between two configurations
+// but only take effect after a restart
+func restartSettings(old, new *Config) []string {
+	changed := []string{}
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		key := ov.Type().Field(i).Tag.Get("config")
+		if !needsRestart(key) {
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+func needsRestart(key string) bool {
+	for _, k := range restartKeys {
+		if key == k {
+			return true
+		}
+	}
+	for _, prefix := range restartPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadConfig loads and validates the configuration again, returning an App
+// for it that keeps the current chat connection. An invalid configuration is
+// rejected and the current App is kept. Changed chat settings are reported,
+// they need a restart to reconnect.
+func reloadConfig(current *App) (*App, bool) {
+	c, err := LoadConfig(ConfigFile, ConfigPath, SecretsPath)
+	if err != nil {
+		log.Printf("Rejecting new configuration, keeping the current one: %v", err)
+		return current, false
+	}
+	app := NewApp(c)
+	app.Chat, app.runs = current.Chat, current.runs
+	app.SetClock(current.Clock)
+	if changed := restartSettings(current.Config, c); len(changed) > 0 {
+		fmt.Printf("Reloaded configuration, but %v only apply after a restart, keeping the current chat connection\n", strings.Join(changed, ", "))
+		return app, true
+	}
+	fmt.Println("Reloaded configuration")
+	return app, true
+}
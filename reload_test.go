@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Lay the directory out like a Kubernetes ConfigMap mount
+	first := filepath.Join(dir, "..2026_10_19_09_00_00.1")
+	second := filepath.Join(dir, "..2026_10_19_10_00_00.2")
+	os.Mkdir(first, 0755)
+	os.Mkdir(second, 0755)
+	writeConfigFiles(t, first, map[string]string{"interval": "5m"})
+	writeConfigFiles(t, second, map[string]string{"interval": "10m"})
+	if err := os.Symlink(filepath.Base(first), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "interval"), filepath.Join(dir, "interval")); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := newConfigWatcher(filepath.Join(dir, "miriam.yaml"), dir)
+	if watcher.Changed() {
+		t.Error("expected no change before anything was modified")
+	}
+
+	// Swap the ..data symlink the way the kubelet does
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(second), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if !watcher.Changed() {
+		t.Error("expected a symlink swap to be noticed")
+	}
+	if watcher.Changed() {
+		t.Error("expected the change to be reported once")
+	}
+
+	writeConfigFiles(t, dir, map[string]string{"miriam.yaml": "wip-limit: 2\n"})
+	if !watcher.Changed() {
+		t.Error("expected a new config file to be noticed")
+	}
+}
+
+func TestReloadConfigKeepsCurrentOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFiles(t, dir, map[string]string{"miriam.yaml": "interval: soon\n"})
+
+	oldFile := ConfigFile
+	defer func() { ConfigFile = oldFile }()
+	ConfigFile = filepath.Join(dir, "miriam.yaml")
+
+	current := &App{Config: &Config{TrelloGoals: "goals"}}
+	reloaded, ok := reloadConfig(current)
+	if ok {
+		t.Error("expected the invalid configuration to be rejected")
+	}
+	if reloaded != current {
+		t.Error("expected the current configuration to be kept")
+	}
+}
+
+func TestReloadConfigReportsChatChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miriam-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFiles(t, dir, map[string]string{"miriam.yaml": "trello-key: key\ntrello-token: token\ntrello-backlog: backlog\ntrello-goals: goals\nwunderlist-client-id: client\nwunderlist-access-token: token\nchat: webhook\nwebhook-url: http://chat.example/new\nchat-channel: planning\nchat-users: matt,sam\n"})
+
+	oldFile := ConfigFile
+	defer func() { ConfigFile = oldFile }()
+	ConfigFile = filepath.Join(dir, "miriam.yaml")
+
+	previous, err := LoadConfig(ConfigFile, ConfigPath, SecretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.WebhookURL = "http://chat.example/old"
+	previous.ChatChannel = "house-party"
+	previous.ChatUsers = []string{"matt"}
+	chat := &fakeChat{}
+	reloaded, ok := reloadConfig(&App{Config: previous, Chat: chat})
+	if !ok {
+		t.Fatal("expected the configuration to be reloaded")
+	}
+	if reloaded.Chat != chat {
+		t.Error("expected the current chat connection to be kept")
+	}
+	// chat-users is read on every message, so it applies straight away
+	changed := restartSettings(previous, reloaded.Config)
+	if strings.Join(changed, ",") != "webhook-url,chat-channel" {
+		t.Errorf("expected webhook-url and chat-channel to need a restart, got %v", changed)
+	}
+}
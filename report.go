@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/pkg/errors"
+)
+
+// reportPath is where the health check server serves the report
+const reportPath = "/report"
+
+// Report formats for the report command and endpoint
+var reportFormats = []string{"table", "csv", "json"}
+
+// goalHistory is a card on the goals board with its list changes, which
+// follow the card from the backlog board
+type goalHistory struct {
+	Card    *Card
+	List    string
+	Actions trello.ActionCollection
+}
+
+// Report is the cycle time and throughput of the goals board. Lists is every
+// list but Done that a goal has been in, in the order goals first reached
+// them.
+type Report struct {
+	Generated  time.Time      `json:"generated"`
+	Lists      []string       `json:"lists"`
+	Goals      []GoalReport   `json:"goals"`
+	Throughput []WeekCount    `json:"throughput"`
+	Location   *time.Location `json:"-"`
+}
+
+// GoalReport is when a goal was created, started and done, its lead time
+// (created to done) and cycle time (started to done), and how long it spent
+// in each list. Time in the done list isn't counted.
+type GoalReport struct {
+	Name       string
+	URL        string
+	List       string
+	Created    time.Time
+	Started    time.Time
+	Done       time.Time
+	LeadTime   time.Duration
+	CycleTime  time.Duration
+	TimeInList map[string]time.Duration
+}
+
+// WeekCount is how many goals were done in the week starting on Week, a
+// Monday
+type WeekCount struct {
+	Week      time.Time `json:"week"`
+	Completed int       `json:"completed"`
+}
+
+// listAfter is the list an action put the card in, nil if it didn't
+func listAfter(action *trello.Action) *trello.List {
+	if action.Data == nil {
+		return nil
+	}
+	if action.Type == "moveCardToBoard" {
+		return action.Data.List
+	}
+	return trello.ListAfterAction(action)
+}
+
+// cycleReport works out the report from the goals' histories
+func cycleReport(c *Config, goals []goalHistory, now time.Time, location *time.Location) *Report {
+	r := &Report{Generated: now, Location: location}
+	seen := map[string]bool{}
+	weeks := map[time.Time]int{}
+	for _, goal := range goals {
+		g := GoalReport{Name: goal.Card.Name, URL: goal.Card.ShortURL, List: goal.List, TimeInList: map[string]time.Duration{}}
+		actions := append(trello.ActionCollection(nil), goal.Actions...)
+		sort.SliceStable(actions, func(i, j int) bool { return actions[i].Date.Before(actions[j].Date) })
+		var current string
+		var since time.Time
+		for _, action := range actions {
+			if !action.DidChangeListForCard() && action.Type != "moveCardToBoard" {
+				continue
+			}
+			if current != "" && current != c.DoneList {
+				g.TimeInList[current] += action.Date.Sub(since)
+			}
+			current, since = "", action.Date
+			if action.DidCreateCard() && g.Created.IsZero() {
+				g.Created = action.Date
+			}
+			list := listAfter(action)
+			if list == nil {
+				continue
+			}
+			current = list.Name
+			if !seen[current] && current != c.DoneList {
+				seen[current] = true
+				r.Lists = append(r.Lists, current)
+			}
+			if current == c.InProgressList && g.Started.IsZero() {
+				g.Started = action.Date
+			}
+			// A goal taken back out of Done isn't done until it returns
+			g.Done = time.Time{}
+			if current == c.DoneList {
+				g.Done = action.Date
+			}
+		}
+		if current != "" && current != c.DoneList {
+			g.TimeInList[current] += now.Sub(since)
+		}
+		if !g.Done.IsZero() {
+			if !g.Created.IsZero() {
+				g.LeadTime = g.Done.Sub(g.Created)
+			}
+			if !g.Started.IsZero() {
+				g.CycleTime = g.Done.Sub(g.Started)
+			}
+			weeks[weekOf(g.Done, location)]++
+		}
+		r.Goals = append(r.Goals, g)
+	}
+
+	// Every week from the first goal done to now, including empty ones
+	var first time.Time
+	for week := range weeks {
+		if first.IsZero() || week.Before(first) {
+			first = week
+		}
+	}
+	if !first.IsZero() {
+		for week := first; !week.After(now); week = week.AddDate(0, 0, 7) {
+			r.Throughput = append(r.Throughput, WeekCount{Week: week, Completed: weeks[week]})
+		}
+	}
+	return r
+}
+
+// weekOf is midnight on the Monday starting t's week
+func weekOf(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	days := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, location)
+}
+
+// days is a duration in days, to one decimal place
+func days(d time.Duration) string {
+	return fmt.Sprintf("%.1f", d.Hours()/24)
+}
+
+// MarshalJSON writes durations in days and leaves out times that aren't known
+func (g GoalReport) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{"name": g.Name, "url": g.URL, "list": g.List}
+	for key, t := range map[string]time.Time{"created": g.Created, "started": g.Started, "done": g.Done} {
+		if !t.IsZero() {
+			out[key] = t
+		}
+	}
+	if g.LeadTime > 0 {
+		out["lead_time_days"] = g.LeadTime.Hours() / 24
+	}
+	if g.CycleTime > 0 {
+		out["cycle_time_days"] = g.CycleTime.Hours() / 24
+	}
+	inList := map[string]float64{}
+	for list, d := range g.TimeInList {
+		inList[list] = d.Hours() / 24
+	}
+	out["days_in_list"] = inList
+	return json.Marshal(out)
+}
+
+// Write writes the report as a table, CSV or JSON. The CSV has a row per
+// goal, and the weekly throughput instead when throughput is true.
+func (r *Report) Write(w io.Writer, format string, throughput bool) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case "csv":
+		out := csv.NewWriter(w)
+		for _, row := range r.rows(throughput, r.formatTime(time.RFC3339)) {
+			out.Write(row)
+		}
+		out.Flush()
+		return out.Error()
+	case "table":
+		out := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, row := range r.rows(false, r.formatTime("2006-01-02")) {
+			fmt.Fprintln(out, strings.Join(row, "\t"))
+		}
+		fmt.Fprintln(out)
+		for _, row := range r.rows(true, nil) {
+			fmt.Fprintln(out, strings.Join(row, "\t"))
+		}
+		return out.Flush()
+	}
+	return fmt.Errorf("Unknown report format '%s', expected one of %s", format, strings.Join(reportFormats, ", "))
+}
+
+func (r *Report) formatTime(layout string) func(time.Time) string {
+	return func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.In(r.Location).Format(layout)
+	}
+}
+
+// rows is the goals or the weekly throughput, with a header row
+func (r *Report) rows(throughput bool, formatTime func(time.Time) string) [][]string {
+	if throughput {
+		rows := [][]string{{"week", "completed"}}
+		for _, week := range r.Throughput {
+			rows = append(rows, []string{week.Week.Format("2006-01-02"), fmt.Sprint(week.Completed)})
+		}
+		return rows
+	}
+	header := []string{"goal", "url", "list", "created", "started", "done", "lead time (days)", "cycle time (days)"}
+	for _, list := range r.Lists {
+		header = append(header, fmt.Sprintf("days in %v", list))
+	}
+	rows := [][]string{header}
+	for _, g := range r.Goals {
+		row := []string{g.Name, g.URL, g.List, formatTime(g.Created), formatTime(g.Started), formatTime(g.Done), "", ""}
+		if g.LeadTime > 0 {
+			row[6] = days(g.LeadTime)
+		}
+		if g.CycleTime > 0 {
+			row[7] = days(g.CycleTime)
+		}
+		for _, list := range r.Lists {
+			if d, ok := g.TimeInList[list]; ok {
+				row = append(row, days(d))
+			} else {
+				row = append(row, "")
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Trello returns at most this many actions per request
+const boardActionsPage = 1000
+
+// boardHistory loads the list changes on a board, cards that have since been
+// archived included, grouped by card ID
+func (a *App) boardHistory(boardID string) (map[string]trello.ActionCollection, error) {
+	history := map[string]trello.ActionCollection{}
+	args := trello.Arguments{"filter": listChangeFilter, "limit": fmt.Sprint(boardActionsPage)}
+	for {
+		actions, err := a.Boards.GetBoardActions(boardID, args)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error loading the history of board %s", boardID)
+		}
+		for _, action := range actions.FilterToListChangeActions() {
+			if action.Data != nil && action.Data.Card != nil {
+				history[action.Data.Card.ID] = append(history[action.Data.Card.ID], action)
+			}
+		}
+		// Newest first, so the next page is everything before the last one
+		if len(actions) < boardActionsPage {
+			return history, nil
+		}
+		args["before"] = actions[len(actions)-1].ID
+	}
+}
+
+// loadReport loads the history of every goal, archived ones included, and
+// works out the report
+func (a *App) loadReport() (*Report, error) {
+	location, err := a.Config.Location()
+	if err != nil {
+		return nil, err
+	}
+	lists, err := a.Boards.GetLists(a.Config.TrelloGoals)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error loading lists for goals board %s", a.Config.TrelloGoals)
+	}
+	history, err := a.boardHistory(a.Config.TrelloGoals)
+	if err != nil {
+		return nil, err
+	}
+	// Goals start out on the backlog board, which has their history before
+	// they moved
+	backlog, err := a.boardHistory(a.Config.TrelloBacklog)
+	if err != nil {
+		return nil, err
+	}
+	var goals []goalHistory
+	open := map[string]bool{}
+	for _, list := range lists {
+		cards, err := a.Boards.GetListCards(list.ID, trello.Defaults())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error loading cards for list %s", list.Name)
+		}
+		for _, card := range cards {
+			open[card.ID] = true
+			actions := append(backlog[card.ID], history[card.ID]...)
+			goals = append(goals, goalHistory{Card: snapshotCard(card), List: list.Name, Actions: actions})
+		}
+	}
+	// Archived goals are only in the history, as they were when last moved.
+	// Cards that left the board some other way, like back to the backlog,
+	// aren't goals any more.
+	var archived []string
+	for id := range history {
+		if !open[id] && endedOnGoalsBoard(a.Config, append(backlog[id], history[id]...)) {
+			archived = append(archived, id)
+		}
+	}
+	sort.Strings(archived)
+	for _, id := range archived {
+		actions := append(backlog[id], history[id]...)
+		latest := actions[0]
+		for _, action := range actions {
+			if action.Date.After(latest.Date) {
+				latest = action
+			}
+		}
+		card := &Card{ID: id, Name: latest.Data.Card.Name, ShortURL: "https://trello.com/c/" + latest.Data.Card.ShortLink}
+		goal := goalHistory{Card: card, Actions: actions}
+		if list := lastList(actions); list != nil {
+			goal.List = list.Name
+		}
+		goals = append(goals, goal)
+	}
+	return cycleReport(a.Config, goals, a.Clock.Now(), location), nil
+}
+
+// endedOnGoalsBoard reports whether a card's history ends with it archived
+// on the goals board or moved to its Done list
+func endedOnGoalsBoard(c *Config, actions trello.ActionCollection) bool {
+	actions = append(trello.ActionCollection(nil), actions...)
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Date.Before(actions[j].Date) })
+	ended := false
+	for _, action := range actions {
+		onGoals := action.Data.Board != nil && action.Data.Board.ID == c.TrelloGoals
+		if action.DidArchiveCard() {
+			ended = onGoals
+		} else if list := listAfter(action); list != nil {
+			ended = onGoals && list.Name == c.DoneList
+		}
+	}
+	return ended
+}
+
+// lastList is the last list actions put a card in
+func lastList(actions trello.ActionCollection) *trello.List {
+	var last *trello.Action
+	for _, action := range actions {
+		if listAfter(action) != nil && (last == nil || action.Date.After(last.Date)) {
+			last = action
+		}
+	}
+	if last == nil {
+		return nil
+	}
+	return listAfter(last)
+}
+
+// reportHandler serves the report as JSON, or the format asked for with
+// ?format=, for the App that's current when the request comes in
+type reportHandler func() *App
+
+func (h reportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = "json"
+	}
+	if !containsString(reportFormats, format) {
+		http.Error(w, fmt.Sprintf("format must be one of %s", strings.Join(reportFormats, ", ")), http.StatusBadRequest)
+		return
+	}
+	report, err := h().loadReport()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	contentType := map[string]string{"json": "application/json", "csv": "text/csv", "table": "text/plain"}[format]
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	report.Write(w, format, r.FormValue("throughput") == "true")
+}
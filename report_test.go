@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adlio/trello"
+)
+
+func TestReport(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	day := 24 * time.Hour
+	move := func(card *trello.Card, args trello.Arguments) {
+		if _, err := w.App.Boards.UpdateCard(card.ID, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two days in the backlog, three in To Do and four in progress
+	goal := w.Trello.AddCard(w.Someday, "Learn Go")
+	w.Clock.Advance(2 * day)
+	move(goal, trello.Arguments{"idBoard": w.Goals.ID})
+	w.Clock.Advance(3 * day)
+	move(goal, trello.Arguments{"idList": w.InProgress.ID})
+	started := w.Trello.AddCard(w.InProgress, "Run a marathon")
+	w.Clock.Advance(4 * day)
+	move(goal, trello.Arguments{"idList": w.Done.ID})
+	w.Clock.Advance(day)
+	// Done goals get archived, they still count
+	move(goal, trello.Arguments{"closed": "true"})
+	// Cards moved back to the backlog aren't goals any more
+	idea := w.Trello.AddCard(w.Someday, "Write a novel")
+	move(idea, trello.Arguments{"idBoard": w.Goals.ID})
+	move(idea, trello.Arguments{"idBoard": w.Backlog.ID})
+
+	report, err := w.App.loadReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range w.Trello.Requests {
+		if strings.HasPrefix(request, "GET /cards/") {
+			t.Errorf("expected the history to be loaded by board, got %v", request)
+		}
+	}
+	expectStrings(t, "lists", report.Lists, "Someday", "To Do", "In Progress")
+	if len(report.Goals) != 2 {
+		t.Fatalf("expected two goals, got %+v", report.Goals)
+	}
+	var done, doing GoalReport
+	for _, g := range report.Goals {
+		if g.URL == goal.ShortUrl {
+			done = g
+		} else if g.URL == started.ShortUrl {
+			doing = g
+		}
+	}
+	if done.List != "Done" || done.LeadTime != 9*day || done.CycleTime != 4*day {
+		t.Errorf("expected a lead time of 9 days and cycle time of 4, got %+v", done)
+	}
+	if done.TimeInList["Someday"] != 2*day || done.TimeInList["To Do"] != 3*day || done.TimeInList["In Progress"] != 4*day {
+		t.Errorf("unexpected time in lists %v", done.TimeInList)
+	}
+	if doing.LeadTime != 0 || doing.CycleTime != 0 || doing.TimeInList["In Progress"] != 5*day {
+		t.Errorf("expected a goal in progress for 5 days without lead or cycle time, got %+v", doing)
+	}
+	if len(report.Throughput) != 1 || report.Throughput[0].Week.Format("2006-01-02") != "2026-03-09" || report.Throughput[0].Completed != 1 {
+		t.Errorf("expected one goal done in the week of 9 March, got %+v", report.Throughput)
+	}
+
+	var table strings.Builder
+	if err := report.Write(&table, "table", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"lead time (days)", "9.0", "4.0", "2026-03-11", "week        completed", "2026-03-09  1"} {
+		if !strings.Contains(table.String(), expected) {
+			t.Errorf("expected %q in the table:\n%v", expected, table.String())
+		}
+	}
+
+	// The endpoint serves JSON by default, and CSV when asked
+	handler := reportHandler(func() *App { return w.App })
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", reportPath, nil))
+	var served map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &served); err != nil {
+		t.Fatalf("expected JSON, got %v: %v", response.Body.String(), err)
+	}
+	goals := served["goals"].([]interface{})
+	// Goals come in list order, so the one done is last
+	if len(goals) != 2 || goals[1].(map[string]interface{})["lead_time_days"] != 9.0 {
+		t.Errorf("expected the lead time in days, got %v", goals)
+	}
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", reportPath+"?format=csv&throughput=true", nil))
+	if csv := response.Body.String(); csv != "week,completed\n2026-03-09,1\n" || response.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("expected the throughput as CSV, got %q", csv)
+	}
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", reportPath+"?format=xml", nil))
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown format to be refused, got %v", response.Code)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// chatCommand is something miriam can be asked in chat. Run gets the text
+// after the command's name and returns the reply.
+type chatCommand struct {
+	Name  string
+	Args  string
+	Help  string
+	Run   func(a *App, arg string) (string, error)
+	alias []string
+}
+
+// chatCommands is set in init, help needs to list it
+var chatCommands []chatCommand
+
+func init() {
+	chatCommands = []chatCommand{
+		{Name: "status", Help: "See if I am online", Run: (*App).chatStatus, alias: []string{"check in"}},
+		{Name: "next", Help: "Show the goal in progress and its next task", Run: (*App).chatNext},
+		{Name: "done", Args: "<task>", Help: "Complete a task in Wunderlist and Trello", Run: (*App).chatDone},
+		{Name: "plan", Args: "<card>", Help: "Mark a backlog card as planned and move it to the goals board", Run: (*App).chatPlan},
+		{Name: "backlog", Args: "<card>", Help: "List a card's Backlog checklist", Run: (*App).chatBacklog},
+		{Name: "burndown", Help: "Show how the goals in progress, or the one named, are burning down, with links to their charts", Run: (*App).chatBurndown},
+		{Name: "sync", Help: "Run every job now", Run: (*App).chatSync},
+		{Name: "add", Args: "<idea>", Help: "Add a card to the backlog's ideas", Run: (*App).chatAdd},
+		{Name: "help", Help: "Get a list of commands", Run: (*App).chatHelp, alias: []string{"commands"}},
+	}
+}
+
+// routeChatMessage finds the command a message starts with, and the rest of
+// the message as its argument
+func routeChatMessage(text string) (*chatCommand, string) {
+	text = strings.TrimSpace(text)
+	lower := strings.ToLower(text)
+	for i := range chatCommands {
+		command := &chatCommands[i]
+		for _, name := range append([]string{command.Name}, command.alias...) {
+			if lower == name || strings.HasPrefix(lower, name+" ") {
+				return command, strings.TrimSpace(text[len(name):])
+			}
+		}
+	}
+	return nil, text
+}
+
+// handleChatMessage runs the command in a message and sends the reply.
+// Messages that aren't commands are just conversation and are ignored, and
+// only chat-users are answered, everyone else is a bot.
+func (a *App) handleChatMessage(msg ChatMessage) {
+	if msg.User == "" {
+		var err error
+		if msg.User, err = a.Chat.LookupUser(msg.UserID); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if !containsString(a.Config.ChatUsers, msg.User) {
+		return
+	}
+	command, arg := routeChatMessage(msg.Text)
+	if command == nil {
+		return
+	}
+	var reply string
+	if command.Args != "" && arg == "" {
+		reply = fmt.Sprintf("Usage: *%v* %v", command.Name, command.Args)
+	} else {
+		var err error
+		if reply, err = command.Run(a, arg); err != nil {
+			log.Println(err)
+			reply = fmt.Sprintf("*%v* failed: %v", command.Name, err)
+		}
+	}
+	if err := a.Chat.Send(msg.Channel, reply); err != nil {
+		log.Println(err)
+	}
+}
+
+// startChatListener answers commands sent in the chat channel
+func (a *App) startChatListener() error {
+	if a.Chat == nil {
+		return fmt.Errorf("No chat is configured")
+	}
+	return a.Chat.Subscribe(a.handleChatMessage)
+}
+
+func (a *App) chatStatus(arg string) (string, error) {
+	return "I'm online", nil
+}
+
+func (a *App) chatHelp(arg string) (string, error) {
+	reply := "Here are commands I can respond to:"
+	for _, command := range chatCommands {
+		usage := command.Name
+		if command.Args != "" {
+			usage += " " + command.Args
+		}
+		reply = fmt.Sprintf("%v\n> *%v*: %v", reply, usage, command.Help)
+	}
+	return reply, nil
+}
+
+func (a *App) chatNext(arg string) (string, error) {
+	snapshot, err := a.loadSnapshot()
+	if err != nil {
+		return "", err
+	}
+	var goals []*Card
+	if list := snapshot.Goals.ListByName(a.Config.InProgressList); list != nil {
+		goals = snapshot.Goals.CardsIn(list.ID)
+	}
+	if len(goals) == 0 {
+		return fmt.Sprintf("Nothing is in %v, *plan* a backlog card to start a goal", a.Config.InProgressList), nil
+	}
+	var lines []string
+	for _, goal := range goals {
+		lines = append(lines, fmt.Sprintf("*%v* (%v)", goal.Name, goal.ShortURL))
+		if _, unchecked := goal.Items(a.Config.TasksChecklist); len(unchecked) > 0 {
+			lines = append(lines, fmt.Sprintf("> Next task: %v", unchecked[0].Name))
+		} else {
+			lines = append(lines, "> No open tasks")
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// matchingCards returns the cards whose name contains text, or just the one
+// whose name is text
+func matchingCards(cards []*Card, text string) []*Card {
+	var matches []*Card
+	for _, card := range cards {
+		if strings.EqualFold(card.Name, text) {
+			return []*Card{card}
+		}
+		if strings.Contains(strings.ToLower(card.Name), strings.ToLower(text)) {
+			matches = append(matches, card)
+		}
+	}
+	return matches
+}
+
+// oneCard picks the single card matching text or explains why it can't
+func oneCard(cards []*Card, text string) (*Card, string) {
+	matches := matchingCards(cards, text)
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Sprintf("No card matches '%v'", text)
+	case 1:
+		return matches[0], ""
+	}
+	reply := fmt.Sprintf("'%v' matches %v cards, which one?", text, len(matches))
+	for _, card := range matches {
+		reply = fmt.Sprintf("%v\n> %v", reply, card.Name)
+	}
+	return nil, reply
+}
+
+func (a *App) chatDone(arg string) (string, error) {
+	var reply string
+	err := a.change(func(snapshot *Snapshot) ([]Step, error) {
+		list := snapshot.Goals.ListByName(a.Config.InProgressList)
+		if list == nil {
+			reply = fmt.Sprintf("There is no %v list", a.Config.InProgressList)
+			return nil, nil
+		}
+		var found []string
+		var steps []Step
+		for _, card := range snapshot.Goals.CardsIn(list.ID) {
+			_, unchecked := card.Items(a.Config.TasksChecklist)
+			for _, item := range unchecked {
+				if !strings.Contains(strings.ToLower(item.Name), strings.ToLower(arg)) {
+					continue
+				}
+				found = append(found, fmt.Sprintf("%v on *%v*", item.Name, card.Name))
+				steps = append(steps, Step{Rule: "chat", CardID: card.ID, Action: MarkCheckItem{Card: *card, Item: item, Complete: true}})
+				// Subtasks are completed by task sync below
+				if a.Config.TaskMode == TaskModeSubtasks {
+					continue
+				}
+				for _, task := range findExistingTasks(snapshot.Tasks, item.Name, false) {
+					if !task.Completed {
+						steps = append(steps, Step{Rule: "chat", CardID: card.ID, Action: CompleteTask{Task: task}})
+					}
+				}
+			}
+		}
+		if len(found) == 0 {
+			reply = fmt.Sprintf("No open task matches '%v'", arg)
+			return nil, nil
+		}
+		if len(found) > 1 {
+			reply = fmt.Sprintf("'%v' matches %v tasks, which one?\n> %v", arg, len(found), strings.Join(found, "\n> "))
+			return nil, nil
+		}
+		reply = fmt.Sprintf("Done: %v", found[0])
+		// Let task sync promote the next backlog item straight away
+		return append(steps, Plan(a.Config, applySteps(snapshot, steps), "task-sync")...), nil
+	})
+	return reply, err
+}
+
+func (a *App) chatPlan(arg string) (string, error) {
+	var reply string
+	err := a.change(func(snapshot *Snapshot) ([]Step, error) {
+		card, problem := oneCard(snapshot.Backlog.Cards, arg)
+		if card == nil {
+			reply = problem
+			return nil, nil
+		}
+		label := snapshot.Backlog.LabelByName(a.Config.PlannedLabel)
+		if label == nil {
+			return nil, fmt.Errorf("The backlog board has no '%v' label", a.Config.PlannedLabel)
+		}
+		var steps []Step
+		if !card.HasLabel(label.Name) {
+			steps = append(steps, Step{Rule: "chat", CardID: card.ID, Action: AddLabel{Card: *card, Label: *label}})
+		}
+		reply = fmt.Sprintf("Planned *%v* (%v)", card.Name, card.ShortURL)
+		return append(steps, Plan(a.Config, applySteps(snapshot, steps), "goal-promotion")...), nil
+	})
+	return reply, err
+}
+
+func (a *App) chatBacklog(arg string) (string, error) {
+	snapshot, err := a.loadSnapshot()
+	if err != nil {
+		return "", err
+	}
+	card, problem := oneCard(append(snapshot.Goals.Cards, snapshot.Backlog.Cards...), arg)
+	if card == nil {
+		return problem, nil
+	}
+	checked, unchecked := card.Items(a.Config.BacklogChecklist)
+	if len(checked)+len(unchecked) == 0 {
+		return fmt.Sprintf("*%v* has nothing in %v", card.Name, a.Config.BacklogChecklist), nil
+	}
+	reply := fmt.Sprintf("%v for *%v* (%v):", a.Config.BacklogChecklist, card.Name, card.ShortURL)
+	for _, item := range unchecked {
+		reply = fmt.Sprintf("%v\n> %v", reply, item.Name)
+	}
+	for _, item := range checked {
+		reply = fmt.Sprintf("%v\n> ~%v~", reply, item.Name)
+	}
+	return reply, nil
+}
+
+func (a *App) chatSync(arg string) (string, error) {
+	if err := a.runJobs(); err != nil {
+		return "", err
+	}
+	return "Sync finished", nil
+}
+
+func (a *App) chatAdd(arg string) (string, error) {
+	lists, err := a.Boards.GetLists(a.Config.TrelloBacklog)
+	if err != nil {
+		return "", err
+	}
+	for _, list := range lists {
+		if list.Name == a.Config.IdeasList {
+			a.runs.Lock()
+			defer a.runs.Unlock()
+			a.Audit.StartRun()
+			card, err := a.createCard(list.ID, arg, "chat")
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Added *%v* to %v (%v)", card.Name, list.Name, card.ShortUrl), nil
+		}
+	}
+	return fmt.Sprintf("The backlog board has no '%v' list", a.Config.IdeasList), nil
+}
+
+// applySteps is the snapshot after the steps are carried out, for planning
+// on top of them
+func applySteps(snapshot *Snapshot, steps []Step) *Snapshot {
+	after := snapshot.Clone()
+	for _, step := range steps {
+		after.apply(step.Action)
+	}
+	return after
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRouteChatMessage(t *testing.T) {
+	for text, expected := range map[string]string{
+		"status":              "status|",
+		"Check in":            "status|",
+		"done Write a CLI":    "done|Write a CLI",
+		"  plan   Learn Go  ": "plan|Learn Go",
+		"commands":            "help|",
+		"planning a trip":     "|planning a trip",
+	} {
+		command, arg := routeChatMessage(text)
+		name := ""
+		if command != nil {
+			name = command.Name
+		}
+		if routed := name + "|" + arg; routed != expected {
+			t.Errorf("%q: expected %v, got %v", text, expected, routed)
+		}
+	}
+}
+
+// say sends a chat message and returns miriam's reply. Replies go to the
+// channel the message came from, notifications don't count.
+func say(t *testing.T, w *fakeWorld, chat *fakeChat, text string) string {
+	before := len(chat.SentTo["direct"])
+	w.App.handleChatMessage(ChatMessage{Channel: "direct", User: "matt", Text: text})
+	if len(chat.SentTo["direct"]) != before+1 {
+		t.Fatalf("expected one reply to %q, got %v", text, chat.SentTo["direct"][before:])
+	}
+	return chat.SentTo["direct"][before]
+}
+
+func TestChatCommands(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	chat := &fakeChat{}
+	w.App.Chat = chat
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+	w.Trello.AddChecklist(goal, "Backlog", "Write a CLI", "[x] Install Go")
+	w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+	marathon := w.Trello.AddCard(w.Someday, "Run a marathon")
+
+	if reply := say(t, w, chat, "next"); reply != fmt.Sprintf("*Learn Go* (%s)\n> Next task: Read the tour", goal.ShortUrl) {
+		t.Errorf("unexpected reply to next: %q", reply)
+	}
+	if reply := say(t, w, chat, "backlog learn go"); !strings.Contains(reply, "> Write a CLI\n> ~Install Go~") {
+		t.Errorf("unexpected reply to backlog: %q", reply)
+	}
+
+	if reply := say(t, w, chat, "done tour"); reply != "Done: Read the tour on *Learn Go*" {
+		t.Errorf("unexpected reply to done: %q", reply)
+	}
+	if tasks := w.Wunderlist.Find("Read the tour"); len(tasks) != 1 || !tasks[0].Completed {
+		t.Errorf("expected the task to be completed, got %v", tasks)
+	}
+	items := w.Trello.ItemsOf(goal, "Tasks")
+	if items["Read the tour"] != "complete" {
+		t.Errorf("expected the checklist item to be completed, got %v", items)
+	}
+	if _, ok := items["Write a CLI"]; !ok {
+		t.Errorf("expected the next backlog item to be promoted, got %v", items)
+	}
+
+	if reply := say(t, w, chat, "plan marathon"); reply != fmt.Sprintf("Planned *Run a marathon* (%s)", marathon.ShortUrl) {
+		t.Errorf("unexpected reply to plan: %q", reply)
+	}
+	if list := w.Trello.ListOf(marathon); list != "To Do" {
+		t.Errorf("expected the planned card in To Do, got %v", list)
+	}
+
+	if reply := say(t, w, chat, "add Learn to juggle"); !strings.HasPrefix(reply, "Added *Learn to juggle* to Ideas") {
+		t.Errorf("unexpected reply to add: %q", reply)
+	}
+	if reply := say(t, w, chat, "plan"); reply != "Usage: *plan* <card>" {
+		t.Errorf("unexpected reply without an argument: %q", reply)
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "backlog-promotion", "chat", "planned-move", "task-sync")
+}
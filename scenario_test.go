@@ -0,0 +1,830 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/pkg/errors"
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// fakeWorld is a backlog and goals board on a fake Trello and an inbox on a
+// fake Wunderlist, with miriam configured to use them
+type fakeWorld struct {
+	Trello     *fakeTrello
+	Wunderlist *fakeWunderlist
+	Backlog    *trello.Board
+	Ideas      *trello.List
+	Someday    *trello.List
+	Goals      *trello.Board
+	ToDo       *trello.List
+	InProgress *trello.List
+	Done       *trello.List
+	App        *App
+	Clock      *fakeClock
+	dir        string
+}
+
+func newFakeWorld(t *testing.T) *fakeWorld {
+	w := &fakeWorld{Trello: newFakeTrello(), Wunderlist: newFakeWunderlist(), Clock: newFakeClock(time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local))}
+	w.Trello.Clock, w.Wunderlist.Clock = w.Clock, w.Clock
+	w.Backlog = w.Trello.AddBoard("Backlog")
+	w.Ideas = w.Trello.AddList(w.Backlog, "Ideas")
+	w.Someday = w.Trello.AddList(w.Backlog, "Someday")
+	for _, label := range []string{"Planned", "Needs success criteria", "Needs tasks", "Stale"} {
+		w.Trello.AddLabel(w.Backlog, label)
+	}
+	w.Goals = w.Trello.AddBoard("Goals")
+	w.ToDo = w.Trello.AddList(w.Goals, "To Do")
+	w.InProgress = w.Trello.AddList(w.Goals, "In Progress")
+	w.Done = w.Trello.AddList(w.Goals, "Done")
+	w.Trello.AddLabel(w.Goals, "Stale")
+
+	var err error
+	if w.dir, err = ioutil.TempDir("", "miriam-world"); err != nil {
+		t.Fatal(err)
+	}
+	writeConfigFiles(t, w.dir, map[string]string{"miriam.yaml": fmt.Sprintf(`
+trello-backlog: %s
+trello-goals: %s
+trello-url: %s
+wunderlist-url: %s
+audit-log: %s
+burndown-file: %s
+trello-key: fake-trello-key
+trello-token: fake-trello-token
+wunderlist-access-token: fake-wunderlist-access-token
+wunderlist-client-id: fake-wunderlist-client-id
+`, w.Backlog.ID, w.Goals.ID, w.Trello.URL(), w.Wunderlist.URL(), filepath.Join(w.dir, "audit.jsonl"), filepath.Join(w.dir, "burndown.json"))})
+	c, err := LoadConfig(filepath.Join(w.dir, "miriam.yaml"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.App = NewApp(c)
+	w.App.SetClock(w.Clock)
+	return w
+}
+
+func (w *fakeWorld) Close() {
+	w.Trello.Close()
+	w.Wunderlist.Close()
+	os.RemoveAll(w.dir)
+}
+
+// Rules is the sorted, distinct rules in the audit log
+func (w *fakeWorld) Rules(t *testing.T) []string {
+	entries, err := ReadAuditLog(filepath.Join(w.dir, "audit.jsonl"))
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	var rules []string
+	for _, entry := range entries {
+		if !seen[entry.Rule] {
+			seen[entry.Rule] = true
+			rules = append(rules, entry.Rule)
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func expectStrings(t *testing.T, what string, actual []string, expected ...string) {
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if len(actual) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%s: expected %v, got %v", what, expected, actual)
+	}
+}
+
+func TestLabelHygieneScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	bare := w.Trello.AddCard(w.Someday, "Learn to juggle")
+	planned := w.Trello.AddCard(w.Someday, "Write a novel", "Needs success criteria", "Needs tasks")
+	w.Trello.AddChecklist(planned, "Success Criteria", "Finished draft")
+	w.Trello.AddChecklist(planned, "Tasks")
+	w.Trello.AddChecklist(planned, "Backlog", "Outline")
+	idea := w.Trello.AddCard(w.Ideas, "Build a boat")
+
+	if err := w.App.runJobs("label-hygiene"); err != nil {
+		t.Fatal(err)
+	}
+	for _, checklist := range []string{"Success Criteria", "Tasks", "Backlog"} {
+		if w.Trello.ItemsOf(bare, checklist) == nil {
+			t.Errorf("expected %s checklist to be created", checklist)
+		}
+	}
+	expectStrings(t, "labels on a bare card", w.Trello.LabelsOf(bare), "Needs success criteria", "Needs tasks")
+	expectStrings(t, "labels on a planned card", w.Trello.LabelsOf(planned))
+	if w.Trello.ItemsOf(idea, "Tasks") != nil || len(w.Trello.LabelsOf(idea)) > 0 {
+		t.Error("expected cards in excluded lists to be left alone")
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "checklist-setup", "label-hygiene")
+}
+
+func TestPlannedCardMovesToGoals(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	card := w.Trello.AddCard(w.Someday, "Run a marathon", "Planned")
+	w.Trello.AddCard(w.InProgress, "Current goal")
+
+	if err := w.App.runJobs("goal-promotion"); err != nil {
+		t.Fatal(err)
+	}
+	if board := w.Trello.BoardOf(card); board != w.Goals.ID {
+		t.Errorf("expected card on the goals board, got %v", board)
+	}
+	if list := w.Trello.ListOf(card); list != "To Do" {
+		t.Errorf("expected card in To Do, got %v", list)
+	}
+	expectStrings(t, "labels", w.Trello.LabelsOf(card))
+	expectStrings(t, "audited rules", w.Rules(t), "planned-move")
+}
+
+func TestGoalPromotionRespectsWIPLimit(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	first := w.Trello.AddCard(w.ToDo, "First goal")
+	second := w.Trello.AddCard(w.ToDo, "Second goal")
+
+	if err := w.App.runJobs("goal-promotion"); err != nil {
+		t.Fatal(err)
+	}
+	if list := w.Trello.ListOf(first); list != "In Progress" {
+		t.Errorf("expected the first card in In Progress, got %v", list)
+	}
+	if list := w.Trello.ListOf(second); list != "To Do" {
+		t.Errorf("expected the second card to stay in To Do, got %v", list)
+	}
+	if tasks := w.Wunderlist.Find("Start working on a new goal"); len(tasks) > 0 {
+		t.Errorf("expected no planning task, got %v", tasks)
+	}
+}
+
+func TestGoalPromotionAsksForANewGoal(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+
+	if err := w.App.runJobs("goal-promotion"); err != nil {
+		t.Fatal(err)
+	}
+	tasks := w.Wunderlist.Find("Start working on a new goal")
+	if len(tasks) != 1 || tasks[0].Title != fmt.Sprintf("Start working on a new goal (%s)", w.Goals.ShortUrl) {
+		t.Fatalf("expected a planning task, got %v", tasks)
+	}
+	if tasks[0].AssigneeID != w.Wunderlist.User.ID {
+		t.Errorf("expected the task to be assigned to %v, got %v", w.Wunderlist.User.ID, tasks[0].AssigneeID)
+	}
+}
+
+func TestTaskSyncScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Read the tour", "Write a CLI", "Write tests", "Ship it")
+	w.Trello.AddChecklist(goal, "Backlog", "Contribute upstream")
+	w.Trello.AddCard(w.ToDo, "Not started")
+
+	// Done in Trello, still open in Wunderlist
+	w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+	// Done in Wunderlist, still open in Trello
+	w.Wunderlist.AddTask(fmt.Sprintf("Write a CLI (%s)", goal.ShortUrl), true)
+	// Open in both
+	w.Wunderlist.AddTask(fmt.Sprintf("Write tests (%s)", goal.ShortUrl), false)
+	// Still in Backlog, should not have a task yet
+	w.Wunderlist.AddTask(fmt.Sprintf("Contribute upstream (%s)", goal.ShortUrl), false)
+
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := w.Wunderlist.Find("Read the tour"); len(tasks) != 1 || !tasks[0].Completed {
+		t.Errorf("expected the task to be completed, got %v", tasks)
+	}
+	items := w.Trello.ItemsOf(goal, "Tasks")
+	if items["Write a CLI"] != "complete" {
+		t.Errorf("expected the checklist item to be completed, got %v", items)
+	}
+	if items["Write tests"] != "incomplete" {
+		t.Errorf("expected the open item to stay open, got %v", items)
+	}
+	if tasks := w.Wunderlist.Find("Ship it"); len(tasks) != 1 || tasks[0].Title != fmt.Sprintf("Ship it (%s)", goal.ShortUrl) {
+		t.Errorf("expected a task for the new checklist item, got %v", tasks)
+	}
+	if tasks := w.Wunderlist.Find("Contribute upstream"); len(tasks) != 0 {
+		t.Errorf("expected the task for the backlog item to be deleted, got %v", tasks)
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "backlog-cleanup", "task-sync")
+}
+
+func TestSubtaskModeScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	w.App.Config.TaskMode = TaskModeSubtasks
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Read the tour", "Write a CLI", "Write tests")
+	w.Trello.AddChecklist(goal, "Backlog", "Ship it")
+
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	tasks := w.Wunderlist.Find(goal.ShortUrl)
+	if len(tasks) != 1 || tasks[0].Title != fmt.Sprintf("Learn Go (%s)", goal.ShortUrl) {
+		t.Fatalf("expected one task for the goal, got %v", tasks)
+	}
+	parent := tasks[0]
+	subtasks := w.Wunderlist.SubtasksOf(parent.ID)
+	var titles []string
+	for _, subtask := range subtasks {
+		titles = append(titles, subtask.Title)
+	}
+	expectStrings(t, "subtasks", titles, "Write a CLI", "Write tests")
+
+	// Finishing both subtasks checks the items and promotes the backlog
+	for _, subtask := range subtasks {
+		subtask.Completed = true
+		if _, err := w.App.Tasks.UpdateSubtask(subtask); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	items := w.Trello.ItemsOf(goal, "Tasks")
+	if items["Write a CLI"] != "complete" || items["Write tests"] != "complete" || items["Ship it"] != "incomplete" {
+		t.Errorf("expected the items to be completed and Ship it promoted, got %v", items)
+	}
+	if subtasks := w.Wunderlist.SubtasksOf(parent.ID); len(subtasks) != 3 || subtasks[2].Title != "Ship it" {
+		t.Errorf("expected a subtask for the promoted item, got %v", subtasks)
+	}
+
+	// Checking the item in chat completes its subtask
+	if _, err := w.App.chatDone("Ship it"); err != nil {
+		t.Fatal(err)
+	}
+	if subtasks := w.Wunderlist.SubtasksOf(parent.ID); len(subtasks) != 3 || !subtasks[2].Completed {
+		t.Errorf("expected the subtask to be completed, got %v", subtasks)
+	}
+	if tasks := w.Wunderlist.Find(goal.ShortUrl); len(tasks) != 1 || tasks[0].Completed {
+		t.Errorf("expected the goal's task to stay open, got %v", tasks)
+	}
+}
+
+func TestTaskNotesScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	goal.Desc = `Get "comfortable" with Go`
+	goal.Attachments = append(goal.Attachments, &trello.Attachment{ID: "upload", Name: "notes.pdf", URL: "https://trello.com/notes.pdf", IsUpload: true})
+	w.Trello.AddChecklist(goal, "Success Criteria", "[x] Built a CLI", "Wrote tests")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour", "Write tests")
+	if _, err := w.App.Boards.AddURLAttachment(goal.ID, "Tour", "https://go.dev/tour"); err != nil {
+		t.Fatal(err)
+	}
+	existing := w.Wunderlist.AddTask(fmt.Sprintf("Write tests (%s)", goal.ShortUrl), false)
+
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Get \"comfortable\" with Go\n\nSuccess Criteria:\n[x] Built a CLI\n[ ] Wrote tests\n\nLinks:\nTour: https://go.dev/tour"
+	tasks := w.Wunderlist.Find("Read the tour")
+	if len(tasks) != 1 {
+		t.Fatalf("expected a task for the item, got %v", tasks)
+	}
+	for _, task := range []wunderlist.Task{tasks[0], *existing} {
+		if note := w.Wunderlist.NoteOf(task.ID); note == nil || note.Content != expected {
+			t.Errorf("expected the note of '%v' to be %q, got %v", task.Title, expected, note)
+		}
+	}
+
+	// Changing the card updates the notes, once
+	if _, err := w.App.Boards.UpdateCard(goal.ID, trello.Arguments{"desc": "Get fluent in Go"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.App.runJobs("task-sync"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if note := w.Wunderlist.NoteOf(existing.ID); note == nil || !strings.HasPrefix(note.Content, "Get fluent in Go\n\n") || note.Revision != 2 {
+		t.Errorf("expected the note to be updated once, got %v", note)
+	}
+}
+
+func TestCommentSyncScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+	w.Trello.AddComment(goal, "Ada", "Before any tasks")
+	w.Clock.Advance(time.Hour)
+	task := w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+	w.Clock.Advance(time.Hour)
+	w.Wunderlist.AddTaskComment(task, "Halfway through")
+	w.Trello.AddComment(goal, "Ada", `Try "the exercises"`)
+
+	// Synced comments carry their origin and aren't synced back
+	for i := 0; i < 2; i++ {
+		if err := w.App.runJobs("comment-sync"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectStrings(t, "card comments", w.Trello.CommentsOf(goal), "Before any tasks", `Try "the exercises"`, "[from Wunderlist] Miriam on 'Read the tour': Halfway through")
+	expectStrings(t, "task comments", w.Wunderlist.TaskCommentsOf(task.ID), "Halfway through", `[from Trello] Ada: Try "the exercises"`)
+
+	entries, err := ReadAuditLog(filepath.Join(w.dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs := w.App.planUndo(selectUndoEntries(entries, "", entries[0].Time))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, op := range ops {
+		if err := op.Apply(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectStrings(t, "card comments after undo", w.Trello.CommentsOf(goal), "Before any tasks", `Try "the exercises"`)
+	expectStrings(t, "task comments after undo", w.Wunderlist.TaskCommentsOf(task.ID), "Halfway through")
+}
+
+func TestCommentSyncWithManyComments(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+	task := w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+	w.Clock.Advance(time.Hour)
+	w.Wunderlist.AddTaskComment(task, "Halfway through")
+	if err := w.App.runJobs("comment-sync"); err != nil {
+		t.Fatal(err)
+	}
+
+	// More comments than Trello returns by default bury the copy
+	for i := 0; i < 60; i++ {
+		w.Trello.AddComment(goal, "Ada", fmt.Sprintf("Note %d", i))
+	}
+	if err := w.App.runJobs("comment-sync"); err != nil {
+		t.Fatal(err)
+	}
+	copied := 0
+	for _, comment := range w.Trello.CommentsOf(goal) {
+		if strings.HasPrefix(comment, fromWunderlist) {
+			copied++
+		}
+	}
+	if copied != 1 {
+		t.Errorf("expected the task comment to be copied once, got %v copies", copied)
+	}
+	if comments := w.Wunderlist.TaskCommentsOf(task.ID); len(comments) != 61 {
+		t.Errorf("expected every card comment on the task, got %v comments", len(comments))
+	}
+
+	// The audit log remembers the copy, even once Trello doesn't return it
+	for _, action := range w.Trello.Actions {
+		if action.Type == "commentCard" && strings.HasPrefix(action.Data.Text, fromWunderlist) {
+			if err := w.App.Boards.DeleteComment(action.ID); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if err := w.App.runJobs("comment-sync"); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range w.Trello.CommentsOf(goal) {
+		if strings.HasPrefix(comment, fromWunderlist) {
+			t.Errorf("expected the task comment not to be copied again, got %v", comment)
+		}
+	}
+}
+
+func TestDueDateScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	due := time.Date(2026, 11, 1, 17, 0, 0, 0, time.UTC)
+	goal.Due = &due
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour", "Write a CLI @2026-10-25")
+
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	tasks := w.Wunderlist.Find("Read the tour")
+	if len(tasks) != 1 || tasks[0].DueDate.Format("2006-01-02") != "2026-11-01" {
+		t.Errorf("expected a task due on the card's date, got %v", tasks)
+	}
+	tasks = w.Wunderlist.Find("Write a CLI")
+	if len(tasks) != 1 || tasks[0].Title != fmt.Sprintf("Write a CLI (%s)", goal.ShortUrl) || tasks[0].DueDate.Format("2006-01-02") != "2026-10-25" {
+		t.Errorf("expected a task due on the hinted date without the hint, got %v", tasks)
+	}
+
+	// Moving the card's due date moves the open task without a hint
+	if _, err := w.App.Boards.UpdateCard(goal.ID, trello.Arguments{"due": "2026-11-08T17:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := w.Wunderlist.Find("Read the tour"); len(tasks) != 1 || tasks[0].DueDate.Format("2006-01-02") != "2026-11-08" {
+		t.Errorf("expected the task to follow the card's due date, got %v", tasks)
+	}
+	if tasks := w.Wunderlist.Find("Write a CLI"); len(tasks) != 1 || tasks[0].DueDate.Format("2006-01-02") != "2026-10-25" {
+		t.Errorf("expected the hinted task to keep its date, got %v", tasks)
+	}
+
+	// Clearing the card's due date clears it on the task without a hint
+	if _, err := w.App.Boards.UpdateCard(goal.ID, trello.Arguments{"due": "null"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := w.Wunderlist.Find("Read the tour"); len(tasks) != 1 || !tasks[0].DueDate.IsZero() {
+		t.Errorf("expected the task's due date to be cleared, got %v", tasks)
+	}
+	if tasks := w.Wunderlist.Find("Write a CLI"); len(tasks) != 1 || tasks[0].DueDate.Format("2006-01-02") != "2026-10-25" {
+		t.Errorf("expected the hinted task to keep its date, got %v", tasks)
+	}
+}
+
+func TestRemindersScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	w.App.Config.Reminders = []string{"due=1d@09:00", "open=3d@18:00"}
+	dentist := w.Wunderlist.AddTask("Call the dentist", false)
+	dentist.DueDate = time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)
+	milk := w.Wunderlist.AddTask("Buy milk", false)
+	reminded := w.Wunderlist.AddTask("Water the plants", false)
+	done := w.Wunderlist.AddTask("File taxes", true)
+	w.Wunderlist.Reminders = []*wunderlist.Reminder{
+		{ID: 1, TaskID: reminded.ID, Date: "2026-03-02T20:00:00.000Z", Revision: 1},
+		{ID: 2, TaskID: done.ID, Date: "2026-03-01T20:00:00.000Z", Revision: 1},
+	}
+
+	if err := w.App.runJobs("reminders"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[*wunderlist.Task]string{
+		// The day before it's due beats three days open
+		dentist:  time.Date(2026, 3, 3, 9, 0, 0, 0, time.Local).UTC().Format(reminderLayout),
+		milk:     time.Date(2026, 3, 5, 18, 0, 0, 0, time.Local).UTC().Format(reminderLayout),
+		reminded: "2026-03-02T20:00:00.000Z",
+	}
+	for task, date := range expected {
+		if reminders := w.Wunderlist.RemindersOf(task.ID); len(reminders) != 1 || reminders[0].Date != date {
+			t.Errorf("expected a reminder on '%v' at %v, got %v", task.Title, date, reminders)
+		}
+	}
+	if reminders := w.Wunderlist.RemindersOf(done.ID); len(reminders) != 0 {
+		t.Errorf("expected the completed task's reminder to be removed, got %v", reminders)
+	}
+
+	// Finishing a task removes its reminder, and nothing else changes
+	if _, err := w.App.Tasks.UpdateTask(wunderlist.Task{ID: milk.ID, Title: milk.Title, Revision: milk.Revision, Completed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.App.runJobs("reminders"); err != nil {
+		t.Fatal(err)
+	}
+	if reminders := w.Wunderlist.RemindersOf(milk.ID); len(reminders) != 0 {
+		t.Errorf("expected the reminder to be removed, got %v", reminders)
+	}
+	if len(w.Wunderlist.Reminders) != 2 {
+		t.Errorf("expected two reminders left, got %v", w.Wunderlist.Reminders)
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "reminders")
+}
+
+func TestBacklogPromotionScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Success Criteria", "Ship something")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Read the tour")
+	w.Trello.AddChecklist(goal, "Backlog", "Write a CLI", "Write tests")
+	w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), true)
+
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	tasks := w.Trello.ItemsOf(goal, "Tasks")
+	backlog := w.Trello.ItemsOf(goal, "Backlog")
+	if _, ok := tasks["Write a CLI"]; !ok {
+		t.Errorf("expected the first backlog item to move to Tasks, got %v", tasks)
+	}
+	if _, ok := backlog["Write tests"]; !ok || len(backlog) != 1 {
+		t.Errorf("expected only the second item to stay in Backlog, got %v", backlog)
+	}
+	if found := w.Wunderlist.Find("Write a CLI"); len(found) != 1 {
+		t.Errorf("expected a task for the promoted item, got %v", found)
+	}
+	if found := w.Wunderlist.Find("Write tests"); len(found) != 0 {
+		t.Errorf("expected no task for the item still in Backlog, got %v", found)
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "backlog-promotion", "task-sync")
+}
+
+func TestRunFailuresAreReported(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Read the tour")
+	w.Trello.AddChecklist(goal, "Backlog")
+	w.Trello.AddChecklist(goal, "Success Criteria")
+	task := w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), false)
+	w.Wunderlist.Fail[fmt.Sprintf("PATCH /tasks/%d", task.ID)] = http.StatusInternalServerError
+
+	if err := w.App.runJobs("task-sync"); err == nil {
+		t.Error("expected the failed update to fail the run")
+	}
+}
+
+func TestFakeClockDrivesTheRun(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	w.Trello.AddChecklist(goal, "Tasks", "Read the tour")
+	w.Trello.AddChecklist(goal, "Backlog")
+
+	// The card sits in In Progress for 15 days
+	w.Clock.Advance(15 * 24 * time.Hour)
+	snapshot, err := w.App.loadSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.Now.Equal(w.Clock.Now()) {
+		t.Errorf("expected the snapshot to be taken at %v, got %v", w.Clock.Now(), snapshot.Now)
+	}
+	if idle := snapshot.Goals.Cards[0].Idle(snapshot.Now); idle != 15*24*time.Hour {
+		t.Errorf("expected the card to be idle for 15 days, got %v", idle)
+	}
+
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	tasks := w.Wunderlist.Find("Read the tour")
+	// The card has no due date, so neither does the task
+	if len(tasks) != 1 || !tasks[0].DueDate.IsZero() {
+		t.Errorf("expected a task without a due date, got %v", tasks)
+	}
+	entries, err := ReadAuditLog(filepath.Join(w.dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.Time.Equal(w.Clock.Now()) {
+			t.Errorf("expected audit entries stamped %v, got %v", w.Clock.Now(), entry.Time)
+		}
+	}
+}
+
+func TestGoalCompletionScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	due := w.Clock.Now().AddDate(0, 0, 7)
+	goal.Due = &due
+	w.Trello.AddChecklist(goal, "Success Criteria", "[x] Ship a CLI")
+	w.Trello.AddChecklist(goal, "Tasks", "[x] Read the tour", "[x] Write a CLI")
+	w.Trello.AddChecklist(goal, "Backlog")
+	w.Wunderlist.AddTask(fmt.Sprintf("Read the tour (%s)", goal.ShortUrl), true)
+	w.Wunderlist.AddTask(fmt.Sprintf("Write a CLI (%s)", goal.ShortUrl), false)
+	next := w.Trello.AddCard(w.ToDo, "Run a marathon")
+	w.Trello.AddChecklist(next, "Tasks", "Buy shoes")
+
+	if err := w.App.runJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if list := w.Trello.ListOf(goal); list != "Done" {
+		t.Errorf("expected the finished goal in Done, got %v", list)
+	}
+	if goal.Due == nil || !goal.Due.Equal(due) || !goal.DueComplete {
+		t.Errorf("expected the goal's due date %v to be kept and completed, got %v (complete %v)", due, goal.Due, goal.DueComplete)
+	}
+	expectStrings(t, "goal comments", w.Trello.CommentsOf(goal), "Completed on "+w.Clock.Now().Format("2006-01-02"))
+	if tasks := w.Wunderlist.Find("Write a CLI"); len(tasks) != 1 || !tasks[0].Completed {
+		t.Errorf("expected the goal's open task to be completed, got %v", tasks)
+	}
+	if list := w.Trello.ListOf(next); list != "In Progress" {
+		t.Errorf("expected the next goal to be pulled into In Progress, got %v", list)
+	}
+	if tasks := w.Wunderlist.Find("Buy shoes"); len(tasks) != 1 {
+		t.Errorf("expected the next goal's tasks to be synced in the same run, got %v", tasks)
+	}
+	expectStrings(t, "audited rules", w.Rules(t), "checklist-setup", "goal-completion", "goal-promotion", "task-sync")
+
+	entries, err := ReadAuditLog(filepath.Join(w.dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs := w.App.planUndo(selectUndoEntries(entries, "", entries[0].Time))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, op := range ops {
+		if err := op.Apply(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if list := w.Trello.ListOf(goal); list != "In Progress" || goal.Due == nil || !goal.Due.Equal(due) || goal.DueComplete {
+		t.Errorf("expected undo to reopen the goal, got %v due %v (complete %v)", list, goal.Due, goal.DueComplete)
+	}
+	if comments := w.Trello.CommentsOf(goal); len(comments) != 0 {
+		t.Errorf("expected undo to remove the completion comment, got %v", comments)
+	}
+}
+
+func TestGoalListsScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	w.App.Config.GoalLists = true
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	success := w.Trello.AddChecklist(goal, "Success Criteria", "Ship a CLI")
+	tasks := w.Trello.AddChecklist(goal, "Tasks", "Write a CLI")
+	w.Trello.AddChecklist(goal, "Backlog")
+	next := w.Trello.AddCard(w.ToDo, "Run a marathon")
+	w.Trello.AddChecklist(next, "Tasks", "Buy shoes")
+
+	for i := 0; i < 2; i++ {
+		if err := w.App.runJobs("task-sync"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := w.Wunderlist.ListNamed("Learn Go")
+	if list == nil || w.Wunderlist.FolderOf(list.ID) != "Goals" {
+		t.Fatalf("expected a list for the goal in Goals, got %v", list)
+	}
+	if found := w.Wunderlist.Find("Write a CLI"); len(found) != 1 || found[0].ListID != list.ID {
+		t.Errorf("expected the goal's task in its list, got %v", found)
+	}
+
+	// Finishing the goal archives its list and starts the next goal's
+	for _, item := range []trello.CheckItem{success.CheckItems[0], tasks.CheckItems[0]} {
+		if _, err := w.App.Boards.UpdateCheckItem(goal.ID, item.ID, trello.Arguments{"state": "complete"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.App.runJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if folder := w.Wunderlist.FolderOf(list.ID); folder != "Goals Archive" {
+		t.Errorf("expected the finished goal's list in Goals Archive, got %q", folder)
+	}
+	if found := w.Wunderlist.Find("Write a CLI"); len(found) != 1 || !found[0].Completed {
+		t.Errorf("expected the archived list to keep its completed task, got %v", found)
+	}
+	nextList := w.Wunderlist.ListNamed("Run a marathon")
+	if nextList == nil || w.Wunderlist.FolderOf(nextList.ID) != "Goals" {
+		t.Fatalf("expected a list for the next goal in Goals, got %v", nextList)
+	}
+	if found := w.Wunderlist.Find("Buy shoes"); len(found) != 1 || found[0].ListID != nextList.ID {
+		t.Errorf("expected the next goal's task in its list, got %v", found)
+	}
+
+	// Without an archive, the list of a goal moved out of In Progress is
+	// deleted with its tasks
+	w.App.Config.GoalListArchive = ""
+	if _, err := w.App.Boards.UpdateCard(next.ID, trello.Arguments{"idList": w.ToDo.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.App.runJobs("task-sync"); err != nil {
+		t.Fatal(err)
+	}
+	if list := w.Wunderlist.ListNamed("Run a marathon"); list != nil {
+		t.Errorf("expected the list to be deleted, got %v", list)
+	}
+	if found := w.Wunderlist.Find("Buy shoes"); len(found) != 0 {
+		t.Errorf("expected the list's tasks to be deleted, got %v", found)
+	}
+
+	// Undo recreates the list in its folder and its tasks in the list
+	entries, err := ReadAuditLog(filepath.Join(w.dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deletedTasks int
+	run := selectUndoEntries(entries, entries[len(entries)-1].RunID, time.Time{})
+	for _, entry := range run {
+		if entry.Op == OpDeleteTask {
+			deletedTasks++
+		}
+	}
+	if deletedTasks == 0 {
+		t.Error("expected the list's tasks to be deleted one by one")
+	}
+	ops, errs := w.App.planUndo(run)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, op := range ops {
+		if err := op.Apply(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restored := w.Wunderlist.ListNamed("Run a marathon")
+	if restored == nil {
+		t.Fatal("expected undo to recreate the list")
+	}
+	if folder := w.Wunderlist.FolderOf(restored.ID); folder != "Goals" {
+		t.Errorf("expected the recreated list in Goals, got %q", folder)
+	}
+	if found := w.Wunderlist.Find("Buy shoes"); len(found) != 1 || found[0].ListID != restored.ID {
+		t.Errorf("expected the task recreated in the list, got %v", found)
+	}
+}
+
+func TestStaleTasksWithoutTheLabelFlag(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	chat := &fakeChat{}
+	w.App.Chat = chat
+	w.App.Config.StaleFlags = []string{StaleFlagTask}
+	w.Wunderlist.AddTask("Call the dentist", false)
+
+	w.Clock.Advance(31 * 24 * time.Hour)
+	if err := w.App.runJobs("stale-detection"); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := w.Wunderlist.Find("Call the dentist"); len(tasks) != 1 || !tasks[0].Starred {
+		t.Errorf("expected the old task to be starred, got %v", tasks)
+	}
+	if len(chat.Sent) != 1 || !strings.Contains(chat.Sent[0], "Task *Call the dentist* has been open since 2 Mar") {
+		t.Errorf("expected a stale nudge in chat, got %v", chat.Sent)
+	}
+}
+
+func TestStaleDetectionScenario(t *testing.T) {
+	w := newFakeWorld(t)
+	defer w.Close()
+	chat := &fakeChat{}
+	w.App.Chat = chat
+	w.App.Config.StaleFlags = []string{StaleFlagLabel, StaleFlagTask}
+	goal := w.Trello.AddCard(w.InProgress, "Learn Go")
+	idea := w.Trello.AddCard(w.Someday, "Learn to juggle")
+	w.Trello.AddChecklist(idea, "Success Criteria", "Three balls for a minute")
+	w.Trello.AddChecklist(idea, "Tasks", "Buy juggling balls")
+	w.Trello.AddChecklist(idea, "Backlog")
+	w.Wunderlist.AddTask("Call the dentist", false)
+	fresh := w.Trello.AddCard(w.Someday, "Learn to knit")
+
+	// Nothing is stale yet
+	w.Clock.Advance(10 * 24 * time.Hour)
+	if err := w.App.runJobs("stale-detection"); err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "audited rules", w.Rules(t))
+
+	w.Clock.Advance(90 * 24 * time.Hour)
+	w.Trello.mu.Lock()
+	w.Trello.touch(fresh)
+	w.Trello.mu.Unlock()
+	if err := w.App.runJobs("stale-detection"); err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "goal labels", w.Trello.LabelsOf(goal), "Stale")
+	expectStrings(t, "backlog card labels", w.Trello.LabelsOf(idea), "Stale")
+	expectStrings(t, "recently changed card labels", w.Trello.LabelsOf(fresh))
+	if tasks := w.Wunderlist.Find("Call the dentist"); len(tasks) != 1 || !tasks[0].Starred {
+		t.Errorf("expected the old task to be starred, got %v", tasks)
+	}
+	for _, card := range []*trello.Card{goal, idea} {
+		if tasks := w.Wunderlist.Find(fmt.Sprintf("Review stale card '%v' (%v)", card.Name, card.ShortUrl)); len(tasks) != 1 {
+			t.Errorf("expected a review task for %v, got %v", card.Name, tasks)
+		}
+	}
+	if len(chat.Sent) != 1 || !strings.Contains(chat.Sent[0], "*Learn Go* has gone stale") || !strings.Contains(chat.Sent[0], "Task *Call the dentist* has been open since 2 Mar") {
+		t.Errorf("expected a stale nudge in chat, got %v", chat.Sent)
+	}
+
+	// Flagged once, and the label comes off once the goal moves
+	if err := w.App.runJobs("stale-detection"); err != nil {
+		t.Fatal(err)
+	}
+	if len(chat.Sent) != 1 {
+		t.Errorf("expected no more nudges, got %v", chat.Sent[1:])
+	}
+	for _, list := range []*trello.List{w.ToDo, w.InProgress} {
+		if _, err := w.App.Boards.UpdateCard(goal.ID, trello.Arguments{"idList": list.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.App.runJobs("stale-detection"); err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "moved goal labels", w.Trello.LabelsOf(goal))
+	expectStrings(t, "backlog card labels", w.Trello.LabelsOf(idea), "Stale")
+}
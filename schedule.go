@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a job is next due
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// everySchedule runs at a fixed interval, the old `interval` behavior
+type everySchedule struct {
+	Interval time.Duration
+}
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(s.Interval)
+}
+
+// cronSchedule is a standard five field cron expression:
+// minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// When both day fields are restricted a day matches either of them
+	domStar, dowStar bool
+	location         *time.Location
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseSchedule parses a cron expression, one of the @daily style shortcuts
+// or `@every <duration>`. Cron times are in the given location.
+func ParseSchedule(spec string, location *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %v", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("invalid schedule '%s': must be at least 1s", spec)
+		}
+		return everySchedule{d}, nil
+	}
+	if shortcut, ok := cronShortcuts[spec]; ok {
+		spec = shortcut
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule '%s': expected 5 fields", spec)
+	}
+	s := &cronSchedule{location: location}
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	targets := []*map[int]bool{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		if *targets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %v", spec, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return s, nil
+}
+
+func parseCronValue(value string) (int, error) {
+	if n, ok := cronNames[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid range '%s'", part)
+			}
+			if high, err = parseCronValue(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid range '%s'", part)
+			}
+		default:
+			value, err := parseCronValue(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			low, high = value, value
+			if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("'%s' is outside %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next finds the first matching minute after the given time, skipping whole
+// months, days and hours that can't match
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// timeWindow is a daily span like 22:00-07:00, which may wrap past midnight
+type timeWindow struct {
+	start, end int // minutes since midnight
+}
+
+func parseTimeWindow(value string) (*timeWindow, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	bounds := strings.SplitN(value, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid window '%s': expected HH:MM-HH:MM", value)
+	}
+	var minutes [2]int
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return nil, fmt.Errorf("invalid window '%s': expected HH:MM-HH:MM", value)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	return &timeWindow{minutes[0], minutes[1]}, nil
+}
+
+func (w *timeWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// scheduledJob pairs a job with its schedule and next due time
+type scheduledJob struct {
+	Name     string
+	Spec     string
+	Schedule Schedule
+	Next     time.Time
+}
+
+// Scheduler tracks when each job is next due and whether jobs may run at
+// all, given quiet hours and the weekday and weekend windows
+type Scheduler struct {
+	Jobs         []*scheduledJob
+	Location     *time.Location
+	QuietHours   *timeWindow
+	WeekdayHours *timeWindow
+	WeekendHours *timeWindow
+}
+
+// NewScheduler builds the schedules from the configuration. Jobs without
+// their own schedule run every `interval`, digests without one never run.
+func NewScheduler(c *Config, now time.Time) (*Scheduler, error) {
+	location, err := c.Location()
+	if err != nil {
+		return nil, err
+	}
+	s := &Scheduler{Location: location}
+	if s.QuietHours, err = parseTimeWindow(c.QuietHours); err != nil {
+		return nil, err
+	}
+	if s.WeekdayHours, err = parseTimeWindow(c.WeekdayHours); err != nil {
+		return nil, err
+	}
+	if s.WeekendHours, err = parseTimeWindow(c.WeekendHours); err != nil {
+		return nil, err
+	}
+	for _, name := range jobNames() {
+		var schedule Schedule = everySchedule{c.Interval}
+		spec := c.JobSchedule(name)
+		if spec != "" {
+			if schedule, err = ParseSchedule(spec, location); err != nil {
+				return nil, fmt.Errorf("schedule for %s: %v", name, err)
+			}
+		} else {
+			spec = "every " + c.Interval.String()
+		}
+		s.Jobs = append(s.Jobs, &scheduledJob{Name: name, Spec: spec, Schedule: schedule, Next: schedule.Next(now)})
+	}
+	for _, d := range digests {
+		spec := c.JobSchedule(d.Name)
+		if spec == "" {
+			continue
+		}
+		schedule, err := ParseSchedule(spec, location)
+		if err != nil {
+			return nil, fmt.Errorf("schedule for %s: %v", d.Name, err)
+		}
+		s.Jobs = append(s.Jobs, &scheduledJob{Name: d.Name, Spec: spec, Schedule: schedule, Next: schedule.Next(now)})
+	}
+	return s, nil
+}
+
+// CarryOver keeps the next due time of every job whose schedule hasn't
+// changed since the previous scheduler (nor the timezone), so a reload doesn't push them back
+func (s *Scheduler) CarryOver(previous *Scheduler) {
+	if previous == nil || previous.Location.String() != s.Location.String() {
+		return
+	}
+	for _, j := range s.Jobs {
+		for _, p := range previous.Jobs {
+			if p.Name == j.Name && p.Spec == j.Spec {
+				j.Next = p.Next
+			}
+		}
+	}
+}
+
+// Allowed reports whether jobs may run at this time
+func (s *Scheduler) Allowed(t time.Time) bool {
+	t = t.In(s.Location)
+	if s.QuietHours != nil && s.QuietHours.Contains(t) {
+		return false
+	}
+	window := s.WeekdayHours
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		window = s.WeekendHours
+	}
+	return window == nil || window.Contains(t)
+}
+
+// NextDue is the earliest time any job is due
+func (s *Scheduler) NextDue() time.Time {
+	var next time.Time
+	for _, j := range s.Jobs {
+		if !j.Next.IsZero() && (next.IsZero() || j.Next.Before(next)) {
+			next = j.Next
+		}
+	}
+	return next
+}
+
+// Due returns the jobs due at the given time, in run order, and schedules
+// their next run. Jobs that fall due outside the allowed hours are skipped,
+// not queued.
+func (s *Scheduler) Due(now time.Time) []string {
+	var due []string
+	for _, j := range s.Jobs {
+		if j.Next.IsZero() || j.Next.After(now) {
+			continue
+		}
+		j.Next = j.Schedule.Next(now)
+		if s.Allowed(now) {
+			due = append(due, j.Name)
+		}
+	}
+	return due
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database is not available")
+	}
+	// A Monday
+	start := time.Date(2026, 10, 19, 8, 7, 30, 0, newYork)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 10, 19, 8, 15, 0, 0, newYork)},
+		{"0 9 * * *", time.Date(2026, 10, 19, 9, 0, 0, 0, newYork)},
+		{"30 7 * * *", time.Date(2026, 10, 20, 7, 30, 0, 0, newYork)},
+		{"0 9 * * sat,sun", time.Date(2026, 10, 24, 9, 0, 0, 0, newYork)},
+		{"0 8-17/4 * * mon-fri", time.Date(2026, 10, 19, 12, 0, 0, 0, newYork)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, newYork)},
+		{"@daily", time.Date(2026, 10, 20, 0, 0, 0, 0, newYork)},
+		// Either day field matches when both are restricted
+		{"0 0 1 * fri", time.Date(2026, 10, 23, 0, 0, 0, 0, newYork)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.spec, newYork)
+		if err != nil {
+			t.Errorf("%s: %v", test.spec, err)
+			continue
+		}
+		if next := schedule.Next(start); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.spec, test.expected, next)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "0 9 * * funday", "@every soon", "@every 10ms"} {
+		if _, err := ParseSchedule(spec, time.UTC); err == nil {
+			t.Errorf("expected an error for '%s'", spec)
+		}
+	}
+	schedule, err := ParseSchedule("@every 5m", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	if next := schedule.Next(start); !next.Equal(start.Add(5 * time.Minute)) {
+		t.Errorf("unexpected next time %v", next)
+	}
+}
+
+func TestSchedulerWindows(t *testing.T) {
+	c := &Config{
+		Interval:         10 * time.Minute,
+		Timezone:         "UTC",
+		QuietHours:       "22:00-07:00",
+		WeekendHours:     "10:00-14:00",
+		ScheduleTaskSync: "*/5 * * * *",
+	}
+	// A Monday
+	start := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+	scheduler, err := NewScheduler(c, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduler.Allowed(start) {
+		t.Error("expected 06:00 to be in quiet hours")
+	}
+	if !scheduler.Allowed(start.Add(2 * time.Hour)) {
+		t.Error("expected 08:00 on a weekday to be allowed")
+	}
+	// Saturday
+	if scheduler.Allowed(time.Date(2026, 10, 24, 9, 0, 0, 0, time.UTC)) || !scheduler.Allowed(time.Date(2026, 10, 24, 11, 0, 0, 0, time.UTC)) {
+		t.Error("expected weekend hours to apply on Saturday")
+	}
+
+	if next := scheduler.NextDue(); !next.Equal(start.Add(5 * time.Minute)) {
+		t.Errorf("expected task-sync to be due first, got %v", next)
+	}
+	// Due during quiet hours, so skipped and rescheduled
+	if due := scheduler.Due(start.Add(5 * time.Minute)); len(due) != 0 {
+		t.Errorf("expected nothing to run in quiet hours, got %v", due)
+	}
+	at := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	if due := scheduler.Due(at); !reflect.DeepEqual(due, jobNames()) {
+		t.Errorf("expected every job in run order, got %v", due)
+	}
+	if due := scheduler.Due(at.Add(5 * time.Minute)); !reflect.DeepEqual(due, []string{"task-sync"}) {
+		t.Errorf("expected only task-sync, got %v", due)
+	}
+}
+
+func TestSchedulerConfigValidation(t *testing.T) {
+	c := &Config{Interval: time.Minute, WIPLimit: 1, TasksChecklist: "Tasks", Timezone: "Mars/Olympus_Mons"}
+	if problems := c.validate(); len(problems) != 1 {
+		t.Errorf("expected an invalid timezone, got %v", problems)
+	}
+	c.Timezone = ""
+	c.ScheduleGoalPromotion = "every morning"
+	c.QuietHours = "late"
+	if problems := c.validate(); len(problems) != 1 {
+		t.Errorf("expected the first scheduling problem, got %v", problems)
+	}
+}
+
+func TestSchedulerDigests(t *testing.T) {
+	c := &Config{Interval: time.Hour, Timezone: "UTC", ScheduleWeeklyDigest: "0 8 * * 1"}
+	// A Sunday
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	scheduler, err := NewScheduler(c, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, j := range scheduler.Jobs {
+		names = append(names, j.Name)
+	}
+	if expected := append(jobNames(), "weekly-digest"); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected only the weekly digest to be scheduled, got %v", names)
+	}
+	if due := scheduler.Due(time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)); !reflect.DeepEqual(due, append(jobNames(), "weekly-digest")) {
+		t.Errorf("expected the weekly digest on Monday morning, got %v", due)
+	}
+}
+
+func TestSchedulerCarryOver(t *testing.T) {
+	c := &Config{Interval: time.Hour, Timezone: "UTC", ScheduleWeeklyDigest: "0 8 * * 1"}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	previous, err := NewScheduler(c, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloading later keeps the schedules that didn't change
+	c.ScheduleWeeklyDigest = "0 9 * * 1"
+	reload := start.Add(50 * time.Minute)
+	scheduler, err := NewScheduler(c, reload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.CarryOver(previous)
+	for _, j := range scheduler.Jobs {
+		expected := start.Add(time.Hour)
+		if j.Name == "weekly-digest" {
+			expected = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+		}
+		if !j.Next.Equal(expected) {
+			t.Errorf("expected %s next at %v, got %v", j.Name, expected, j.Next)
+		}
+	}
+}
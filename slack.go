@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slackChat is a ChatAdapter on Slack. Messages are sent with the Web API
+// and arrive as Events API callbacks, which the daemon serves at
+// chatEventsPath on listen-address.
+type slackChat struct {
+	url           string
+	token         string
+	signingSecret string
+	channel       string
+	client        *http.Client
+	clock         Clock
+
+	mu     sync.Mutex
+	handle func(ChatMessage)
+	// Channel names by ID and usernames by user ID
+	channels map[string]string
+	users    map[string]string
+}
+
+// chatEventsPath is where chat adapters that are called back by their
+// service receive events
+const chatEventsPath = "/chat/events"
+
+func newSlackChat(c *Config) *slackChat {
+	return &slackChat{
+		url:           strings.TrimSuffix(c.SlackURL, "/"),
+		token:         c.SlackToken,
+		signingSecret: c.SlackSigningSecret,
+		channel:       strings.TrimPrefix(c.ChatChannel, "#"),
+		client:        &http.Client{Timeout: 30 * time.Second},
+		clock:         systemClock{},
+		channels:      map[string]string{},
+		users:         map[string]string{},
+	}
+}
+
+// call makes a Web API request. Slack answers 200 with ok set to false when
+// a request fails.
+func (s *slackChat) call(method string, args url.Values, body interface{}, result interface{}) error {
+	var req *http.Request
+	var err error
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest("POST", s.url+"/"+method, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	} else if req, err = http.NewRequest("GET", s.url+"/"+method+"?"+args.Encode(), nil); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "Error calling Slack %s", method)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading Slack %s", method)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return errors.Wrapf(err, "Slack %s answered %s", method, resp.Status)
+	}
+	if !status.OK {
+		return fmt.Errorf("Slack %s failed: %s", method, status.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+func (s *slackChat) Send(channel string, text string) error {
+	if channel == "" {
+		channel = s.channel
+	}
+	return s.call("chat.postMessage", nil, map[string]string{"channel": channel, "text": text}, nil)
+}
+
+// Subscribe only stores the handler, the events arrive at ServeHTTP
+func (s *slackChat) Subscribe(handle func(ChatMessage)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+	return nil
+}
+
+func (s *slackChat) LookupUser(userID string) (string, error) {
+	s.mu.Lock()
+	name, ok := s.users[userID]
+	s.mu.Unlock()
+	if ok {
+		return name, nil
+	}
+	var info struct {
+		User struct {
+			Name string `json:"name"`
+		} `json:"user"`
+	}
+	if err := s.call("users.info", url.Values{"user": {userID}}, nil, &info); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.users[userID] = info.User.Name
+	s.mu.Unlock()
+	return info.User.Name, nil
+}
+
+// channelName is the name of a channel ID, events only carry the ID
+func (s *slackChat) channelName(channelID string) (string, error) {
+	s.mu.Lock()
+	name, ok := s.channels[channelID]
+	s.mu.Unlock()
+	if ok {
+		return name, nil
+	}
+	var info struct {
+		Channel struct {
+			Name string `json:"name"`
+		} `json:"channel"`
+	}
+	if err := s.call("conversations.info", url.Values{"channel": {channelID}}, nil, &info); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.channels[channelID] = info.Channel.Name
+	s.mu.Unlock()
+	return info.Channel.Name, nil
+}
+
+// verify checks Slack's signature on a request, and that it is recent so it
+// can't be replayed
+func (s *slackChat) verify(r *http.Request, body []byte) bool {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := s.clock.Now().Sub(time.Unix(seconds, 0)); age > 5*time.Minute || age < -5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.signingSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// slackEvent is the part of an Events API request miriam reads
+type slackEvent struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event     struct {
+		Type    string `json:"type"`
+		Subtype string `json:"subtype"`
+		BotID   string `json:"bot_id"`
+		Channel string `json:"channel"`
+		User    string `json:"user"`
+		Text    string `json:"text"`
+	} `json:"event"`
+}
+
+// ServeHTTP receives Events API requests. Slack retries anything that isn't
+// answered within 3 seconds, so messages are handled after answering.
+func (s *slackChat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || !s.verify(r, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var event slackEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event.Type == "url_verification" {
+		fmt.Fprint(w, event.Challenge)
+		return
+	}
+	s.mu.Lock()
+	handle := s.handle
+	s.mu.Unlock()
+	// Edits, joins and other bots' messages have a subtype or bot ID
+	message := event.Event
+	if event.Type != "event_callback" || message.Type != "message" || message.Subtype != "" || message.BotID != "" || handle == nil {
+		return
+	}
+	go func() {
+		name, err := s.channelName(message.Channel)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if name != s.channel && message.Channel != s.channel {
+			return
+		}
+		handle(ChatMessage{Channel: message.Channel, UserID: message.User, Text: message.Text})
+	}()
+}
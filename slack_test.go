@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSlack is the Slack Web API methods miriam calls, with a channel and
+// some users
+type fakeSlack struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	Posted   []map[string]string
+	Channels map[string]string
+	Users    map[string]string
+}
+
+func newFakeSlack() *fakeSlack {
+	f := &fakeSlack{Channels: map[string]string{"C1": "house-party", "C2": "random"}, Users: map[string]string{"U1": "matt", "U2": "sam"}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeSlack) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer xoxb-fake" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+		return
+	}
+	switch r.URL.Path {
+	case "/chat.postMessage":
+		var message map[string]string
+		json.NewDecoder(r.Body).Decode(&message)
+		f.Posted = append(f.Posted, message)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+	case "/users.info":
+		name, ok := f.Users[r.URL.Query().Get("user")]
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": ok, "error": "user_not_found", "user": map[string]string{"name": name}})
+	case "/conversations.info":
+		name, ok := f.Channels[r.URL.Query().Get("channel")]
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": ok, "error": "channel_not_found", "channel": map[string]string{"name": name}})
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "unknown_method"})
+	}
+}
+
+// Event delivers an Events API request to the adapter, signed with secret
+func (f *fakeSlack) Event(s *slackChat, secret string, body string) *httptest.ResponseRecorder {
+	timestamp := fmt.Sprint(s.clock.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	r := httptest.NewRequest("POST", chatEventsPath, strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestSlackChat(t *testing.T) {
+	slack := newFakeSlack()
+	defer slack.server.Close()
+	s := newSlackChat(&Config{SlackURL: slack.server.URL, SlackToken: "xoxb-fake", SlackSigningSecret: "shh", ChatChannel: "#house-party"})
+	s.clock = newFakeClock(time.Now())
+
+	if err := s.Send("", "I'm online"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send("goals", "Started goal"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]string{{"channel": "house-party", "text": "I'm online"}, {"channel": "goals", "text": "Started goal"}}
+	if fmt.Sprint(slack.Posted) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, slack.Posted)
+	}
+
+	if w := slack.Event(s, "shh", `{"type": "url_verification", "challenge": "abc"}`); w.Body.String() != "abc" {
+		t.Errorf("expected the challenge to be answered, got %q", w.Body.String())
+	}
+	if w := slack.Event(s, "wrong", `{"type": "url_verification", "challenge": "abc"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a bad signature to be refused, got %v", w.Code)
+	}
+
+	received := make(chan ChatMessage, 3)
+	s.Subscribe(func(msg ChatMessage) { received <- msg })
+	slack.Event(s, "shh", `{"type": "event_callback", "event": {"type": "message", "subtype": "bot_message", "channel": "C1", "text": "status"}}`)
+	slack.Event(s, "shh", `{"type": "event_callback", "event": {"type": "message", "channel": "C2", "user": "U1", "text": "status"}}`)
+	slack.Event(s, "shh", `{"type": "event_callback", "event": {"type": "message", "channel": "C1", "user": "U1", "text": "status"}}`)
+	select {
+	case msg := <-received:
+		if msg != (ChatMessage{Channel: "C1", UserID: "U1", Text: "status"}) {
+			t.Errorf("expected the message from house-party, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message to be delivered")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("expected only one message, got %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if name, err := s.LookupUser("U2"); err != nil || name != "sam" {
+		t.Errorf("expected U2 to be sam, got %v %v", name, err)
+	}
+	if _, err := s.LookupUser("U3"); err == nil || !strings.Contains(err.Error(), "user_not_found") {
+		t.Errorf("expected an unknown user to fail, got %v", err)
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/pkg/errors"
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// Snapshot is everything a run reads, loaded once before anything is
+// planned. The planner only ever sees these plain structs, and Now instead of
+// the clock.
+type Snapshot struct {
+	Now   time.Time
+	Inbox wunderlist.List
+	// GoalLists are the lists in goals-folder, only loaded with goal-lists.
+	// Tasks and everything on them are loaded from these and the inbox.
+	GoalLists []wunderlist.List
+	User      wunderlist.User
+	Tasks     []wunderlist.Task
+	// Notes in the inbox, only loaded with task-notes
+	Notes []wunderlist.Note
+	// Subtasks in the inbox, only loaded in the subtasks task-mode
+	Subtasks []wunderlist.Subtask
+	// Reminders in the inbox, only loaded when reminders are configured
+	Reminders []wunderlist.Reminder
+	// TaskComments in the inbox, only loaded with comment-sync
+	TaskComments []wunderlist.TaskComment
+	// CopiedTaskComments are the task comments the audit log has copies of
+	// on cards, so they aren't copied again once the copies are older than
+	// the card comments Trello returns. Only loaded with comment-sync.
+	CopiedTaskComments []uint
+	Backlog            Board
+	Goals              Board
+}
+
+// Board holds the lists, labels and cards of a board. Cards in excluded
+// lists are left out.
+type Board struct {
+	ID       string
+	ShortURL string
+	Lists    []List
+	Labels   []Label
+	Cards    []*Card
+}
+
+type List struct {
+	ID   string
+	Name string
+}
+
+type Label struct {
+	ID   string
+	Name string
+}
+
+type Card struct {
+	ID          string
+	Name        string
+	Desc        string
+	ShortURL    string
+	BoardID     string
+	ListID      string
+	Labels      []Label
+	Checklists  []*Checklist
+	Attachments []Attachment
+	// LastActivity is when the card last changed, zero if Trello didn't say
+	LastActivity time.Time
+	// EnteredList is when the card moved to its list. It's only loaded for
+	// lists with a stale-lists threshold, zero otherwise.
+	EnteredList time.Time
+	// Due is zero when the card has no due date
+	Due         time.Time
+	DueComplete bool
+	// Comments are only loaded for goals in progress with comment-sync
+	Comments []Comment
+}
+
+// Idle is how long the card has gone without changes
+func (card *Card) Idle(now time.Time) time.Duration {
+	if card.LastActivity.IsZero() {
+		return 0
+	}
+	return now.Sub(card.LastActivity)
+}
+
+type Comment struct {
+	ID     string
+	Author string
+	Text   string
+	Date   time.Time
+}
+
+type Attachment struct {
+	ID   string
+	Name string
+	URL  string
+	// Upload is a file uploaded to Trello rather than a link
+	Upload bool
+}
+
+type Checklist struct {
+	ID    string
+	Name  string
+	Items []CheckItem
+}
+
+// CheckItem is a checklist item. A due date hint like "@2026-11-01" in the
+// item's name in Trello is taken out of Name and kept as Due.
+type CheckItem struct {
+	ID          string
+	Name        string
+	ChecklistID string
+	Complete    bool
+	// Due is midnight UTC on the hinted date, zero without a hint
+	Due time.Time
+}
+
+// dueHint matches a due date in a checklist item's name
+var dueHint = regexp.MustCompile(`(^|\s)@(\d{4}-\d{2}-\d{2})(\s|$)`)
+
+// parseDueHint splits an item's name into the name without the due date
+// hint and the hinted date
+func parseDueHint(name string) (string, time.Time) {
+	m := dueHint.FindStringSubmatchIndex(name)
+	if m == nil {
+		return name, time.Time{}
+	}
+	due, err := time.Parse("2006-01-02", name[m[4]:m[5]])
+	if err != nil {
+		return name, time.Time{}
+	}
+	return strings.TrimSpace(name[:m[0]] + " " + name[m[1]:]), due
+}
+
+// State is the Trello name for whether an item is checked
+func (item CheckItem) State() string {
+	if item.Complete {
+		return "complete"
+	}
+	return "incomplete"
+}
+
+// loadSnapshot reads the inbox and both boards
+func (a *App) loadSnapshot() (*Snapshot, error) {
+	s := &Snapshot{Now: a.Clock.Now()}
+	var err error
+	s.Inbox, err = a.Tasks.Inbox()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading inbox")
+	}
+	s.User, err = a.Tasks.User()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading wunderlist user")
+	}
+	if a.Config.GoalLists {
+		if s.GoalLists, err = a.loadGoalLists(); err != nil {
+			return nil, errors.Wrap(err, "Error loading goal lists")
+		}
+	}
+	completed := 0
+	for _, list := range append([]wunderlist.List{s.Inbox}, s.GoalLists...) {
+		n, err := a.loadTaskList(s, list)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error loading list '%s'", list.Title)
+		}
+		completed += n
+	}
+	fmt.Printf("Found %v tasks (%v completed)\n", len(s.Tasks), completed)
+	if a.Config.CommentSync {
+		if s.CopiedTaskComments, err = a.copiedTaskComments(); err != nil {
+			return nil, err
+		}
+	}
+	if s.Backlog, err = a.loadBoard(a.Config.TrelloBacklog); err != nil {
+		return nil, errors.Wrapf(err, "Error loading backlog board %s", a.Config.TrelloBacklog)
+	}
+	if s.Goals, err = a.loadBoard(a.Config.TrelloGoals); err != nil {
+		return nil, errors.Wrapf(err, "Error loading goals board %s", a.Config.TrelloGoals)
+	}
+	return s, nil
+}
+
+// copiedTaskComments is the task comments copied to cards according to the
+// audit log, less the copies undo deleted
+func (a *App) copiedTaskComments() ([]uint, error) {
+	if a.Audit.Path == "" {
+		return nil, nil
+	}
+	entries, err := ReadAuditLog(a.Audit.Path)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	copies := map[string]uint{}
+	for _, entry := range entries {
+		if entry.Target.TaskCommentID == 0 {
+			continue
+		}
+		switch entry.Op {
+		case OpAddComment:
+			copies[entry.Target.CommentID] = entry.Target.TaskCommentID
+		case OpDeleteComment:
+			delete(copies, entry.Target.CommentID)
+		}
+	}
+	var copied []uint
+	for _, id := range copies {
+		copied = append(copied, id)
+	}
+	sort.Slice(copied, func(i, j int) bool { return copied[i] < copied[j] })
+	return copied, nil
+}
+
+// loadGoalLists is the lists in goals-folder, none if there's no such folder
+func (a *App) loadGoalLists() ([]wunderlist.List, error) {
+	folders, err := a.Tasks.Folders()
+	if err != nil {
+		return nil, err
+	}
+	folder := folderByTitle(folders, a.Config.GoalsFolder)
+	if folder == nil {
+		return nil, nil
+	}
+	lists, err := a.Tasks.Lists()
+	if err != nil {
+		return nil, err
+	}
+	var goalLists []wunderlist.List
+	for _, list := range lists {
+		for _, id := range folder.ListIDs {
+			if list.ID == id {
+				goalLists = append(goalLists, list)
+			}
+		}
+	}
+	return goalLists, nil
+}
+
+func folderByTitle(folders []wunderlist.Folder, title string) *wunderlist.Folder {
+	for i := range folders {
+		if folders[i].Title == title {
+			return &folders[i]
+		}
+	}
+	return nil
+}
+
+// loadTaskList adds a list's tasks, and their notes, subtasks, comments and
+// reminders when they're used, to the snapshot. It returns the number of
+// completed tasks.
+func (a *App) loadTaskList(s *Snapshot, list wunderlist.List) (int, error) {
+	open, err := a.Tasks.TasksForListID(list.ID)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error loading open tasks")
+	}
+	completed, err := a.Tasks.CompletedTasksForListID(list.ID, true)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error loading completed tasks")
+	}
+	s.Tasks = append(append(s.Tasks, open...), completed...)
+	if a.Config.TaskNotes {
+		notes, err := a.Tasks.NotesForListID(list.ID)
+		if err != nil {
+			return 0, errors.Wrap(err, "Error loading notes")
+		}
+		s.Notes = append(s.Notes, notes...)
+	}
+	if a.Config.TaskMode == TaskModeSubtasks {
+		subtasks, err := a.Tasks.SubtasksForListID(list.ID)
+		if err != nil {
+			return 0, errors.Wrap(err, "Error loading open subtasks")
+		}
+		completedSubtasks, err := a.Tasks.CompletedSubtasksForListID(list.ID, true)
+		if err != nil {
+			return 0, errors.Wrap(err, "Error loading completed subtasks")
+		}
+		s.Subtasks = append(append(s.Subtasks, subtasks...), completedSubtasks...)
+	}
+	if a.Config.CommentSync {
+		comments, err := a.Tasks.TaskCommentsForListID(list.ID)
+		if err != nil {
+			return 0, errors.Wrap(err, "Error loading task comments")
+		}
+		s.TaskComments = append(s.TaskComments, comments...)
+	}
+	if len(a.Config.Reminders) > 0 {
+		reminders, err := a.Tasks.RemindersForListID(list.ID)
+		if err != nil {
+			return 0, errors.Wrap(err, "Error loading reminders")
+		}
+		s.Reminders = append(s.Reminders, reminders...)
+	}
+	return len(completed), nil
+}
+
+func (a *App) loadBoard(boardID string) (Board, error) {
+	var board Board
+	thresholds, err := a.Config.StaleThresholds()
+	if err != nil {
+		return board, err
+	}
+	trelloBoard, err := a.Boards.GetBoard(boardID)
+	if err != nil {
+		return board, err
+	}
+	board.ID, board.ShortURL = trelloBoard.ID, trelloBoard.ShortUrl
+	labels, err := a.Boards.GetLabels(board.ID)
+	if err != nil {
+		return board, errors.Wrap(err, "Error loading labels")
+	}
+	for _, label := range labels {
+		board.Labels = append(board.Labels, Label{ID: label.ID, Name: label.Name})
+	}
+	lists, err := a.Boards.GetLists(board.ID)
+	if err != nil {
+		return board, errors.Wrap(err, "Error loading lists")
+	}
+	for _, list := range lists {
+		board.Lists = append(board.Lists, List{ID: list.ID, Name: list.Name})
+		if a.isExcludedList(list.Name) {
+			continue
+		}
+		cards, err := a.Boards.GetListCards(list.ID, trello.Arguments{"checklists": "all", "attachments": "true"})
+		if err != nil {
+			return board, errors.Wrapf(err, "Error loading cards for list %s", list.Name)
+		}
+		for _, card := range cards {
+			c := snapshotCard(card)
+			if thresholds[list.Name] > 0 {
+				actions, err := a.Boards.GetCardActions(card.ID, trello.Arguments{"filter": listChangeFilter})
+				if err != nil {
+					return board, errors.Wrapf(err, "Error loading the history of card %s", card.Name)
+				}
+				c.EnteredList = enteredList(actions)
+			}
+			if a.Config.CommentSync && boardID == a.Config.TrelloGoals && list.Name == a.Config.InProgressList {
+				// Trello returns 50 actions unless asked for more
+				actions, err := a.Boards.GetCardActions(card.ID, trello.Arguments{"filter": "commentCard", "limit": "1000"})
+				if err != nil {
+					return board, errors.Wrapf(err, "Error loading the comments on card %s", card.Name)
+				}
+				c.Comments = cardComments(actions)
+			}
+			board.Cards = append(board.Cards, c)
+		}
+	}
+	return board, nil
+}
+
+func snapshotCard(card *trello.Card) *Card {
+	c := &Card{ID: card.ID, Name: card.Name, Desc: card.Desc, ShortURL: card.ShortUrl, BoardID: card.IDBoard, ListID: card.IDList}
+	if card.DateLastActivity != nil {
+		c.LastActivity = *card.DateLastActivity
+	}
+	if card.Due != nil {
+		c.Due, c.DueComplete = *card.Due, card.DueComplete
+	}
+	for _, label := range card.Labels {
+		c.Labels = append(c.Labels, Label{ID: label.ID, Name: label.Name})
+	}
+	for _, attachment := range card.Attachments {
+		c.Attachments = append(c.Attachments, Attachment{ID: attachment.ID, Name: attachment.Name, URL: attachment.URL, Upload: attachment.IsUpload})
+	}
+	for _, checklist := range card.Checklists {
+		cl := &Checklist{ID: checklist.ID, Name: checklist.Name}
+		for _, item := range checklist.CheckItems {
+			name, due := parseDueHint(item.Name)
+			cl.Items = append(cl.Items, CheckItem{ID: item.ID, Name: name, ChecklistID: checklist.ID, Complete: item.State == "complete", Due: due})
+		}
+		c.Checklists = append(c.Checklists, cl)
+	}
+	return c
+}
+
+// listChangeFilter asks for the actions that move a card to a list
+const listChangeFilter = "createCard,copyCard,convertToCardFromCheckItem,moveCardToBoard,updateCard:idList,updateCard:closed"
+
+// enteredList is the time of the latest action that put the card in a list
+func enteredList(actions trello.ActionCollection) time.Time {
+	var entered time.Time
+	for _, action := range actions {
+		if (action.DidChangeListForCard() || action.Type == "moveCardToBoard") && action.Date.After(entered) {
+			entered = action.Date
+		}
+	}
+	return entered
+}
+
+// cardComments is the comments among a card's actions, oldest first
+func cardComments(actions trello.ActionCollection) []Comment {
+	var comments []Comment
+	for i := len(actions) - 1; i >= 0; i-- {
+		action := actions[i]
+		if action.Type != "commentCard" || action.Data == nil {
+			continue
+		}
+		comment := Comment{ID: action.ID, Text: action.Data.Text, Date: action.Date}
+		if action.MemberCreator != nil {
+			comment.Author = action.MemberCreator.FullName
+		}
+		comments = append(comments, comment)
+	}
+	return comments
+}
+
+// TaskCommentsOf is the comments on a task
+func (s *Snapshot) TaskCommentsOf(taskID uint) []wunderlist.TaskComment {
+	var comments []wunderlist.TaskComment
+	for _, comment := range s.TaskComments {
+		if comment.TaskID == taskID {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+// GoalList is the goal list with the title, nil if there isn't one. A list
+// created earlier in the plan has an ID of 0.
+func (s *Snapshot) GoalList(title string) *wunderlist.List {
+	for i := range s.GoalLists {
+		if s.GoalLists[i].Title == title {
+			return &s.GoalLists[i]
+		}
+	}
+	return nil
+}
+
+// NoteOf is a task's note. Wunderlist keeps one per task.
+func (s *Snapshot) NoteOf(taskID uint) (wunderlist.Note, bool) {
+	for _, note := range s.Notes {
+		if note.TaskID == taskID {
+			return note, true
+		}
+	}
+	return wunderlist.Note{}, false
+}
+
+// SubtasksOf is the subtasks of a task. Subtasks planned for a task created
+// earlier in the plan have a TaskID of 0, like the task.
+func (s *Snapshot) SubtasksOf(taskID uint) []wunderlist.Subtask {
+	var subtasks []wunderlist.Subtask
+	for _, subtask := range s.Subtasks {
+		if subtask.TaskID == taskID {
+			subtasks = append(subtasks, subtask)
+		}
+	}
+	return subtasks
+}
+
+// RemindersFor is the reminders set on a task
+func (s *Snapshot) RemindersFor(taskID uint) []wunderlist.Reminder {
+	var reminders []wunderlist.Reminder
+	for _, reminder := range s.Reminders {
+		if reminder.TaskID == taskID {
+			reminders = append(reminders, reminder)
+		}
+	}
+	return reminders
+}
+
+// Clone is a deep copy, so the planner can change it without touching the
+// snapshot it was given
+func (s *Snapshot) Clone() *Snapshot {
+	c := *s
+	c.GoalLists = append([]wunderlist.List(nil), s.GoalLists...)
+	c.Tasks = append([]wunderlist.Task(nil), s.Tasks...)
+	c.Notes = append([]wunderlist.Note(nil), s.Notes...)
+	c.Subtasks = append([]wunderlist.Subtask(nil), s.Subtasks...)
+	c.Reminders = append([]wunderlist.Reminder(nil), s.Reminders...)
+	c.TaskComments = append([]wunderlist.TaskComment(nil), s.TaskComments...)
+	c.CopiedTaskComments = append([]uint(nil), s.CopiedTaskComments...)
+	c.Backlog = s.Backlog.clone()
+	c.Goals = s.Goals.clone()
+	return &c
+}
+
+func (b Board) clone() Board {
+	c := b
+	c.Lists = append([]List(nil), b.Lists...)
+	c.Labels = append([]Label(nil), b.Labels...)
+	c.Cards = nil
+	for _, card := range b.Cards {
+		c.Cards = append(c.Cards, card.clone())
+	}
+	return c
+}
+
+func (card *Card) clone() *Card {
+	c := *card
+	c.Labels = append([]Label(nil), card.Labels...)
+	c.Attachments = append([]Attachment(nil), card.Attachments...)
+	c.Comments = append([]Comment(nil), card.Comments...)
+	c.Checklists = nil
+	for _, checklist := range card.Checklists {
+		cl := *checklist
+		cl.Items = append([]CheckItem(nil), checklist.Items...)
+		c.Checklists = append(c.Checklists, &cl)
+	}
+	return &c
+}
+
+// ListByName returns the list with the given name, nil if there isn't one
+func (b *Board) ListByName(name string) *List {
+	for i := range b.Lists {
+		if b.Lists[i].Name == name {
+			return &b.Lists[i]
+		}
+	}
+	return nil
+}
+
+func (b *Board) LabelByName(name string) *Label {
+	for i := range b.Labels {
+		if b.Labels[i].Name == name {
+			return &b.Labels[i]
+		}
+	}
+	return nil
+}
+
+// CardsIn returns the cards in a list, in order
+func (b *Board) CardsIn(listID string) []*Card {
+	var cards []*Card
+	for _, card := range b.Cards {
+		if card.ListID == listID {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+func (card *Card) HasLabel(name string) bool {
+	for _, label := range card.Labels {
+		if label.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAttachment is true when the card has an attachment with the URL
+func (card *Card) HasAttachment(url string) bool {
+	for _, attachment := range card.Attachments {
+		if attachment.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// HasComment is true when the card has a comment with exactly the text
+func (card *Card) HasComment(text string) bool {
+	for _, comment := range card.Comments {
+		if comment.Text == text {
+			return true
+		}
+	}
+	return false
+}
+
+func (card *Card) Checklist(name string) *Checklist {
+	for _, checklist := range card.Checklists {
+		if checklist.Name == name {
+			return checklist
+		}
+	}
+	return nil
+}
+
+// Items returns the checked and unchecked items of a checklist
+func (card *Card) Items(name string) ([]CheckItem, []CheckItem) {
+	var checked, unchecked []CheckItem
+	if checklist := card.Checklist(name); checklist != nil {
+		for _, item := range checklist.Items {
+			if item.Complete {
+				checked = append(checked, item)
+			} else {
+				unchecked = append(unchecked, item)
+			}
+		}
+	}
+	return checked, unchecked
+}
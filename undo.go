@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adlio/trello"
+	"github.com/pkg/errors"
+	wunderlist "github.com/robdimsdale/wl"
+)
+
+// undoOp is the inverse of a single audit entry
+type undoOp struct {
+	Entry       AuditEntry
+	Description string
+	Apply       func() error
+}
+
+// selectUndoEntries returns the entries to revert, either all entries from
+// one run or everything since a point in time. Entries written by a previous
+// undo are only included when selected by run ID.
+func selectUndoEntries(entries []AuditEntry, runID string, since time.Time) []AuditEntry {
+	var selected []AuditEntry
+	for _, entry := range entries {
+		if runID != "" {
+			if entry.RunID == runID {
+				selected = append(selected, entry)
+			}
+			continue
+		}
+		if entry.Rule != "undo" && !entry.Time.Before(since) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// parseSince accepts either a timestamp or a duration before now
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Could not parse '%s' as a time or duration", value)
+}
+
+func decodeAuditValue(raw json.RawMessage, value interface{}) error {
+	if len(raw) == 0 {
+		return errors.New("audit entry is missing the value needed to undo it")
+	}
+	return json.Unmarshal(raw, value)
+}
+
+// inverseOf computes the operation that reverts an audit entry. lists maps
+// deleted lists to the ones recreated in their place while undoing, so tasks
+// deleted with a list are recreated in the new one.
+func (a *App) inverseOf(entry AuditEntry, lists map[uint]uint) (*undoOp, error) {
+	target := entry.Target
+	op := &undoOp{Entry: entry}
+	switch entry.Op {
+	case OpCreateChecklist:
+		op.Description = fmt.Sprintf("Delete checklist %s from card '%s'", target.ChecklistID, target.CardName)
+		op.Apply = func() error {
+			if err := a.Boards.DeleteChecklist(target.ChecklistID); err != nil {
+				return errors.Wrapf(err, "Error deleting checklist %s", target.ChecklistID)
+			}
+			a.Audit.record("undo", OpDeleteChecklist, target, entry.After, nil)
+			return nil
+		}
+	case OpAddLabel:
+		op.Description = fmt.Sprintf("Remove label '%s' from card '%s'", target.LabelName, target.CardName)
+		op.Apply = func() error {
+			if err := a.Boards.RemoveLabel(target.CardID, target.LabelID); err != nil {
+				return errors.Wrapf(err, "Error removing label %s from card %s", target.LabelName, target.CardID)
+			}
+			a.Audit.record("undo", OpRemoveLabel, target, entry.After, nil)
+			return nil
+		}
+	case OpRemoveLabel:
+		op.Description = fmt.Sprintf("Re-add label '%s' to card '%s'", target.LabelName, target.CardName)
+		op.Apply = func() error {
+			if err := a.Boards.AddLabel(target.CardID, target.LabelID); err != nil {
+				return errors.Wrapf(err, "Error adding label %s to card %s", target.LabelName, target.CardID)
+			}
+			a.Audit.record("undo", OpAddLabel, target, nil, entry.Before)
+			return nil
+		}
+	case OpAddAttachment:
+		op.Description = fmt.Sprintf("Delete attachment %s from card '%s'", target.AttachmentID, target.CardName)
+		op.Apply = func() error {
+			if err := a.Boards.DeleteAttachment(target.CardID, target.AttachmentID); err != nil {
+				return errors.Wrapf(err, "Error deleting attachment %s", target.AttachmentID)
+			}
+			a.Audit.record("undo", OpDeleteAttachment, target, entry.After, nil)
+			return nil
+		}
+	case OpAddComment:
+		op.Description = fmt.Sprintf("Delete comment %s from card '%s'", target.CommentID, target.CardName)
+		op.Apply = func() error {
+			if err := a.Boards.DeleteComment(target.CommentID); err != nil {
+				return errors.Wrapf(err, "Error deleting comment %s", target.CommentID)
+			}
+			a.Audit.record("undo", OpDeleteComment, target, entry.After, nil)
+			return nil
+		}
+	case OpMoveCardToList, OpMoveCardToBoard:
+		var before map[string]string
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		args := trello.Arguments{"idList": before["idList"]}
+		if entry.Op == OpMoveCardToBoard {
+			args["idBoard"] = before["idBoard"]
+			op.Description = fmt.Sprintf("Move card '%s' back to board %s", target.CardName, before["idBoard"])
+		} else {
+			op.Description = fmt.Sprintf("Move card '%s' back to list %s", target.CardName, before["idList"])
+		}
+		op.Apply = func() error {
+			if _, err := a.Boards.UpdateCard(target.CardID, args); err != nil {
+				return errors.Wrapf(err, "Error moving card %s back", target.CardID)
+			}
+			a.Audit.record("undo", entry.Op, AuditTarget{CardID: target.CardID, CardName: target.CardName, BoardID: before["idBoard"], ListID: before["idList"]}, entry.After, before)
+			return nil
+		}
+	case OpMoveCheckItem, OpMarkCheckItem:
+		var before trello.CheckItem
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		args := trello.Arguments{"idChecklist": before.IDChecklist}
+		op.Description = fmt.Sprintf("Move checklist item '%s' back to checklist %s", before.Name, before.IDChecklist)
+		if entry.Op == OpMarkCheckItem {
+			args = trello.Arguments{"state": before.State}
+			op.Description = fmt.Sprintf("Mark checklist item '%s' as %s", before.Name, before.State)
+		}
+		op.Apply = func() error {
+			item, err := a.Boards.UpdateCheckItem(target.CardID, target.CheckItemID, args)
+			if err != nil {
+				return errors.Wrapf(err, "Error reverting checklist item '%s'", before.Name)
+			}
+			a.Audit.record("undo", entry.Op, target, entry.After, item)
+			return nil
+		}
+	case OpUpdateCard:
+		var before map[string]string
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Restore card '%s'", target.CardName)
+		op.Apply = func() error {
+			if _, err := a.Boards.UpdateCard(target.CardID, trello.Arguments(before)); err != nil {
+				return errors.Wrapf(err, "Error restoring card %s", target.CardID)
+			}
+			a.Audit.record("undo", OpUpdateCard, target, entry.After, before)
+			return nil
+		}
+	case OpCreateCard:
+		op.Description = fmt.Sprintf("Archive card '%s'", target.CardName)
+		op.Apply = func() error {
+			if _, err := a.Boards.UpdateCard(target.CardID, trello.Arguments{"closed": "true"}); err != nil {
+				return errors.Wrapf(err, "Error archiving card %s", target.CardID)
+			}
+			a.Audit.record("undo", OpArchiveCard, target, entry.After, nil)
+			return nil
+		}
+	case OpCreateList:
+		var created wunderlist.List
+		if err := decodeAuditValue(entry.After, &created); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Delete list '%s'", created.Title)
+		op.Apply = func() error {
+			current, err := a.Tasks.List(created.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading list '%s'", created.Title)
+			}
+			if err := a.Tasks.DeleteList(current); err != nil {
+				return errors.Wrapf(err, "Error deleting list '%s'", created.Title)
+			}
+			a.Audit.record("undo", OpDeleteList, target, current, nil)
+			return nil
+		}
+	case OpDeleteList:
+		var deleted wunderlist.List
+		if err := decodeAuditValue(entry.Before, &deleted); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Recreate list '%s'", deleted.Title)
+		op.Apply = func() error {
+			list, err := a.Tasks.CreateList(jsonString(deleted.Title))
+			if err != nil {
+				return errors.Wrapf(err, "Error recreating list '%s'", deleted.Title)
+			}
+			a.Audit.record("undo", OpCreateList, AuditTarget{TaskListID: list.ID}, nil, list)
+			lists[deleted.ID] = list.ID
+			if target.FolderID == 0 {
+				return nil
+			}
+			folder, err := a.Tasks.Folder(target.FolderID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading folder %d", target.FolderID)
+			}
+			restored := folder
+			restored.ListIDs = append(append([]uint(nil), folder.ListIDs...), list.ID)
+			return a.updateFolder(folder, restored, "undo")
+		}
+	case OpCreateFolder:
+		var created wunderlist.Folder
+		if err := decodeAuditValue(entry.After, &created); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Delete folder '%s'", created.Title)
+		op.Apply = func() error {
+			current, err := a.Tasks.Folder(created.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading folder '%s'", created.Title)
+			}
+			if err := a.Tasks.DeleteFolder(current); err != nil {
+				return errors.Wrapf(err, "Error deleting folder '%s'", created.Title)
+			}
+			a.Audit.record("undo", OpDeleteFolder, target, current, nil)
+			return nil
+		}
+	case OpUpdateFolder:
+		var before wunderlist.Folder
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Restore the lists in folder '%s'", before.Title)
+		op.Apply = func() error {
+			current, err := a.Tasks.Folder(before.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading folder '%s'", before.Title)
+			}
+			restored := current
+			restored.ListIDs = before.ListIDs
+			return a.updateFolder(current, restored, "undo")
+		}
+	case OpCreateTask:
+		var created wunderlist.Task
+		if err := decodeAuditValue(entry.After, &created); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Delete task '%s'", created.Title)
+		op.Apply = func() error {
+			// Deleting needs the current revision
+			current, err := a.Tasks.Task(created.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading task '%s'", created.Title)
+			}
+			return a.deleteTask(current, "undo")
+		}
+	case OpUpdateTask:
+		var before wunderlist.Task
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Restore task '%s'", before.Title)
+		if !before.Completed {
+			op.Description = fmt.Sprintf("Reopen task '%s'", before.Title)
+		}
+		op.Apply = func() error {
+			current, err := a.Tasks.Task(before.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading task '%s'", before.Title)
+			}
+			restored := before
+			restored.Revision = current.Revision
+			_, err = a.updateTask(current, restored, "undo")
+			return err
+		}
+	case OpDeleteTask:
+		var deleted wunderlist.Task
+		if err := decodeAuditValue(entry.Before, &deleted); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Recreate task '%s'", deleted.Title)
+		op.Apply = func() error {
+			listID := deleted.ListID
+			if id, ok := lists[listID]; ok {
+				listID = id
+			}
+			task, err := a.Tasks.CreateTask(deleted.Title, listID, deleted.AssigneeID, deleted.Completed, deleted.RecurrenceType, deleted.RecurrenceCount, deleted.DueDate, deleted.Starred)
+			if err != nil {
+				return errors.Wrapf(err, "Error recreating task '%s'", deleted.Title)
+			}
+			a.Audit.record("undo", OpCreateTask, AuditTarget{TaskID: task.ID}, nil, task)
+			return nil
+		}
+	case OpCreateTaskComment:
+		op.Description = fmt.Sprintf("Delete comment %d from task %d", target.TaskCommentID, target.TaskID)
+		op.Apply = func() error {
+			current, err := a.Tasks.TaskComment(target.TaskCommentID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading task comment %d", target.TaskCommentID)
+			}
+			if err := a.Tasks.DeleteTaskComment(current); err != nil {
+				return errors.Wrapf(err, "Error deleting task comment %d", current.ID)
+			}
+			a.Audit.record("undo", OpDeleteTaskComment, target, current, nil)
+			return nil
+		}
+	case OpCreateNote:
+		var created wunderlist.Note
+		if err := decodeAuditValue(entry.After, &created); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Delete the note of task %d", created.TaskID)
+		op.Apply = func() error {
+			current, err := a.Tasks.Note(created.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading note %d", created.ID)
+			}
+			return a.deleteNote(current, "undo")
+		}
+	case OpUpdateNote:
+		var before wunderlist.Note
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Restore the note of task %d", before.TaskID)
+		op.Apply = func() error {
+			current, err := a.Tasks.Note(before.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading note %d", before.ID)
+			}
+			restored := current
+			restored.Content = before.Content
+			_, err = a.updateNote(current, restored, "undo")
+			return err
+		}
+	case OpDeleteNote:
+		var deleted wunderlist.Note
+		if err := decodeAuditValue(entry.Before, &deleted); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Recreate the note of task %d", deleted.TaskID)
+		op.Apply = func() error {
+			note, err := a.Tasks.CreateNote(jsonString(deleted.Content), deleted.TaskID)
+			if err != nil {
+				return errors.Wrapf(err, "Error recreating the note of task %d", deleted.TaskID)
+			}
+			a.Audit.record("undo", OpCreateNote, AuditTarget{TaskID: note.TaskID, NoteID: note.ID}, nil, note)
+			return nil
+		}
+	case OpCreateSubtask:
+		var created wunderlist.Subtask
+		if err := decodeAuditValue(entry.After, &created); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Delete subtask '%s'", created.Title)
+		op.Apply = func() error {
+			current, err := a.Tasks.Subtask(created.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading subtask '%s'", created.Title)
+			}
+			return a.deleteSubtask(current, "undo")
+		}
+	case OpUpdateSubtask:
+		var before wunderlist.Subtask
+		if err := decodeAuditValue(entry.Before, &before); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Restore subtask '%s'", before.Title)
+		if !before.Completed {
+			op.Description = fmt.Sprintf("Reopen subtask '%s'", before.Title)
+		}
+		op.Apply = func() error {
+			current, err := a.Tasks.Subtask(before.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading subtask '%s'", before.Title)
+			}
+			restored := before
+			restored.Revision = current.Revision
+			_, err = a.updateSubtask(current, restored, "undo")
+			return err
+		}
+	case OpDeleteSubtask:
+		var deleted wunderlist.Subtask
+		if err := decodeAuditValue(entry.Before, &deleted); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Recreate subtask '%s'", deleted.Title)
+		op.Apply = func() error {
+			subtask, err := a.Tasks.CreateSubtask(jsonString(deleted.Title), deleted.TaskID, deleted.Completed)
+			if err != nil {
+				return errors.Wrapf(err, "Error recreating subtask '%s'", deleted.Title)
+			}
+			a.Audit.record("undo", OpCreateSubtask, AuditTarget{TaskID: subtask.TaskID, SubtaskID: subtask.ID}, nil, subtask)
+			return nil
+		}
+	case OpCreateReminder:
+		var created wunderlist.Reminder
+		if err := decodeAuditValue(entry.After, &created); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Remove reminder %d from task %d", created.ID, created.TaskID)
+		op.Apply = func() error {
+			// Deleting needs the current revision
+			current, err := a.Tasks.Reminder(created.ID)
+			if err != nil {
+				return errors.Wrapf(err, "Error loading reminder %d", created.ID)
+			}
+			if err := a.Tasks.DeleteReminder(current); err != nil {
+				return errors.Wrapf(err, "Error removing reminder %d", created.ID)
+			}
+			a.Audit.record("undo", OpDeleteReminder, target, current, nil)
+			return nil
+		}
+	case OpDeleteReminder:
+		var deleted wunderlist.Reminder
+		if err := decodeAuditValue(entry.Before, &deleted); err != nil {
+			return nil, err
+		}
+		op.Description = fmt.Sprintf("Restore the reminder on task %d at %s", deleted.TaskID, deleted.Date)
+		op.Apply = func() error {
+			reminder, err := a.Tasks.CreateReminder(deleted.Date, deleted.TaskID, "")
+			if err != nil {
+				return errors.Wrapf(err, "Error restoring the reminder on task %d", deleted.TaskID)
+			}
+			a.Audit.record("undo", OpCreateReminder, AuditTarget{TaskID: reminder.TaskID, ReminderID: reminder.ID}, nil, reminder)
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("Don't know how to undo '%s'", entry.Op)
+	}
+	return op, nil
+}
+
+// planUndo builds the inverse operations, newest first
+func (a *App) planUndo(entries []AuditEntry) ([]*undoOp, []error) {
+	var ops []*undoOp
+	var errs []error
+	lists := map[uint]uint{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		op, err := a.inverseOf(entries[i], lists)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Skipping %s from run %s", entries[i].Op, entries[i].RunID))
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops, errs
+}
+
+func undoCommand(args []string) error {
+	flags := flag.NewFlagSet("undo", flag.ExitOnError)
+	runID := flags.String("run", "", "Revert every mutation from this run ID")
+	since := flags.String("since", "", "Revert every mutation since this time (RFC3339 or a duration like 2h)")
+	dryRun := flags.Bool("dry-run", false, "Print the inverse operations without applying them")
+	flags.Parse(args)
+
+	if (*runID == "") == (*since == "") {
+		return errors.New("Exactly one of --run or --since is required")
+	}
+	app, err := loadApp()
+	if err != nil {
+		return err
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		t, err := parseSince(*since, app.Clock.Now())
+		if err != nil {
+			return err
+		}
+		sinceTime = t
+	}
+
+	entries, err := ReadAuditLog(app.Audit.Path)
+	if err != nil {
+		return err
+	}
+	selected := selectUndoEntries(entries, *runID, sinceTime)
+	if len(selected) == 0 {
+		fmt.Println("Nothing to undo")
+		return nil
+	}
+
+	ops, errs := app.planUndo(selected)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	undoRunID := app.Audit.StartRun()
+	fmt.Printf("Undoing %v mutations (undo run %v)\n", len(ops), undoRunID)
+	failed := 0
+	for _, op := range ops {
+		fmt.Printf("    %v\n", op.Description)
+		if *dryRun {
+			continue
+		}
+		if err := op.Apply(); err != nil {
+			log.Println(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d inverse operations failed", failed, len(ops))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSelectUndoEntries(t *testing.T) {
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{Time: start, RunID: "run1", Rule: "label-hygiene", Op: OpAddLabel},
+		{Time: start.Add(time.Hour), RunID: "run2", Rule: "planned-move", Op: OpMoveCardToBoard},
+		{Time: start.Add(2 * time.Hour), RunID: "run3", Rule: "undo", Op: OpMoveCardToBoard},
+	}
+	if selected := selectUndoEntries(entries, "run2", time.Time{}); len(selected) != 1 || selected[0].RunID != "run2" {
+		t.Errorf("expected only run2, got %+v", selected)
+	}
+	if selected := selectUndoEntries(entries, "", start.Add(30*time.Minute)); len(selected) != 1 || selected[0].RunID != "run2" {
+		t.Errorf("expected run2 and no undo entries, got %+v", selected)
+	}
+	if selected := selectUndoEntries(entries, "run3", time.Time{}); len(selected) != 1 {
+		t.Errorf("expected undo run to be selectable by ID, got %+v", selected)
+	}
+}
+
+func TestPlanUndoReversesOrder(t *testing.T) {
+	entries := []AuditEntry{
+		{RunID: "run1", Op: OpRemoveLabel, Target: AuditTarget{CardID: "card1", LabelID: "planned", LabelName: "Planned"}},
+		{RunID: "run1", Op: OpMoveCardToBoard, Target: AuditTarget{CardID: "card1", BoardID: "goals"}, Before: json.RawMessage(`{"idBoard":"backlog","idList":"todo"}`)},
+		{RunID: "run1", Op: OpMoveCardToList},
+	}
+	ops, errs := (&App{}).planUndo(entries)
+	if len(errs) != 1 {
+		t.Errorf("expected the entry without a before value to be skipped, got %v", errs)
+	}
+	if len(ops) != 2 {
+		t.Fatalf("expected 2 inverse operations, got %d", len(ops))
+	}
+	if ops[0].Entry.Op != OpMoveCardToBoard || ops[1].Entry.Op != OpRemoveLabel {
+		t.Errorf("expected inverse operations newest first, got %s then %s", ops[0].Entry.Op, ops[1].Entry.Op)
+	}
+	if ops[0].Description != "Move card '' back to board backlog" {
+		t.Errorf("unexpected description %q", ops[0].Description)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	if since, err := parseSince("2h", now); err != nil || !since.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("unexpected result %v %v", since, err)
+	}
+	since, err := parseSince("2026-10-01T09:00:00Z", now)
+	if err != nil || !since.Equal(time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected result %v %v", since, err)
+	}
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Error("expected an error for an unparseable time")
+	}
+}
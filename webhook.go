@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// webhookChat posts messages to an incoming webhook URL as JSON with the
+// channel and text. It can't receive anything, so there are no commands.
+type webhookChat struct {
+	url     string
+	channel string
+	client  *http.Client
+}
+
+func newWebhookChat(c *Config) *webhookChat {
+	return &webhookChat{url: c.WebhookURL, channel: c.ChatChannel, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (h *webhookChat) Send(channel string, text string) error {
+	if channel == "" {
+		channel = h.channel
+	}
+	body, err := json.Marshal(map[string]string{"channel": channel, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Error calling the chat webhook")
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("The chat webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (h *webhookChat) Subscribe(handle func(ChatMessage)) error {
+	return fmt.Errorf("Webhook chat is outbound only, chat commands are not available")
+}
+
+func (h *webhookChat) LookupUser(userID string) (string, error) {
+	return userID, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookChat(t *testing.T) {
+	var received []map[string]string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]string
+		json.NewDecoder(r.Body).Decode(&message)
+		received = append(received, message)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	h := newWebhookChat(&Config{WebhookURL: server.URL, ChatChannel: "house-party"})
+
+	if err := h.Send("", "Started goal"); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0]["channel"] != "house-party" || received[0]["text"] != "Started goal" {
+		t.Errorf("expected the message in house-party, got %v", received)
+	}
+	status = http.StatusNotFound
+	if err := h.Send("goals", "Started goal"); err == nil {
+		t.Error("expected an error status to fail")
+	}
+	if err := h.Subscribe(func(ChatMessage) {}); err == nil {
+		t.Error("expected webhooks to be outbound only")
+	}
+}